@@ -15,29 +15,21 @@
 package main
 
 import (
-	"bytes"
-	"encoding/binary"
 	"flag"
 	"fmt"
 	"io"
 	"os"
-	"strconv"
 
-	"github.com/googlecloudplatform/pi-delivery/pkg/ycd"
+	"github.com/googlecloudplatform/pi-delivery/pkg/unpack"
 )
 
-const zeros = "0000000000000000000"
-
 func main() {
 	radix := flag.Int("r", 10, "radix")
 	blockSize := flag.Int("b", 100, "block size")
 	flag.Parse()
 
-	dpw := ycd.DigitsPerWord(*radix)
-
 	block := make([]byte, *blockSize)
-	uw := make([]byte, dpw)
-	bin := make([]byte, 8)
+	packed := make([]byte, unpack.PackedLen(int64(*blockSize), *radix))
 	for {
 		n, err := io.ReadFull(os.Stdin, block)
 		if err != nil && err != io.ErrUnexpectedEOF {
@@ -47,23 +39,13 @@ func main() {
 			break
 		}
 
-		rd := bytes.NewReader(block[:n])
-		for {
-			copy(uw, zeros)
-			_, err := io.ReadFull(rd, uw)
-			if err != nil && err != io.ErrUnexpectedEOF {
-				if err != io.EOF {
-					fmt.Fprintf(os.Stderr, "ReadFull: %v", err)
-				}
-				break
-			}
-			word, err := strconv.ParseUint(string(uw), *radix, 64)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "ParseUint: %v", err)
-				break
-			}
-			binary.LittleEndian.PutUint64(bin, word)
-			for _, v := range bin {
+		pn, err := unpack.PackBlock(packed, block[:n], *radix)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "PackBlock: %v", err)
+			break
+		}
+		for i := 0; i < pn; i += unpack.WordSize {
+			for _, v := range packed[i : i+unpack.WordSize] {
 				fmt.Fprintf(os.Stdout, "0x%02x, ", v)
 			}
 			fmt.Fprintln(os.Stdout)
@@ -0,0 +1,56 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/googlecloudplatform/pi-delivery/pkg/ycd"
+)
+
+func main() {
+	radix := flag.Int("r", 10, "radix")
+	blockSize := flag.Int64("b", 1_000_000, "block size")
+	outDir := flag.String("o", ".", "output directory")
+	prefix := flag.String("prefix", "", "file name prefix (default \"Pi - Dec - Chudnovsky\" or \"Pi - Hex - Chudnovsky\")")
+	flag.Parse()
+
+	if *prefix == "" {
+		*prefix = "Pi - Dec - Chudnovsky"
+		if *radix == 16 {
+			*prefix = "Pi - Hex - Chudnovsky"
+		}
+	}
+
+	enc, err := ycd.NewEncoder(*radix, *blockSize, func(blockID int64) (string, io.WriteCloser, error) {
+		name := ycd.FileName(*prefix, blockID)
+		f, err := os.Create(filepath.Join(*outDir, name))
+		return name, f, err
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "NewEncoder: %v\n", err)
+		os.Exit(1)
+	}
+	files, err := enc.Encode(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Encode: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d files\n", len(files))
+}
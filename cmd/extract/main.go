@@ -22,7 +22,8 @@ import (
 	"os"
 
 	"github.com/googlecloudplatform/pi-delivery/gen/index"
-	"github.com/googlecloudplatform/pi-delivery/pkg/obj/gcs"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/backend"
+	"github.com/googlecloudplatform/pi-delivery/pkg/resultset"
 	"github.com/googlecloudplatform/pi-delivery/pkg/unpack"
 )
 
@@ -31,6 +32,12 @@ func main() {
 	n := flag.Int64("n", 100, "Number of digits to read")
 	outfile := flag.String("o", "-", "Output file")
 	useReadAt := flag.Bool("a", false, "Use ReadAt")
+	depth := flag.Int("depth", resultset.DefaultPrefetchDepth, "Number of range requests in flight ahead of the reader")
+	chunkSize := flag.Int("chunk", resultset.DefaultPrefetchChunkSize, "Bytes per range request")
+	parallelism := flag.Int("p", 8, "Number of concurrent range requests per ReadAt")
+	bucketName := flag.String("bucket", index.BucketName, "bucket name")
+	var storage backend.Options
+	storage.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
 	if *n <= 0 {
@@ -52,29 +59,49 @@ func main() {
 	}
 
 	ctx := context.Background()
-	sc, err := gcs.NewClient(ctx)
+	sc, err := backend.NewClient(ctx, storage)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "couldn't initialize storage client: %v\n", err)
 		os.Exit(1)
 	}
 	defer sc.Close()
 
-	unpackReader := unpack.NewReader(ctx, index.Decimal.NewReader(ctx, sc.Bucket(index.BucketName)))
-
+	bucket := sc.Bucket(*bucketName)
 	var reader io.Reader
+	var buf []byte
+	var retryStats func() resultset.RetryStats
 	if *useReadAt {
-		reader = io.NewSectionReader(unpackReader, *start, *n)
+		rrd := index.Decimal.NewReaderWithOptions(ctx, bucket, resultset.ReaderOptions{
+			Parallelism: *parallelism,
+			PartSize:    int64(*chunkSize),
+		})
+		defer rrd.Close()
+		retryStats = rrd.RetryStats
+		reader = io.NewSectionReader(unpack.NewReader(ctx, rrd), *start, *n)
+		// Large enough for each ReadAt to keep all the requests busy.
+		buf = make([]byte, *chunkSize**parallelism)
 	} else {
+		rrd := index.Decimal.NewPrefetchReader(ctx, bucket, resultset.PrefetchOptions{
+			Depth:     *depth,
+			ChunkSize: *chunkSize,
+		})
+		defer rrd.Close()
+		retryStats = rrd.RetryStats
+		unpackReader := unpack.NewReader(ctx, rrd)
 		if _, err := unpackReader.Seek(*start, io.SeekStart); err != nil {
 			fmt.Fprintf(os.Stderr, "seek failed: %v\n", err)
 			os.Exit(1)
 		}
-		reader = unpackReader
+		reader = io.LimitReader(unpackReader, *n)
+	}
+	written, err := io.CopyBuffer(out, reader, buf)
+	if err == nil && written < *n {
+		err = io.EOF
 	}
-	written, err := io.CopyN(out, reader, *n)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "I/O error: %v\n", err)
 		os.Exit(1)
 	}
-	fmt.Fprintf(os.Stderr, "extracted %d digits\n", written)
+	stats := retryStats()
+	fmt.Fprintf(os.Stderr, "extracted %d digits (%d retries, %d resumes)\n", written, stats.Retries, stats.Resumes)
 }
@@ -23,36 +23,46 @@ import (
 	"sort"
 
 	"cloud.google.com/go/storage"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/gcs"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/local"
 	"github.com/googlecloudplatform/pi-delivery/pkg/resultset"
 	"github.com/googlecloudplatform/pi-delivery/pkg/ycd"
 	"go.uber.org/zap"
-	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
 // All the YCD files I tested are smaller than 256 bytes so let's just fetch the first
-// one kilobyte. This program fails if a header is somehow bigger than this limit.
+// one kilobyte. ycd.ParseWithOptions fails with ycd.HeaderTooLargeError
+// if a header is somehow bigger than this limit.
 const maxHeaderLength = 1024
 
+// Newer y-cruncher versions may add header fields, so parse leniently
+// and log whatever we don't know about.
+var parseOptions = ycd.ParseOptions{
+	MaxHeaderLength:  maxHeaderLength,
+	VersionCheck:     ycd.VersionLenient,
+	AllowUnknownKeys: true,
+}
+
 var logger *zap.SugaredLogger
 
 var bucketName = flag.String("bucket", "", "bucket name (e.g. pi-delivery-public)")
 var hexPrefix = flag.String("hex", "Pi - Hex - Chudnovsky", "prefix for hexadecimal results")
 var decPrefix = flag.String("dec", "Pi - Dec - Chudnovsky", "prefix for decimal results")
 var prefix = flag.String("prefix", "", "common prefix for the result objects")
+var dir = flag.String("dir", "", "index buckets in a local directory instead of Cloud Storage")
 
-func listObjects(ctx context.Context, bucket *storage.BucketHandle, prefix string) ([]string, error) {
+func listObjects(ctx context.Context, bucket obj.Bucket, prefix string) ([]*obj.ObjectAttrs, error) {
 	logger.Infow("listObjects",
 		"prefix", prefix,
 	)
 
-	query := &storage.Query{Prefix: prefix}
-	query.SetAttrSelection([]string{"Name"})
-	iter := bucket.Objects(ctx, query)
-	objects := []string{}
+	iter := bucket.List(ctx, &obj.Query{Prefix: prefix})
+	objects := []*obj.ObjectAttrs{}
 	for {
 		attrs, err := iter.Next()
-		if err == iterator.Done {
+		if err == obj.Done {
 			break
 		}
 		if err != nil {
@@ -64,8 +74,9 @@ func listObjects(ctx context.Context, bucket *storage.BucketHandle, prefix strin
 		}
 		logger.Infow("object found",
 			"name", attrs.Name,
+			"size", attrs.Size,
 		)
-		objects = append(objects, attrs.Name)
+		objects = append(objects, attrs)
 	}
 	logger.Infow("listObjects finished",
 		"prefix", prefix,
@@ -74,11 +85,17 @@ func listObjects(ctx context.Context, bucket *storage.BucketHandle, prefix strin
 	return objects, nil
 }
 
-func newStorageClient(ctx context.Context) *storage.Client {
-	option.WithScopes("ScopeReadOnly")
-	client, err := storage.NewClient(ctx)
+// newClient returns a client for the local directory dir, or for Cloud Storage if dir is empty.
+func newClient(ctx context.Context, dir string) obj.Client {
+	var client obj.Client
+	var err error
+	if dir != "" {
+		client, err = local.NewClient(dir, local.Options{})
+	} else {
+		client, err = gcs.NewClient(ctx, option.WithScopes(storage.ScopeReadOnly))
+	}
 	if err != nil {
-		logger.Fatalw("failed to create a Storage client",
+		logger.Fatalw("failed to create a storage client",
 			"error", err,
 		)
 		os.Exit(1)
@@ -97,10 +114,11 @@ func logYCDInfo(file *ycd.YCDFile) {
 		"block size", file.Header.BlockSize,
 		"block id", file.Header.BlockID,
 		"header length", file.Header.Length,
+		"extra", file.Header.Extra,
 	)
 }
 
-func fetchYCDFiles(ctx context.Context, client *storage.Client, bucketName, prefix string) resultset.ResultSet {
+func fetchYCDFiles(ctx context.Context, client obj.Client, bucketName, prefix string) resultset.ResultSet {
 	bucket := client.Bucket(bucketName)
 	objects, err := listObjects(ctx, bucket, prefix)
 	if err != nil {
@@ -111,7 +129,8 @@ func fetchYCDFiles(ctx context.Context, client *storage.Client, bucketName, pref
 		os.Exit(1)
 	}
 	files := resultset.ResultSet{}
-	for _, name := range objects {
+	for _, attrs := range objects {
+		name := attrs.Name
 		object := bucket.Object(name)
 		reader, err := object.NewRangeReader(ctx, 0, maxHeaderLength)
 		if err != nil {
@@ -123,16 +142,25 @@ func fetchYCDFiles(ctx context.Context, client *storage.Client, bucketName, pref
 			os.Exit(1)
 		}
 		defer reader.Close()
-		ycd, err := ycd.Parse(reader)
+		ycd, err := ycd.ParseWithOptions(reader, parseOptions)
 		if err != nil {
-			logger.Errorw("failed to parse a ycd file",
+			logger.Fatalw("failed to parse a ycd file",
 				"error", err,
 				"bucket", bucketName,
-				"object", object,
+				"object", name,
 			)
+			os.Exit(1)
 		}
 		ycd.Name = name
 		logYCDInfo(ycd)
+		// Only the last block is shorter, and it has TotalDigits set.
+		if want := int64(ycd.FirstDigitOffset) + ycd.BlockByteLength(); ycd.Header.TotalDigits == 0 && attrs.Size != want {
+			logger.Warnw("unexpected object size",
+				"object", name,
+				"size", attrs.Size,
+				"want", want,
+			)
+		}
 		files = append(files, ycd)
 	}
 	sort.Sort(files)
@@ -186,7 +214,7 @@ func printIndexFileList(w io.Writer, varName string, files resultset.ResultSet)
 	fmt.Fprintln(w)
 }
 
-func processDirectory(ctx context.Context, client *storage.Client, w io.Writer, varName, bucketName, prefix string) {
+func processDirectory(ctx context.Context, client obj.Client, w io.Writer, varName, bucketName, prefix string) {
 	files := fetchYCDFiles(ctx, client, bucketName, prefix)
 	printIndexFileList(w, varName, files)
 }
@@ -208,10 +236,10 @@ func main() {
 
 	ctx := context.Background()
 
-	client := newStorageClient(ctx)
+	client := newClient(ctx, *dir)
 	defer func() {
 		if err := client.Close(); err != nil {
-			logger.Errorw("failed to close the Storage client",
+			logger.Errorw("failed to close the storage client",
 				"error", err)
 		}
 	}()
@@ -26,7 +26,8 @@ import (
 
 	"github.com/googlecloudplatform/pi-delivery/gen/index"
 	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
-	"github.com/googlecloudplatform/pi-delivery/pkg/obj/gcs"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/backend"
+	"github.com/googlecloudplatform/pi-delivery/pkg/resultset"
 	"github.com/googlecloudplatform/pi-delivery/pkg/unpack"
 	"github.com/sethvargo/go-retry"
 	"go.uber.org/zap"
@@ -39,6 +40,8 @@ const (
 	WORKERS           = 256
 	SEQUENCE          = "3141592653589793238462643383279502884197169399375105820974944592307816406286208998628034825342117067"
 	MIN_MATCH         = 10
+	PREFETCH_DEPTH    = 2
+	PREFETCH_CHUNK    = 1024 * 1024
 )
 
 var logger *zap.SugaredLogger
@@ -52,10 +55,13 @@ type task struct {
 	cancel context.CancelFunc
 }
 
-func process(ctx context.Context, task *task, logger *zap.SugaredLogger, client obj.Client) error {
+func process(ctx context.Context, task *task, logger *zap.SugaredLogger, bucket obj.Bucket) error {
 	logger.Infof("processing task, start = %d, n = %v", task.start, task.n)
 
-	rrd := index.Decimal.NewReader(ctx, client.Bucket(index.BucketName))
+	rrd := index.Decimal.NewPrefetchReader(ctx, bucket, resultset.PrefetchOptions{
+		Depth:     PREFETCH_DEPTH,
+		ChunkSize: PREFETCH_CHUNK,
+	})
 	defer rrd.Close()
 	urd := unpack.NewReader(ctx, rrd)
 	if _, err := urd.Seek(task.start, io.SeekStart); err != nil {
@@ -99,7 +105,7 @@ func process(ctx context.Context, task *task, logger *zap.SugaredLogger, client
 	return nil
 }
 
-func worker(ctx context.Context, taskChan <-chan task, client obj.Client) {
+func worker(ctx context.Context, taskChan <-chan task, bucket obj.Bucket) {
 	defer wg.Done()
 	logger := logger.With("worker id", ctx.Value(workerContextKey("workerId")))
 	defer logger.Sync()
@@ -115,7 +121,11 @@ func worker(ctx context.Context, taskChan <-chan task, client obj.Client) {
 		}
 
 		if err := retry.Do(ctx, b, func(ctx context.Context) error {
-			if err := process(ctx, &task, logger, client); err != nil {
+			if err := process(ctx, &task, logger, bucket); err != nil {
+				if ctx.Err() != nil {
+					// Canceled by another worker. Don't retry.
+					return err
+				}
 				return retry.RetryableError(err)
 			}
 			return nil
@@ -134,22 +144,26 @@ func main() {
 	logger = l.Sugar()
 
 	start := flag.Int64("s", 0, "Start offset")
+	bucketName := flag.String("bucket", index.BucketName, "bucket name")
+	var storage backend.Options
+	storage.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
 	ctx, cancel := context.WithCancel(context.Background())
-	client, err := gcs.NewClient(ctx)
+	client, err := backend.NewClient(ctx, storage)
 	if err != nil {
-		logger.Errorf("couldn't create a GCS client: %v", err)
+		logger.Errorf("couldn't create a storage client: %v", err)
 		os.Exit(1)
 	}
 	defer client.Close()
+	bucket := client.Bucket(*bucketName)
 
 	taskChan := make(chan task, 256)
 
 	for i := 0; i < WORKERS; i++ {
 		wg.Add(1)
 		ctx = context.WithValue(ctx, workerContextKey("workerId"), i)
-		go worker(ctx, taskChan, client)
+		go worker(ctx, taskChan, bucket)
 	}
 
 	for i := *start; i < index.Decimal.TotalDigits(); i += CHUNK_SIZE {
@@ -16,10 +16,12 @@ package main
 
 import (
 	"context"
+	"net/http"
 	"os"
 
 	"github.com/GoogleCloudPlatform/functions-framework-go/funcframework"
 	server "github.com/googlecloudplatform/pi-delivery"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/peercache"
 	"go.ajitem.com/zapdriver"
 	"go.uber.org/zap"
 )
@@ -33,9 +35,26 @@ func main() {
 	defer l.Sync()
 	zap.ReplaceGlobals(l)
 
-	if err := funcframework.RegisterHTTPFunctionContext(ctx, "/", server.Get); err != nil {
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.URL.Path == "/stream":
+			server.Stream(w, req)
+		default:
+			server.Get(w, req)
+		}
+	}
+	if err := funcframework.RegisterHTTPFunctionContext(ctx, "/", handler); err != nil {
 		l.Sugar().Fatalf("funcframework.RegisterHTTPFunctionContext: %v\n", err)
 	}
+	// Serve pages to the other instances in PI_PEERS on a separate
+	// listener, which must only be reachable by them.
+	if addr := os.Getenv("PI_PEER_ADDR"); addr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc(peercache.DefaultBasePath, server.Peer)
+		go func() {
+			l.Sugar().Fatalf("http.ListenAndServe(%s): %v\n", addr, http.ListenAndServe(addr, mux))
+		}()
+	}
 	// Use PORT environment variable, or default to 8080.
 	port := "8080"
 	if envPort := os.Getenv("PORT"); envPort != "" {
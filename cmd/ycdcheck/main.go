@@ -0,0 +1,171 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/goccy/go-json"
+	"github.com/googlecloudplatform/pi-delivery/gen/index"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/backend"
+	"github.com/googlecloudplatform/pi-delivery/pkg/unpack"
+)
+
+// Report is the JSON report written at the end of a scan.
+type Report struct {
+	Blocks   int              `json:"blocks"`
+	Problems []unpack.Problem `json:"problems"`
+}
+
+// loadState reads block reports from a previous run. It returns the reports
+// and the size of the file up to the end of the last complete record.
+func loadState(name string) (map[int64]*unpack.BlockReport, int64, error) {
+	state := make(map[int64]*unpack.BlockReport)
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	good := int64(0)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			// The last line is incomplete if the previous run was killed.
+			if len(line) > 0 {
+				fmt.Fprintf(os.Stderr, "ignoring the rest of %s: incomplete record\n", name)
+			}
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		r := new(unpack.BlockReport)
+		if err := json.Unmarshal(line, r); err != nil {
+			fmt.Fprintf(os.Stderr, "ignoring the rest of %s: %v\n", name, err)
+			break
+		}
+		state[r.BlockID] = r
+		good += int64(len(line))
+	}
+	return state, good, nil
+}
+
+func main() {
+	bucketName := flag.String("bucket", index.BucketName, "bucket name")
+	radix := flag.Int("r", 10, "radix")
+	parallelism := flag.Int("p", 8, "number of blocks checked in parallel")
+	stateFile := flag.String("state", "", "file to record checked blocks to and resume from")
+	outfile := flag.String("o", "-", "output file for the JSON report")
+	var storage backend.Options
+	storage.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	set := index.Decimal
+	if *radix == 16 {
+		set = index.Hexadecimal
+	}
+
+	state := make(map[int64]*unpack.BlockReport)
+	var stateOut *os.File
+	if *stateFile != "" {
+		var (
+			good int64
+			err  error
+		)
+		if state, good, err = loadState(*stateFile); err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't load %s: %v\n", *stateFile, err)
+			os.Exit(1)
+		}
+		stateOut, err = os.OpenFile(*stateFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't open %s: %v\n", *stateFile, err)
+			os.Exit(1)
+		}
+		defer stateOut.Close()
+		// Drop whatever follows the last complete record so that new records
+		// aren't appended after it and lost on the next resume.
+		if err := stateOut.Truncate(good); err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't truncate %s: %v\n", *stateFile, err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "resuming with %d blocks already checked\n", len(state))
+	}
+
+	ctx := context.Background()
+	sc, err := backend.NewClient(ctx, storage)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "couldn't initialize storage client: %v\n", err)
+		os.Exit(1)
+	}
+	defer sc.Close()
+
+	problems, err := unpack.Check(ctx, set, sc.Bucket(*bucketName), unpack.CheckOptions{
+		Parallelism: *parallelism,
+		Skip: func(blockID int64) bool {
+			_, ok := state[blockID]
+			return ok
+		},
+		OnBlock: func(r *unpack.BlockReport) error {
+			fmt.Fprintf(os.Stderr, "checked %s: %d bytes, %d problems\n", r.Object, r.Bytes, len(r.Problems))
+			if stateOut == nil {
+				return nil
+			}
+			b, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			_, err = stateOut.Write(append(b, '\n'))
+			return err
+		},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "check failed: %v\n", err)
+		os.Exit(1)
+	}
+	for _, r := range state {
+		problems = append(problems, r.Problems...)
+	}
+
+	out := os.Stdout
+	if *outfile != "-" {
+		f, err := os.Create(*outfile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't open %s: %v\n", *outfile, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		out = f
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(&Report{Blocks: len(set), Problems: problems}); err != nil {
+		fmt.Fprintf(os.Stderr, "couldn't write the report: %v\n", err)
+		os.Exit(1)
+	}
+	if len(problems) > 0 {
+		os.Exit(2)
+	}
+}
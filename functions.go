@@ -16,35 +16,68 @@ package rest
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
 	"github.com/goccy/go-json"
 	"github.com/googlecloudplatform/pi-delivery/gen/index"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/backend"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/peercache"
+	"github.com/googlecloudplatform/pi-delivery/pkg/resultset"
 	"github.com/googlecloudplatform/pi-delivery/pkg/service"
+	"github.com/googlecloudplatform/pi-delivery/pkg/unpack"
 	"go.ajitem.com/zapdriver"
 	"go.uber.org/zap"
 )
 
 var _serv *service.Service
 var _servOnce sync.Once
+var _peers *peercache.Client
 
 var maxDigitsPerRequest = 1000
+var maxDigitsPerStream int64 = 100_000_000
 var bucketName = index.BucketName
+var requestTimeout time.Duration
+var localDir string
+var localMmap bool
+var baseURL string
+var httpAuthorization string
+var cacheDir string
+var cacheSize int64
+var peerSelf string
+var peers []string
+var peerAddr string
+var peerSecret string
 
 const (
 	envMaxDigitsPerRequest = "PI_MAX_DIGITS_PER_REQUEST"
+	envMaxDigitsPerStream  = "PI_MAX_DIGITS_PER_STREAM"
 	envBucketName          = "PI_BUCKET_NAME"
+	envRequestTimeout      = "PI_REQUEST_TIMEOUT"
+	envLocalDir            = "PI_LOCAL_DIR"
+	envLocalMmap           = "PI_LOCAL_MMAP"
+	envBaseURL             = "PI_BASE_URL"
+	envHTTPAuthorization   = "PI_HTTP_AUTHORIZATION"
+	envCacheDir            = "PI_CACHE_DIR"
+	envCacheSize           = "PI_CACHE_SIZE"
+	envPeerSelf            = "PI_PEER_SELF"
+	envPeers               = "PI_PEERS"
+	envPeerAddr            = "PI_PEER_ADDR"
+	envPeerSecret          = "PI_PEER_SECRET"
 )
 
 func init() {
 	functions.HTTP("Get", Get)
+	functions.HTTP("Stream", Stream)
 	functions.HTTP("NotFound", NotFound)
 	if logger, err := zapdriver.NewProduction(); err != nil {
 		zap.S().Fatalw("zapdriver.NewProduction() failed", "error", err)
@@ -61,22 +94,114 @@ func init() {
 			maxDigitsPerRequest = i
 		}
 	}
+	if s := os.Getenv(envMaxDigitsPerStream); s != "" {
+		if i, err := strconv.ParseInt(s, 10, 64); err != nil {
+			zap.S().Error("invalid env value", "name", envMaxDigitsPerStream, "value", s)
+		} else {
+			maxDigitsPerStream = i
+		}
+	}
 	if s := os.Getenv(envBucketName); s != "" {
 		bucketName = s
 	}
+	if s := os.Getenv(envRequestTimeout); s != "" {
+		if d, err := time.ParseDuration(s); err != nil {
+			zap.S().Error("invalid env value", "name", envRequestTimeout, "value", s)
+		} else {
+			requestTimeout = d
+		}
+	}
+	localDir = os.Getenv(envLocalDir)
+	baseURL = os.Getenv(envBaseURL)
+	httpAuthorization = os.Getenv(envHTTPAuthorization)
+	if s := os.Getenv(envLocalMmap); s != "" {
+		if b, err := strconv.ParseBool(s); err != nil {
+			zap.S().Error("invalid env value", "name", envLocalMmap, "value", s)
+		} else {
+			localMmap = b
+		}
+	}
+	cacheDir = os.Getenv(envCacheDir)
+	if s := os.Getenv(envCacheSize); s != "" {
+		if i, err := strconv.ParseInt(s, 10, 64); err != nil {
+			zap.S().Error("invalid env value", "name", envCacheSize, "value", s)
+		} else {
+			cacheSize = i
+		}
+	}
+	peerSelf = os.Getenv(envPeerSelf)
+	if s := os.Getenv(envPeers); s != "" {
+		peers = strings.Split(s, ",")
+	}
+	peerAddr = os.Getenv(envPeerAddr)
+	peerSecret = os.Getenv(envPeerSecret)
+	if len(peers) > 0 && peerAddr == "" {
+		zap.S().Error("peers are ignored without a peer address", "name", envPeers, "value", peers)
+	}
 	zap.S().Info("Config",
 		"maxDigitsPerRequest", maxDigitsPerRequest,
+		"maxDigitsPerStream", maxDigitsPerStream,
 		"bucketName", bucketName,
+		"requestTimeout", requestTimeout,
+		"localDir", localDir,
+		"localMmap", localMmap,
+		"baseURL", baseURL,
+		"cacheDir", cacheDir,
+		"cacheSize", cacheSize,
+		"peerSelf", peerSelf,
+		"peers", peers,
+		"peerAddr", peerAddr,
 	)
 }
 
 func getService(ctx context.Context) *service.Service {
 	_servOnce.Do(func() {
-		_serv = service.NewService(ctx, zap.S(), bucketName)
+		// Serve ycd files in a local directory or on an HTTP server or a CDN
+		// instead of Cloud Storage if configured, and keep packed pages on
+		// the local disk across restarts.
+		client, err := backend.NewClient(ctx, backend.Options{
+			Dir:           localDir,
+			Mmap:          localMmap,
+			BaseURL:       baseURL,
+			Authorization: httpAuthorization,
+			CacheDir:      cacheDir,
+			CacheSize:     cacheSize,
+		})
+		if err != nil {
+			zap.S().Fatalw("Failed to create a storage client",
+				"error", err)
+		}
+		if peerAddr != "" && peerSelf != "" && len(peers) > 0 {
+			// Share pages with the other instances, which fetch them from Peer.
+			_peers = peercache.NewClient(client, peercache.Options{
+				Bucket: bucketName,
+				Secret: peerSecret,
+				Self:   peerSelf,
+				Peers:  peers,
+			})
+			client = _peers
+		}
+		if _serv, err = service.NewServiceWithClient(client, bucketName); err != nil {
+			zap.S().Fatalw("Failed to create a new Service",
+				"error", err)
+		}
 	})
 	return _serv
 }
 
+// Peer serves cached pages to the other instances in PI_PEERS
+// under peercache.DefaultBasePath. It isn't registered as a function
+// as instances of Cloud Functions can't reach each other; cmd/rest
+// serves it on PI_PEER_ADDR, which must not be reachable publicly.
+func Peer(res http.ResponseWriter, req *http.Request) {
+	getService(req.Context())
+	if _peers == nil {
+		http.NotFound(res, req)
+		return
+	}
+	_peers.ServeHTTP(res, req)
+}
+
 func namedLogger(l *zap.SugaredLogger, name string, req *http.Request) *zap.SugaredLogger {
 	return l.Named(name).
 		With(
@@ -108,6 +233,56 @@ func getIntQueryParam(l *zap.SugaredLogger, q url.Values, name string, def int64
 	return i, nil
 }
 
+// digitRange is a range of digits requested by the query parameters
+// of Get and Stream.
+type digitRange struct {
+	set   resultset.ResultSet
+	radix int
+	start int64
+	n     int64
+}
+
+// parseRange parses the query parameters radix, start and numberOfDigits,
+// which can be up to maxDigits. The error is the message to the client.
+func (h *getHandler) parseRange(l *zap.SugaredLogger, q url.Values, maxDigits int64) (*digitRange, error) {
+	radix, err := getIntQueryParam(l, q, "radix", 10)
+	if err != nil {
+		return nil, err
+	}
+	if radix != 10 && unpack.BitsPerDigit(int(radix)) == 0 {
+		return nil, errors.New("radix must be one of 2, 4, 8, 10, 16 or 32")
+	}
+	set := h.decimal
+	totalDigits := set.TotalDigits()
+	if radix != 10 {
+		set = h.hexadecimal
+		totalDigits = unpack.BaseDigits(set.TotalDigits(), int(radix))
+	}
+
+	start, err := getIntQueryParam(l, q, "start", 0)
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 {
+		return nil, errors.New("start is negative")
+	}
+	if start > totalDigits {
+		return nil, errors.New("start out of range")
+	}
+
+	numberOfDigits, err := getIntQueryParam(l, q, "numberOfDigits", 100)
+	if err != nil {
+		return nil, err
+	}
+	if numberOfDigits < 0 {
+		return nil, errors.New("numberOfDigits is negative")
+	}
+	if numberOfDigits > maxDigits {
+		return nil, errors.New("numberOfDigits is too big")
+	}
+	return &digitRange{set: set, radix: int(radix), start: start, n: numberOfDigits}, nil
+}
+
 // GetResponse is the JSON response for Get.
 type GetResponse struct {
 	// Content is a string representation of Pi digits.
@@ -119,60 +294,56 @@ type GetResponse struct {
 // It takes three parameters in the query string:
 //  - start (int64): the digit position to read from.
 //  - numberOfDigits(int64): number of digits to read.
-//  - radix (int): the radix of pi to read. 10 or 16. default 10.
+//  - radix (int): the radix of pi to read. 2, 4, 8, 10, 16 or 32. default 10.
+//    2, 4, 8 and 32 are converted from the hexadecimal digits.
 // It returns a JSON response as GetResponse.
 func Get(res http.ResponseWriter, req *http.Request) {
+	defaultGetHandler.ServeHTTP(res, req)
+}
+
+// getHandler serves Get from the result sets it's configured with.
+type getHandler struct {
+	service     func(ctx context.Context) *service.Service
+	decimal     resultset.ResultSet
+	hexadecimal resultset.ResultSet
+}
+
+var defaultGetHandler = &getHandler{
+	service:     getService,
+	decimal:     index.Decimal,
+	hexadecimal: index.Hexadecimal,
+}
+
+func (h *getHandler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	l := namedLogger(zap.S(), "Get", req)
 	defer l.Sync()
 
 	l.Info("Get start")
 	res.Header().Set("Access-Control-Allow-Origin", "*")
 
-	q := req.URL.Query()
-	radix, err := getIntQueryParam(l, q, "radix", 10)
-	if err != nil {
-		writeError(l, res, http.StatusBadRequest, err.Error())
-		return
-	}
-	if radix != 10 && radix != 16 {
-		writeError(l, res, http.StatusBadRequest, "radix must be either 10 or 16")
-		return
-	}
-	set := index.Decimal
-	if radix == 16 {
-		set = index.Hexadecimal
-	}
-
-	start, err := getIntQueryParam(l, q, "start", 0)
+	r, err := h.parseRange(l, req.URL.Query(), int64(maxDigitsPerRequest))
 	if err != nil {
 		writeError(l, res, http.StatusBadRequest, err.Error())
 		return
 	}
-	if start < 0 {
-		writeError(l, res, http.StatusBadRequest, "start is negative")
-		return
-	}
-	if start > set.TotalDigits() {
-		writeError(l, res, http.StatusBadRequest, "start out of range")
-		return
-	}
 
-	numberOfDigits, err := getIntQueryParam(l, q, "numberOfDigits", 100)
-	if err != nil {
-		writeError(l, res, http.StatusBadRequest, err.Error())
-		return
+	ctx := req.Context()
+	if requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, requestTimeout)
+		defer cancel()
 	}
-	if numberOfDigits < 0 {
-		writeError(l, res, http.StatusBadRequest, "numberOfDigits is negative")
+	unpacked, err := h.service(req.Context()).
+		GetBase(ctx, l, r.set, r.radix, r.start, r.n)
+	if errors.Is(err, context.DeadlineExceeded) {
+		writeError(l, res, http.StatusGatewayTimeout, "Gateway Timeout")
 		return
 	}
-	if numberOfDigits > int64(maxDigitsPerRequest) {
-		writeError(l, res, http.StatusBadRequest, "numberOfDigits is too big")
+	if errors.Is(err, context.Canceled) {
+		// The client went away. Nobody reads the response.
+		l.Infow("request canceled", "error", err)
 		return
 	}
-
-	unpacked, err := getService(req.Context()).
-		Get(req.Context(), l, set, start, numberOfDigits)
 	if err != nil {
 		writeError(l, res, http.StatusInternalServerError, "Internal Server Error")
 		return
@@ -189,6 +360,109 @@ func Get(res http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// Stream is the entrypoint for streaming a large number of digits.
+// It takes the query parameters of Get, with numberOfDigits up to
+// PI_MAX_DIGITS_PER_STREAM, and format:
+//   - format (string): text (default) for digits in text/plain, or ndjson
+//     for lines of StreamChunk in application/x-ndjson.
+//
+// Digits are written as they are read, and the response is flushed
+// after each write. The stream stops when the client goes away.
+// If reading fails after the response has started, the text stream
+// ends early and the ndjson stream ends with a StreamChunk with Error.
+// Either way, the X-Pi-Error trailer is set to the error, so clients
+// can tell a truncated stream from a complete one.
+func Stream(res http.ResponseWriter, req *http.Request) {
+	defaultGetHandler.serveStream(res, req)
+}
+
+// streamErrorTrailer is the trailer Stream sets if it fails.
+const streamErrorTrailer = "X-Pi-Error"
+
+// StreamChunk is a line of the ndjson response of Stream.
+type StreamChunk struct {
+	// Content is the next digits.
+	Content string `json:"content,omitempty"`
+	// Error is set on the last line if the stream failed.
+	Error string `json:"error,omitempty"`
+}
+
+func (h *getHandler) serveStream(res http.ResponseWriter, req *http.Request) {
+	l := namedLogger(zap.S(), "Stream", req)
+	defer l.Sync()
+
+	l.Info("Stream start")
+	res.Header().Set("Access-Control-Allow-Origin", "*")
+
+	q := req.URL.Query()
+	r, err := h.parseRange(l, q, maxDigitsPerStream)
+	if err != nil {
+		writeError(l, res, http.StatusBadRequest, err.Error())
+		return
+	}
+	format := q.Get("format")
+	switch format {
+	case "", "text":
+		res.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	case "ndjson":
+		res.Header().Set("Content-Type", "application/x-ndjson")
+	default:
+		writeError(l, res, http.StatusBadRequest, "format must be text or ndjson")
+		return
+	}
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.Header().Set("Trailer", streamErrorTrailer)
+	res.WriteHeader(http.StatusOK)
+
+	var w io.Writer = &flushWriter{w: res}
+	if format == "ndjson" {
+		w = &ndjsonWriter{w: w}
+	}
+	// The request context is canceled when the client goes away.
+	ctx := req.Context()
+	_, err = h.service(ctx).WriteBase(ctx, l, w, r.set, r.radix, r.start, r.n)
+	if errors.Is(err, context.Canceled) {
+		l.Infow("request canceled", "error", err)
+		return
+	}
+	if err == nil {
+		return
+	}
+	if format == "ndjson" {
+		json.NewEncoder(res).Encode(&StreamChunk{Error: "Internal Server Error"})
+	}
+	res.Header().Set(streamErrorTrailer, "Internal Server Error")
+}
+
+// flushWriter flushes the response after each write
+// so that clients receive digits as they're read.
+type flushWriter struct {
+	w io.Writer
+}
+
+func (w *flushWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if f, ok := w.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, err
+}
+
+// ndjsonWriter writes each write as a line of StreamChunk.
+type ndjsonWriter struct {
+	w io.Writer
+}
+
+func (w *ndjsonWriter) Write(p []byte) (int, error) {
+	if err := json.NewEncoder(w.w).EncodeWithOption(
+		&StreamChunk{Content: string(p)},
+		json.DisableHTMLEscape(),
+	); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
 // NotFound returns 404 for all requests.
 // This is necessary because LB can't return 404 by itself.
 // https://issuetracker.google.com/160192483
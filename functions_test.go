@@ -15,6 +15,7 @@
 package rest
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -25,6 +26,9 @@ import (
 
 	"github.com/goccy/go-json"
 	"github.com/google/go-cmp/cmp"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/mem"
+	"github.com/googlecloudplatform/pi-delivery/pkg/service"
+	"github.com/googlecloudplatform/pi-delivery/pkg/tests"
 )
 
 func TestRest_Get(t *testing.T) {
@@ -89,6 +93,219 @@ func TestRest_Get(t *testing.T) {
 	}
 }
 
+func TestRest_GetMem(t *testing.T) {
+	t.Parallel()
+
+	client := mem.NewClient()
+	bucket := client.MemBucket("pi")
+	decimal, err := tests.NewResultSet(bucket, tests.PiDecimal, 10, 100)
+	if err != nil {
+		t.Fatalf("NewResultSet() failed: %v", err)
+	}
+	hexadecimal, err := tests.NewResultSet(bucket, tests.PiHexadecimal, 16, 64)
+	if err != nil {
+		t.Fatalf("NewResultSet() failed: %v", err)
+	}
+	serv, err := service.NewServiceWithClient(client, "pi")
+	if err != nil {
+		t.Fatalf("NewServiceWithClient() failed: %v", err)
+	}
+	defer serv.Close()
+	handler := &getHandler{
+		service:     func(context.Context) *service.Service { return serv },
+		decimal:     decimal,
+		hexadecimal: hexadecimal,
+	}
+
+	testCases := []struct {
+		radix    int
+		start, n int64
+		want     string
+	}{
+		{10, 0, 50, "31415926535897932384626433832795028841971693993751"},
+		{10, 95, 10, "1706798214"},
+		{10, 995, 10, "201989"},
+		{16, 0, 50, "3243f6a8885a308d313198a2e03707344a4093822299f31d00"},
+		{16, 60, 10, "e6c8945282"},
+		{2, 0, 10, "1100100100"},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(fmt.Sprintf("Radix %d Start %d N %d", tc.radix, tc.start, tc.n), func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, "/Get", nil)
+			q := req.URL.Query()
+			q.Add("start", strconv.FormatInt(tc.start, 10))
+			q.Add("numberOfDigits", strconv.FormatInt(tc.n, 10))
+			q.Add("radix", strconv.Itoa(tc.radix))
+			req.URL.RawQuery = q.Encode()
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			res := recorder.Result()
+			if got, want := res.StatusCode, http.StatusOK; got != want {
+				t.Errorf("StatusCode = got %d, want %d", got, want)
+			}
+			got := &GetResponse{}
+			if err := json.NewDecoder(res.Body).Decode(got); err != nil {
+				t.Errorf("JSON Decode() failed: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got.Content); diff != "" {
+				t.Errorf("Content = (-want, +got):\n%s", diff)
+			}
+		})
+	}
+
+	// Out of range for the small result set.
+	req := httptest.NewRequest(http.MethodGet, "/Get?start=2000", nil)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if got, want := recorder.Result().StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("StatusCode = got %d, want %d", got, want)
+	}
+}
+
+func TestRest_StreamMem(t *testing.T) {
+	t.Parallel()
+
+	client := mem.NewClient()
+	bucket := client.MemBucket("pi")
+	decimal, err := tests.NewResultSet(bucket, tests.PiDecimal, 10, 100)
+	if err != nil {
+		t.Fatalf("NewResultSet() failed: %v", err)
+	}
+	hexadecimal, err := tests.NewResultSet(bucket, tests.PiHexadecimal, 16, 64)
+	if err != nil {
+		t.Fatalf("NewResultSet() failed: %v", err)
+	}
+	serv, err := service.NewServiceWithClient(client, "pi")
+	if err != nil {
+		t.Fatalf("NewServiceWithClient() failed: %v", err)
+	}
+	defer serv.Close()
+	handler := &getHandler{
+		service:     func(context.Context) *service.Service { return serv },
+		decimal:     decimal,
+		hexadecimal: hexadecimal,
+	}
+
+	testCases := []struct {
+		query       string
+		contentType string
+		want        string
+	}{
+		{"start=0&numberOfDigits=5000", "text/plain; charset=utf-8", tests.PiDecimal[:1] + tests.PiDecimal[2:]},
+		{"start=996&numberOfDigits=10&format=text", "text/plain; charset=utf-8", "01989"},
+		{"start=1&numberOfDigits=12&radix=2", "text/plain; charset=utf-8", "100100100001"},
+		{"start=0&numberOfDigits=5000&radix=16&format=ndjson", "application/x-ndjson", tests.PiHexadecimal[:1] + tests.PiHexadecimal[2:]},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.query, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, "/stream?"+tc.query, nil)
+			recorder := httptest.NewRecorder()
+			handler.serveStream(recorder, req)
+
+			res := recorder.Result()
+			if got, want := res.StatusCode, http.StatusOK; got != want {
+				t.Errorf("StatusCode = got %d, want %d", got, want)
+			}
+			if got := res.Header.Get("Content-Type"); got != tc.contentType {
+				t.Errorf("Content-Type = got %s, want %s", got, tc.contentType)
+			}
+			if !recorder.Flushed {
+				t.Errorf("Flushed = got false, want true")
+			}
+			var got string
+			if tc.contentType == "application/x-ndjson" {
+				dec := json.NewDecoder(res.Body)
+				for dec.More() {
+					var chunk StreamChunk
+					if err := dec.Decode(&chunk); err != nil {
+						t.Fatalf("JSON Decode() failed: %v", err)
+					}
+					if chunk.Error != "" {
+						t.Errorf("Error = got %s, want none", chunk.Error)
+					}
+					got += chunk.Content
+				}
+			} else {
+				b, err := io.ReadAll(res.Body)
+				if err != nil {
+					t.Fatalf("ReadAll() failed: %v", err)
+				}
+				got = string(b)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Content = (-want, +got):\n%s", diff)
+			}
+			if got := res.Trailer.Get(streamErrorTrailer); got != "" {
+				t.Errorf("%s = got %s, want none", streamErrorTrailer, got)
+			}
+		})
+	}
+
+	for _, query := range []string{
+		"numberOfDigits=1000000000000",
+		"format=xml",
+		"radix=7",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/stream?"+query, nil)
+		recorder := httptest.NewRecorder()
+		handler.serveStream(recorder, req)
+		if got, want := recorder.Result().StatusCode, http.StatusBadRequest; got != want {
+			t.Errorf("%s: StatusCode = got %d, want %d", query, got, want)
+		}
+	}
+}
+
+func TestRest_StreamError(t *testing.T) {
+	t.Parallel()
+
+	client := mem.NewClient()
+	bucket := client.MemBucket("pi")
+	decimal, err := tests.NewResultSet(bucket, tests.PiDecimal, 10, 100)
+	if err != nil {
+		t.Fatalf("NewResultSet() failed: %v", err)
+	}
+	// The stream fails in the middle when it reaches the missing block.
+	bucket.Delete(decimal[5].Name)
+	serv, err := service.NewServiceWithClient(client, "pi")
+	if err != nil {
+		t.Fatalf("NewServiceWithClient() failed: %v", err)
+	}
+	defer serv.Close()
+	handler := &getHandler{
+		service: func(context.Context) *service.Service { return serv },
+		decimal: decimal,
+	}
+
+	for _, format := range []string{"text", "ndjson"} {
+		req := httptest.NewRequest(http.MethodGet, "/stream?start=0&numberOfDigits=1000&format="+format, nil)
+		recorder := httptest.NewRecorder()
+		handler.serveStream(recorder, req)
+
+		res := recorder.Result()
+		if got, want := res.StatusCode, http.StatusOK; got != want {
+			t.Errorf("%s: StatusCode = got %d, want %d", format, got, want)
+		}
+		b, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("%s: ReadAll() failed: %v", format, err)
+		}
+		if format == "text" && (len(b) == 0 || len(b) >= 1000) {
+			t.Errorf("%s: len(Content) = got %d, want a truncated stream", format, len(b))
+		}
+		if got, want := res.Trailer.Get(streamErrorTrailer), "Internal Server Error"; got != want {
+			t.Errorf("%s: %s = got %q, want %q", format, streamErrorTrailer, got, want)
+		}
+	}
+}
+
 func TestGet_BadRequests(t *testing.T) {
 	t.Parallel()
 
@@ -6,10 +6,12 @@ require (
 	cloud.google.com/go/storage v1.21.0
 	github.com/GoogleCloudPlatform/functions-framework-go v1.5.3
 	github.com/goccy/go-json v0.9.5
+	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da
 	github.com/golang/mock v1.6.0
 	github.com/google/go-cmp v0.5.7
 	github.com/sethvargo/go-retry v0.2.3
 	go.ajitem.com/zapdriver v1.4.0
+	go.opencensus.io v0.23.0
 	go.uber.org/zap v1.21.0
 	google.golang.org/api v0.71.0
 )
@@ -20,14 +22,12 @@ require (
 	cloud.google.com/go/functions v1.3.0 // indirect
 	cloud.google.com/go/iam v0.3.0 // indirect
 	github.com/cloudevents/sdk-go/v2 v2.8.0 // indirect
-	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/google/uuid v1.3.0 // indirect
 	github.com/googleapis/gax-go/v2 v2.1.1 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
 	github.com/modern-go/reflect2 v1.0.2 // indirect
-	go.opencensus.io v0.23.0 // indirect
 	go.uber.org/atomic v1.9.0 // indirect
 	go.uber.org/multierr v1.8.0 // indirect
 	golang.org/x/mod v0.5.1 // indirect
@@ -0,0 +1,198 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cached
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/googlecloudplatform/pi-delivery/pkg/resultset"
+	"github.com/googlecloudplatform/pi-delivery/pkg/ycd"
+)
+
+const (
+	// DefaultPageSize is the default size of a cache page in bytes.
+	DefaultPageSize = 64 * 1024
+	// DefaultBudget is the default memory budget of a cache in bytes.
+	DefaultBudget = 64 * 1024 * 1024
+)
+
+// Range is a range of packed bytes in a result set.
+type Range struct {
+	Off    int64
+	Length int64
+}
+
+// Options configures a Cache.
+type Options struct {
+	// PageSize is the size of the aligned pages the cache reads
+	// and evicts. DefaultPageSize if 0.
+	PageSize int64
+	// Budget is the maximum number of bytes of pages that can be evicted.
+	// DefaultBudget if 0. It's at least PageSize.
+	Budget int64
+	// Pinned are ranges of every result set whose pages are never evicted
+	// once read, e.g. the start. They don't count toward Budget, so pin
+	// ranges only for long-lived result sets such as the index.
+	Pinned []Range
+}
+
+// Stats are statistics of a Cache.
+type Stats struct {
+	// Hits is the number of pages found in the cache.
+	Hits int64
+	// Misses is the number of pages read from upstream.
+	Misses int64
+	// Evictions is the number of pages evicted.
+	Evictions int64
+	// Pages is the number of pages in the cache including pinned ones.
+	Pages int
+	// Bytes is the number of bytes in the cache including pinned pages.
+	Bytes int64
+}
+
+// Cache is an LRU cache of fixed-size aligned pages of result sets.
+// It's safe for concurrent use and can be shared by result sets.
+type Cache struct {
+	opts Options
+
+	mu     sync.Mutex
+	pages  map[pageKey]*page
+	lru    *list.List
+	bytes  int64
+	pinned int64
+	stats  Stats
+}
+
+// pageKey identifies a page of a result set.
+type pageKey struct {
+	set *ycd.YCDFile
+	off int64
+}
+
+type page struct {
+	key  pageKey
+	data []byte
+	// elem is the element in the LRU list. nil if the page is pinned.
+	elem *list.Element
+}
+
+// Default is the cache of NewCachedReader.
+// It pins the first 1 MiB of each result set.
+var Default = NewCache(Options{
+	Pinned: []Range{{Off: 0, Length: 1024 * 1024}},
+})
+
+// NewCache returns a new empty Cache.
+func NewCache(opts Options) *Cache {
+	if opts.PageSize <= 0 {
+		opts.PageSize = DefaultPageSize
+	}
+	if opts.Budget <= 0 {
+		opts.Budget = DefaultBudget
+	}
+	if opts.Budget < opts.PageSize {
+		opts.Budget = opts.PageSize
+	}
+	return &Cache{
+		opts:  opts,
+		pages: make(map[pageKey]*page),
+		lru:   list.New(),
+	}
+}
+
+// Stats returns the statistics of c.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Pages = len(c.pages)
+	s.Bytes = c.bytes + c.pinned
+	return s
+}
+
+// PageSize returns the page size of c.
+func (c *Cache) PageSize() int64 {
+	return c.opts.PageSize
+}
+
+// setKey returns the identity of set in page keys, its first file.
+// Result sets built separately don't share pages even if they have
+// the same radix or file names.
+func setKey(set resultset.ResultSet) *ycd.YCDFile {
+	if len(set) == 0 {
+		return nil
+	}
+	return set[0]
+}
+
+func (c *Cache) isPinned(off int64) bool {
+	end := off + c.opts.PageSize
+	for _, r := range c.opts.Pinned {
+		if off < r.Off+r.Length && r.Off < end {
+			return true
+		}
+	}
+	return false
+}
+
+// get returns the page at key and marks it recently used.
+func (c *Cache) get(key pageKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.pages[key]
+	if !ok {
+		return nil, false
+	}
+	c.stats.Hits++
+	if p.elem != nil {
+		c.lru.MoveToFront(p.elem)
+	}
+	return p.data, true
+}
+
+// has reports whether the page at key is cached without marking it used.
+func (c *Cache) has(key pageKey) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.pages[key]
+	return ok
+}
+
+// put adds a page read from upstream and evicts the least recently used
+// pages over the budget.
+func (c *Cache) put(key pageKey, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Misses++
+	if _, ok := c.pages[key]; ok {
+		// Read concurrently by another reader.
+		return
+	}
+	p := &page{key: key, data: data}
+	c.pages[key] = p
+	if c.isPinned(key.off) {
+		c.pinned += int64(len(data))
+		return
+	}
+	p.elem = c.lru.PushFront(p)
+	c.bytes += int64(len(data))
+	for c.bytes > c.opts.Budget {
+		victim := c.lru.Remove(c.lru.Back()).(*page)
+		delete(c.pages, victim.key)
+		c.bytes -= int64(len(victim.data))
+		c.stats.Evictions++
+	}
+}
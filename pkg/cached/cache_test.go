@@ -0,0 +1,168 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cached
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/mem"
+	"github.com/googlecloudplatform/pi-delivery/pkg/resultset"
+	"github.com/googlecloudplatform/pi-delivery/pkg/tests"
+)
+
+// newTestReader returns a reader of a decimal result set of text
+// and the packed bytes of the set.
+func newTestReader(t *testing.T, text string) (*resultset.Reader, []byte) {
+	t.Helper()
+	ctx := context.Background()
+	bucket := mem.NewClient().MemBucket("bucket")
+	set, err := tests.NewResultSet(bucket, text, 10, 300)
+	if err != nil {
+		t.Fatalf("NewResultSet() failed: %v", err)
+	}
+	rd := set.NewReader(ctx, bucket)
+	t.Cleanup(func() { rd.Close() })
+	want := make([]byte, set.TotalByteLength())
+	n, err := rd.ReadAt(want, 0)
+	if err != nil && err != io.EOF {
+		t.Fatalf("ReadAt() failed: %v", err)
+	}
+	return rd, want[:n]
+}
+
+func readPage(t *testing.T, rd *CachedReader, want []byte, page int64) {
+	t.Helper()
+	buf := make([]byte, 4)
+	off := page*rd.cache.PageSize() + 3
+	if _, err := rd.ReadAt(buf, off); err != nil {
+		t.Fatalf("ReadAt(%d) failed: %v", off, err)
+	}
+	if diff := cmp.Diff(want[off:off+4], buf); diff != "" {
+		t.Errorf("ReadAt(%d) = (-want, +got):\n%s", off, diff)
+	}
+}
+
+func TestCache_Evict(t *testing.T) {
+	t.Parallel()
+
+	ur, want := newTestReader(t, tests.PiDecimal)
+	cache := NewCache(Options{PageSize: 16, Budget: 64})
+	rd := NewCachedReaderWithCache(context.Background(), ur, cache)
+
+	for page := int64(0); page < 8; page++ {
+		readPage(t, rd, want, page)
+	}
+	readPage(t, rd, want, 7)
+	readPage(t, rd, want, 4)
+	// 5 is the least recently used now.
+	readPage(t, rd, want, 0)
+	readPage(t, rd, want, 4)
+	readPage(t, rd, want, 5)
+
+	got := cache.Stats()
+	if diff := cmp.Diff(Stats{Hits: 3, Misses: 10, Evictions: 6, Pages: 4, Bytes: 64}, got); diff != "" {
+		t.Errorf("Stats() = (-want, +got):\n%s", diff)
+	}
+}
+
+func TestCache_Pinned(t *testing.T) {
+	t.Parallel()
+
+	ur, want := newTestReader(t, tests.PiDecimal)
+	cache := NewCache(Options{PageSize: 16, Budget: 32, Pinned: []Range{{Off: 20, Length: 1}}})
+	rd := NewCachedReaderWithCache(context.Background(), ur, cache)
+
+	for page := int64(0); page < 10; page++ {
+		readPage(t, rd, want, page)
+	}
+	readPage(t, rd, want, 1)
+
+	got := cache.Stats()
+	if diff := cmp.Diff(Stats{Hits: 1, Misses: 10, Evictions: 7, Pages: 3, Bytes: 48}, got); diff != "" {
+		t.Errorf("Stats() = (-want, +got):\n%s", diff)
+	}
+}
+
+func TestCache_Sets(t *testing.T) {
+	t.Parallel()
+
+	rnd := rand.New(rand.NewSource(1))
+	var sb strings.Builder
+	sb.WriteString("3.")
+	for i := 0; i < 1000; i++ {
+		sb.WriteByte(byte('0' + rnd.Intn(10)))
+	}
+	ur1, want1 := newTestReader(t, tests.PiDecimal)
+	ur2, want2 := newTestReader(t, sb.String())
+	set1, set2 := ur1.ResultSet(), ur2.ResultSet()
+	if set1.Radix() != set2.Radix() || set1[0].Name != set2[0].Name {
+		t.Fatalf("result sets %q and %q differ, want the same radix and names", set1[0].Name, set2[0].Name)
+	}
+
+	cache := NewCache(Options{PageSize: 32})
+	ctx := context.Background()
+	for _, tc := range []struct {
+		rd   *CachedReader
+		want []byte
+	}{
+		{NewCachedReaderWithCache(ctx, ur1, cache), want1},
+		{NewCachedReaderWithCache(ctx, ur2, cache), want2},
+	} {
+		got, err := io.ReadAll(tc.rd)
+		if err != nil {
+			t.Fatalf("ReadAll() failed: %v", err)
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("ReadAll() = (-want, +got):\n%s", diff)
+		}
+	}
+}
+
+func TestCache_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	ur, want := newTestReader(t, tests.PiDecimal)
+	cache := NewCache(Options{PageSize: 16, Budget: 128})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			rd := NewCachedReaderWithCache(context.Background(), ur, cache)
+			for j := 0; j < 200; j++ {
+				off := rnd.Int63n(int64(len(want)))
+				buf := make([]byte, rnd.Intn(40)+1)
+				n, err := rd.ReadAt(buf, off)
+				if err != nil && err != io.EOF {
+					t.Errorf("ReadAt(%d) failed: %v", off, err)
+					return
+				}
+				if diff := cmp.Diff(want[off:off+int64(n)], buf[:n]); diff != "" {
+					t.Errorf("ReadAt(%d) = (-want, +got):\n%s", off, diff)
+					return
+				}
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+}
@@ -16,23 +16,13 @@ package cached
 
 import (
 	"context"
+	"errors"
 	"io"
-	"sync"
 
 	"github.com/googlecloudplatform/pi-delivery/pkg/resultset"
+	"github.com/googlecloudplatform/pi-delivery/pkg/ycd"
 )
 
-const cacheSize = 1 * 1024 * 1024 // 1 MiB
-var radixes = map[int]int{10: 0, 16: 1}
-
-type cache struct {
-	once  sync.Once
-	lock  sync.RWMutex
-	cache []byte
-}
-
-var _cache = make([]cache, len(radixes))
-
 // UpstreamReader is the reader CachedReader reads from.
 type UpstreamReader interface {
 	io.ReadSeeker
@@ -41,97 +31,144 @@ type UpstreamReader interface {
 	ResultSet() resultset.ResultSet
 }
 
-// CachedReader provides a cache support for up to the first cacheSize bytes
-// on top of the UpstreamReader.
+// CachedReader reads the upstream result set through a Cache.
+// Reads are widened to the pages of the cache so that neighbouring
+// reads hit the same pages.
 type CachedReader struct {
 	off   int64
 	rd    UpstreamReader
 	ctx   context.Context
-	cache *cache
+	cache *Cache
+	set   *ycd.YCDFile
+	total int64
 }
 
 var _ io.ReadSeeker = new(CachedReader)
 var _ io.ReaderAt = new(CachedReader)
 
-// NewCachedReader returns a new CachedReader for upstream rd.
+// NewCachedReader returns a new CachedReader for upstream rd with the Default cache.
 func NewCachedReader(ctx context.Context, rd UpstreamReader) *CachedReader {
-	cache := &_cache[radixes[rd.ResultSet().Radix()]]
-
-	cache.once.Do(func() {
-		cache.cache = make([]byte, 0, cacheSize)
-	})
+	return NewCachedReaderWithCache(ctx, rd, Default)
+}
 
+// NewCachedReaderWithCache returns a new CachedReader for upstream rd with cache.
+func NewCachedReaderWithCache(ctx context.Context, rd UpstreamReader, cache *Cache) *CachedReader {
+	set := rd.ResultSet()
 	return &CachedReader{
 		ctx:   ctx,
 		rd:    rd,
-		off:   0,
 		cache: cache,
+		set:   setKey(set),
+		total: set.DataByteLength(),
 	}
 }
 
 // ReadAt reads len(p) bytes of packed results from offset off.
+// Pages missing from the cache are read from upstream, consecutive
+// ones with a single read.
 func (r *CachedReader) ReadAt(p []byte, off int64) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, errors.New("ReadAt: negative offset")
+	}
+	ps := r.cache.PageSize()
+	end := off + int64(len(p))
 	n := 0
-	if read, ok := r.readCache(p, off); ok {
-		n += read
-		if n == len(p) {
-			return n, nil
+	for n < len(p) {
+		cur := off + int64(n)
+		if cur >= r.total {
+			return n, io.EOF
+		}
+		pageOff := cur / ps * ps
+		data, ok := r.cache.get(pageKey{r.set, pageOff})
+		if !ok {
+			var err error
+			data, err = r.fill(pageOff, end)
+			if err != nil {
+				return n, err
+			}
+		}
+		i := cur - pageOff
+		if i >= int64(len(data)) {
+			// The last page is short at the end of the data.
+			return n, io.EOF
+		}
+		n += copy(p[n:], data[i:])
+	}
+	return n, nil
+}
+
+// fill reads the missing page at pageOff and the missing pages following
+// it up to end from upstream, adds them to the cache and returns the first one.
+func (r *CachedReader) fill(pageOff, end int64) ([]byte, error) {
+	ps := r.cache.PageSize()
+	runEnd := pageOff + ps
+	for runEnd < end && runEnd < r.total && !r.cache.has(pageKey{r.set, runEnd}) {
+		runEnd += ps
+	}
+	if runEnd > r.total {
+		runEnd = r.total
+	}
+
+	buf := make([]byte, runEnd-pageOff)
+	n, err := r.rd.ReadAt(buf, pageOff)
+	if err != nil && !(errors.Is(err, io.EOF) && n > 0) {
+		return nil, err
+	}
+	buf = buf[:n]
+	// Only the last page of the data is short. A short page elsewhere
+	// means upstream ended early, so it's neither cached nor returned.
+	atEnd := pageOff+int64(n) == r.total
+	for i := int64(0); i < int64(n); i += ps {
+		j := i + ps
+		if j > int64(n) {
+			if !atEnd {
+				break
+			}
+			j = int64(n)
 		}
+		r.cache.put(pageKey{r.set, pageOff + i}, buf[i:j:j])
+	}
+	if int64(n) < ps && !atEnd {
+		return nil, io.ErrUnexpectedEOF
 	}
-	read, err := r.rd.ReadAt(p[n:], off+int64(n))
-	r.updateCache(p[n:n+read], off+int64(n))
-	return n + read, err
+	if int64(n) > ps {
+		return buf[:ps:ps], nil
+	}
+	return buf, nil
 }
 
 // Read reads len(p) bytes of packed results from the current offset.
 func (r *CachedReader) Read(p []byte) (int, error) {
-	if n, ok := r.readCache(p, r.off); ok {
-		r.Seek(int64(n), io.SeekCurrent)
-		return n, nil
+	if len(p) == 0 {
+		return 0, r.ctx.Err()
 	}
-	n, err := r.rd.Read(p)
-	r.updateCache(p[:n], r.off)
+	n, err := r.ReadAt(p, r.off)
 	r.off += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
 	return n, err
 }
 
 // Seek updates the offset for the next Read.
 func (r *CachedReader) Seek(offset int64, whence int) (int64, error) {
-	off, err := r.rd.Seek(offset, whence)
-	r.off = off
-	return off, err
-}
-
-func (r *CachedReader) readCache(p []byte, offset int64) (int, bool) {
-	r.cache.lock.RLock()
-	defer r.cache.lock.RUnlock()
-	if int64(len(r.cache.cache)) <= offset {
-		return 0, false
+	off := r.off
+	switch whence {
+	case io.SeekStart:
+		off = offset
+	case io.SeekCurrent:
+		off += offset
+	case io.SeekEnd:
+		off = r.total + offset
 	}
-	return copy(p, r.cache.cache[offset:]), true
-}
-
-func (r *CachedReader) updateCache(p []byte, offset int64) {
-	// This is lazy so just update if the data is contiguous.
-	// Check boundaries with a read lock first.
-	r.cache.lock.RLock()
-	if int64(len(r.cache.cache)) < offset ||
-		int64(cap(r.cache.cache)) <= offset {
-		r.cache.lock.RUnlock()
-		return
-	}
-	r.cache.lock.RUnlock()
-
-	r.cache.lock.Lock()
-	defer r.cache.lock.Unlock()
-	overlap := len(r.cache.cache) - int(offset)
-	n := len(p) - overlap
-	if overlap >= 0 && n > 0 {
-		if len(r.cache.cache)+n > cap(r.cache.cache) {
-			n = cap(r.cache.cache) - len(r.cache.cache)
-		}
-		r.cache.cache = append(r.cache.cache, p[overlap:overlap+n]...)
+	if off < 0 {
+		return r.off, errors.New("Seek: negative offset")
 	}
+	r.off = off
+	return off, nil
 }
 
 // ResultSet returns the upstream ResultSet.
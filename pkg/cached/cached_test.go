@@ -24,6 +24,7 @@ import (
 
 	"github.com/golang/mock/gomock"
 	"github.com/google/go-cmp/cmp"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/mem"
 	mock_obj "github.com/googlecloudplatform/pi-delivery/pkg/obj/mocks"
 	"github.com/googlecloudplatform/pi-delivery/pkg/resultset"
 	"github.com/googlecloudplatform/pi-delivery/pkg/tests"
@@ -91,29 +92,21 @@ func TestCachedReader_Cached(t *testing.T) {
 	bucket := mock_obj.NewMockBucket(mockCtrl)
 	object := mock_obj.NewMockObject(mockCtrl)
 
-	// Check if the cache is working around boundaries.
+	// Check if the cache is working around page boundaries.
 	bucket.EXPECT().
 		Object(testSet[0].Name).
 		Return(object).
-		MaxTimes(4)
+		Times(2)
 
 	gomock.InOrder(
 		object.EXPECT().
-			NewRangeReader(ctx, int64(testSet[0].FirstDigitOffset), int64(10)).
-			Return(io.NopCloser(bytes.NewReader(testBuf)), nil).
-			MaxTimes(1),
+			NewRangeReader(ctx, int64(testSet[0].FirstDigitOffset), int64(16)).
+			Return(io.NopCloser(bytes.NewReader(testBuf[:16])), nil).
+			Times(1),
 		object.EXPECT().
-			NewRangeReader(ctx, int64(testSet[0].FirstDigitOffset)+20, int64(10)).
-			Return(io.NopCloser(bytes.NewReader(testBuf[20:])), nil).
-			MaxTimes(1),
-		object.EXPECT().
-			NewRangeReader(ctx, int64(testSet[0].FirstDigitOffset)+10, int64(10)).
-			Return(io.NopCloser(bytes.NewReader(testBuf[10:])), nil).
-			MaxTimes(1),
-		object.EXPECT().
-			NewRangeReader(ctx, int64(testSet[0].FirstDigitOffset)+20, int64(10)).
-			Return(io.NopCloser(bytes.NewReader(testBuf[20:])), nil).
-			MaxTimes(1),
+			NewRangeReader(ctx, int64(testSet[0].FirstDigitOffset)+16, int64(16)).
+			Return(io.NopCloser(bytes.NewReader(testBuf[16:32])), nil).
+			Times(1),
 	)
 
 	ur := testSet.NewReader(ctx, bucket)
@@ -122,7 +115,8 @@ func TestCachedReader_Cached(t *testing.T) {
 			t.Errorf("Close() failed: %v", err)
 		}
 	})
-	rd := NewCachedReader(ctx, ur)
+	cache := NewCache(Options{PageSize: 16})
+	rd := NewCachedReaderWithCache(ctx, ur, cache)
 
 	testCases := []struct {
 		off int64
@@ -149,6 +143,79 @@ func TestCachedReader_Cached(t *testing.T) {
 			}
 		})
 	}
+
+	want := Stats{Hits: 5, Misses: 2, Pages: 2, Bytes: 32}
+	if diff := cmp.Diff(want, cache.Stats()); diff != "" {
+		t.Errorf("Stats() = (-want, +got):\n%s", diff)
+	}
+}
+
+// earlyEOFReader ends reads of more than n bytes with io.EOF after n bytes,
+// as a broken upstream might. It reads normally if n is 0.
+type earlyEOFReader struct {
+	UpstreamReader
+	n int
+}
+
+func (r *earlyEOFReader) ReadAt(p []byte, off int64) (int, error) {
+	if r.n > 0 && len(p) > r.n {
+		n, err := r.UpstreamReader.ReadAt(p[:r.n], off)
+		if err != nil {
+			return n, err
+		}
+		return n, io.EOF
+	}
+	return r.UpstreamReader.ReadAt(p, off)
+}
+
+func TestCachedReader_EarlyEOF(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	client := mem.NewClient()
+	set, err := tests.NewResultSet(client.MemBucket("pi"), tests.PiDecimal, 10, 1000)
+	if err != nil {
+		t.Fatalf("NewResultSet() failed: %v", err)
+	}
+	ur := set.NewReader(ctx, client.Bucket("pi"))
+	t.Cleanup(func() { ur.Close() })
+	want := make([]byte, 64)
+	if _, err := ur.ReadAt(want, 0); err != nil {
+		t.Fatalf("ReadAt() failed: %v", err)
+	}
+
+	cache := NewCache(Options{PageSize: 16})
+	upstream := &earlyEOFReader{UpstreamReader: ur, n: 24}
+	rd := NewCachedReaderWithCache(ctx, upstream, cache)
+	buf := make([]byte, 64)
+	// Each read from upstream ends after a full page and a half;
+	// the full page is kept and the rest is read again.
+	n, err := rd.ReadAt(buf, 0)
+	if err != nil {
+		t.Fatalf("ReadAt() failed: %v", err)
+	}
+	if diff := cmp.Diff(want, buf[:n]); diff != "" {
+		t.Errorf("ReadAt() = (-want, +got):\n%s", diff)
+	}
+
+	// A read that ends within the first page fails and caches nothing.
+	cache = NewCache(Options{PageSize: 16})
+	upstream.n = 8
+	rd = NewCachedReaderWithCache(ctx, upstream, cache)
+	if n, err := rd.ReadAt(buf, 0); err == nil {
+		t.Errorf("ReadAt() = got %d bytes, want an error", n)
+	}
+	if got := cache.Stats().Pages; got != 0 {
+		t.Errorf("Stats().Pages = got %d, want 0", got)
+	}
+	upstream.n = 0
+	n, err = rd.ReadAt(buf, 0)
+	if err != nil {
+		t.Fatalf("ReadAt() failed: %v", err)
+	}
+	if diff := cmp.Diff(want, buf[:n]); diff != "" {
+		t.Errorf("ReadAt() = (-want, +got):\n%s", diff)
+	}
 }
 
 func TestCacheReader_Simple(t *testing.T) {
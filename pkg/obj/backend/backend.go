@@ -0,0 +1,109 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/diskcache"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/fault"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/gcs"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/httpobj"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/local"
+)
+
+// Creates the obj.Client the commands and the API read ycd files with:
+// a local directory, an HTTP server or Cloud Storage, optionally with
+// injected faults and a disk cache.
+
+// Options selects the backend of a client and its decorators.
+type Options struct {
+	// Dir is the local directory of the buckets. Read with the local backend if set.
+	Dir string
+	// Mmap maps local files into memory.
+	Mmap bool
+	// BaseURL is the HTTP base URL of the buckets. Read with the HTTP backend if set
+	// and Dir is empty.
+	BaseURL string
+	// Authorization is the Authorization header of HTTP requests.
+	Authorization string
+	// Faults are fault.ParseRules rules injected into range requests.
+	Faults string
+	// FaultSeed seeds the random decisions of Faults.
+	FaultSeed int64
+	// CacheDir is the directory of a disk cache of read pages. Not cached if empty.
+	CacheDir string
+	// CacheSize is the maximum bytes of pages in CacheDir.
+	// diskcache.DefaultMaxBytes if 0.
+	CacheSize int64
+}
+
+// RegisterFlags defines the command line flags of the fields of o in fs.
+func (o *Options) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Dir, "dir", "", "Read ycd files from buckets in a local directory instead of Cloud Storage")
+	fs.BoolVar(&o.Mmap, "mmap", false, "Map local files into memory (with -dir)")
+	fs.StringVar(&o.BaseURL, "url", "", "Read ycd files from buckets under an HTTP base URL instead of Cloud Storage")
+	fs.StringVar(&o.Authorization, "auth", "", "Authorization header for HTTP requests (with -url)")
+	fs.StringVar(&o.Faults, "faults", "", "Inject faults into range requests, e.g. \"kind=drop,bytes=4096,rate=0.1\" (see fault.ParseRules)")
+	fs.Int64Var(&o.FaultSeed, "fault-seed", 1, "Seed of random faults (with -faults)")
+	fs.StringVar(&o.CacheDir, "cache-dir", "", "Keep read pages of ycd files in a local directory across runs")
+	fs.Int64Var(&o.CacheSize, "cache-size", diskcache.DefaultMaxBytes, "Maximum bytes of pages in -cache-dir")
+}
+
+// NewClient returns a client for opts.Dir, opts.BaseURL, or Cloud Storage
+// if both are empty, with the faults and the disk cache of opts.
+func NewClient(ctx context.Context, opts Options) (obj.Client, error) {
+	var rules []fault.Rule
+	if opts.Faults != "" {
+		var err error
+		if rules, err = fault.ParseRules(opts.Faults); err != nil {
+			return nil, fmt.Errorf("invalid faults: %w", err)
+		}
+	}
+
+	var client obj.Client
+	var err error
+	switch {
+	case opts.Dir != "":
+		client, err = local.NewClient(opts.Dir, local.Options{Mmap: opts.Mmap})
+	case opts.BaseURL != "":
+		header := http.Header{}
+		if opts.Authorization != "" {
+			header.Set("Authorization", opts.Authorization)
+		}
+		client, err = httpobj.NewClient(opts.BaseURL, httpobj.Options{Header: header})
+	default:
+		client, err = gcs.NewClient(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if opts.Faults != "" {
+		client = fault.NewClient(client, fault.Options{Seed: opts.FaultSeed, Rules: rules})
+	}
+	if opts.CacheDir != "" {
+		cached, err := diskcache.NewClient(client, diskcache.Options{Dir: opts.CacheDir, MaxBytes: opts.CacheSize})
+		if err != nil {
+			client.Close()
+			return nil, fmt.Errorf("couldn't open the cache directory: %w", err)
+		}
+		client = cached
+	}
+	return client, nil
+}
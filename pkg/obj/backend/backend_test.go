@@ -0,0 +1,72 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend_test
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/backend"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/diskcache"
+	"github.com/googlecloudplatform/pi-delivery/pkg/tests"
+)
+
+func TestBackend_NewClient(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	content := tests.GenTestByteSeq(100)
+	if err := os.Mkdir(filepath.Join(root, "bucket"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "bucket", "obj"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var opts backend.Options
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	opts.RegisterFlags(fs)
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	if err := fs.Parse([]string{"-dir", root, "-cache-dir", cacheDir}); err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if opts.CacheSize != diskcache.DefaultMaxBytes {
+		t.Errorf("CacheSize = got %d, want %d", opts.CacheSize, diskcache.DefaultMaxBytes)
+	}
+
+	c, err := backend.NewClient(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+	defer c.Close()
+	got, err := tests.ReadRange(context.Background(), c.Bucket("bucket").Object("obj"), 10, 20)
+	if err != nil {
+		t.Fatalf("ReadRange() failed: %v", err)
+	}
+	if diff := cmp.Diff(content[10:30], got); diff != "" {
+		t.Errorf("ReadRange() = (-want, +got):\n%s", diff)
+	}
+	if _, err := os.Stat(cacheDir); err != nil {
+		t.Errorf("cache directory: %v", err)
+	}
+
+	if _, err := backend.NewClient(context.Background(), backend.Options{Dir: root, Faults: "kind=unknown"}); err == nil {
+		t.Errorf("NewClient() with invalid faults succeeded")
+	}
+}
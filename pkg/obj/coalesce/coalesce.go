@@ -0,0 +1,282 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coalesce
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+)
+
+// A decorator of obj.Client that merges concurrent range reads of an object.
+// A range read joins an upstream request in progress (a flight) whose range
+// covers it, or starts a new flight of exactly its range; reads are never
+// widened, so a lone read costs what it would without the decorator.
+// Concurrent readers of a flight share its result. Results aren't kept after
+// the flight lands; caching is left to the layers above.
+
+const (
+	// DefaultMaxLength is the default maximum length of a flight in bytes.
+	DefaultMaxLength = 1024 * 1024
+	// DefaultFlightTimeout is the default maximum duration of a flight.
+	DefaultFlightTimeout = 30 * time.Second
+)
+
+// Options configures a Client.
+type Options struct {
+	// MaxLength is the maximum length of a range read that starts a flight.
+	// Longer range reads and reads to the end of objects go upstream
+	// as they are. DefaultMaxLength if 0.
+	MaxLength int64
+	// FlightTimeout is the maximum duration of a flight. A flight outlives
+	// the reader that started it as long as others wait for it, so it's
+	// bounded by this instead. DefaultFlightTimeout if 0.
+	FlightTimeout time.Duration
+}
+
+// Stats are counters of a Client.
+type Stats struct {
+	// Flights is the number of upstream requests started by range reads.
+	Flights int64
+	// Joins is the number of times a range read waited for a flight
+	// another range read had started.
+	Joins int64
+	// Bypasses is the number of range reads that went upstream as they are.
+	Bypasses int64
+}
+
+type Client struct {
+	c    obj.Client
+	opts Options
+	mu   sync.Mutex
+	// fs are the flights in progress of each object.
+	fs    map[objectKey][]*flight
+	stats Stats
+}
+
+type Bucket struct {
+	c    *Client
+	b    obj.Bucket
+	name string
+}
+
+type Object struct {
+	c      *Client
+	o      obj.Object
+	bucket string
+	name   string
+}
+
+// NewClient returns a client that coalesces range reads made with c.
+// Closing the returned client closes c.
+func NewClient(c obj.Client, opts Options) obj.Client {
+	if opts.MaxLength <= 0 {
+		opts.MaxLength = DefaultMaxLength
+	}
+	if opts.FlightTimeout <= 0 {
+		opts.FlightTimeout = DefaultFlightTimeout
+	}
+	return &Client{
+		c:    c,
+		opts: opts,
+		fs:   make(map[objectKey][]*flight),
+	}
+}
+
+func (c *Client) Bucket(name string) obj.Bucket {
+	return &Bucket{c: c, b: c.c.Bucket(name), name: name}
+}
+
+func (c *Client) Close() error {
+	return c.c.Close()
+}
+
+// Stats returns the counters of c.
+func (c *Client) Stats() Stats {
+	return Stats{
+		Flights:  atomic.LoadInt64(&c.stats.Flights),
+		Joins:    atomic.LoadInt64(&c.stats.Joins),
+		Bypasses: atomic.LoadInt64(&c.stats.Bypasses),
+	}
+}
+
+func (b *Bucket) Object(name string) obj.Object {
+	return &Object{c: b.c, o: b.b.Object(name), bucket: b.name, name: name}
+}
+
+func (b *Bucket) List(ctx context.Context, q *obj.Query) obj.ObjectIterator {
+	return b.b.List(ctx, q)
+}
+
+func (o *Object) Attrs(ctx context.Context) (*obj.ObjectAttrs, error) {
+	return o.o.Attrs(ctx)
+}
+
+// NewRangeReader joins a flight covering the range, or starts one, and
+// waits for it so that errors such as a missing object are returned here.
+// If ctx is done while waiting, the reader gives up its interest in the
+// flight and returns ctx.Err(); a flight is canceled when all of its
+// readers gave up.
+func (o *Object) NewRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	c := o.c
+	if offset < 0 || length <= 0 || length > c.opts.MaxLength {
+		atomic.AddInt64(&c.stats.Bypasses, 1)
+		return o.o.NewRangeReader(ctx, offset, length)
+	}
+
+	f := c.join(ctx, o, offset, length)
+	var err error
+	select {
+	case <-f.done:
+		err = f.err
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	f.leave()
+	if err != nil {
+		return nil, err
+	}
+
+	lo := offset - f.off
+	if lo >= int64(len(f.data)) {
+		return nil, io.EOF
+	}
+	hi := lo + length
+	if hi > int64(len(f.data)) {
+		// The object ends within the range.
+		hi = int64(len(f.data))
+	}
+	return io.NopCloser(bytes.NewReader(f.data[lo:hi])), nil
+}
+
+type objectKey struct {
+	bucket, object string
+}
+
+// flight is an upstream request of a range.
+type flight struct {
+	c        *Client
+	key      objectKey
+	off, end int64
+	done     chan struct{}
+	data     []byte
+	err      error
+	waiters  int
+	cancel   context.CancelFunc
+}
+
+// join returns a flight in progress that covers [off, off+length), starting
+// a new one if there's none. The caller must leave the flight when it has
+// the result or gives up.
+func (c *Client) join(ctx context.Context, o *Object, off, length int64) *flight {
+	key := objectKey{bucket: o.bucket, object: o.name}
+	end := off + length
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, f := range c.fs[key] {
+		if f.off <= off && end <= f.end {
+			f.waiters++
+			atomic.AddInt64(&c.stats.Joins, 1)
+			return f
+		}
+	}
+
+	// The flight outlives the reader that started it but carries its
+	// values, e.g. to attribute the cost of the request.
+	fctx, cancel := context.WithTimeout(detach(ctx), c.opts.FlightTimeout)
+	f := &flight{
+		c:       c,
+		key:     key,
+		off:     off,
+		end:     end,
+		done:    make(chan struct{}),
+		waiters: 1,
+		cancel:  cancel,
+	}
+	c.fs[key] = append(c.fs[key], f)
+	atomic.AddInt64(&c.stats.Flights, 1)
+	go c.fetch(fctx, o.o, f)
+	return f
+}
+
+func (c *Client) fetch(ctx context.Context, o obj.Object, f *flight) {
+	defer f.cancel()
+
+	// A broken response fails the flight rather than ending the object.
+	data, err := obj.ReadFull(ctx, o, f.off, f.end-f.off)
+
+	c.mu.Lock()
+	c.removeLocked(f)
+	f.data, f.err = data, err
+	c.mu.Unlock()
+	close(f.done)
+}
+
+// removeLocked removes f from the flights in progress. c.mu must be held.
+func (c *Client) removeLocked(f *flight) {
+	fs := c.fs[f.key]
+	for i, g := range fs {
+		if g == f {
+			fs = append(fs[:i:i], fs[i+1:]...)
+			break
+		}
+	}
+	if len(fs) == 0 {
+		delete(c.fs, f.key)
+	} else {
+		c.fs[f.key] = fs
+	}
+}
+
+// leave gives up the interest in f and cancels it if nobody waits for it.
+func (f *flight) leave() {
+	c := f.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f.waiters--
+	if f.waiters > 0 {
+		return
+	}
+	select {
+	case <-f.done:
+		return
+	default:
+	}
+	c.removeLocked(f)
+	f.cancel()
+}
+
+// detach returns a context with the values of ctx that is never done.
+func detach(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
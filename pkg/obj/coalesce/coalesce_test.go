@@ -0,0 +1,282 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coalesce_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/coalesce"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/fault"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/mem"
+	"github.com/googlecloudplatform/pi-delivery/pkg/tests"
+)
+
+// gateClient blocks range requests until gate is closed.
+type gateClient struct {
+	obj.Client
+	gate     chan struct{}
+	calls    int64
+	canceled int64
+}
+
+type gateBucket struct {
+	obj.Bucket
+	c *gateClient
+}
+
+type gateObject struct {
+	obj.Object
+	c *gateClient
+}
+
+func (c *gateClient) Bucket(name string) obj.Bucket {
+	return &gateBucket{Bucket: c.Client.Bucket(name), c: c}
+}
+
+func (b *gateBucket) Object(name string) obj.Object {
+	return &gateObject{Object: b.Bucket.Object(name), c: b.c}
+}
+
+func (o *gateObject) NewRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	atomic.AddInt64(&o.c.calls, 1)
+	select {
+	case <-o.c.gate:
+	case <-ctx.Done():
+		atomic.AddInt64(&o.c.canceled, 1)
+		return nil, ctx.Err()
+	}
+	return o.Object.NewRangeReader(ctx, offset, length)
+}
+
+func newTestClient(t *testing.T, content []byte, opts coalesce.Options) (*coalesce.Client, *gateClient) {
+	t.Helper()
+	mc := mem.NewClient()
+	mc.MemBucket("bucket").Put("obj", content)
+	gc := &gateClient{Client: mc, gate: make(chan struct{})}
+	c := coalesce.NewClient(gc, opts).(*coalesce.Client)
+	t.Cleanup(func() { c.Close() })
+	return c, gc
+}
+
+// waitFor polls cond until it's true or the test times out.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; !cond(); i++ {
+		if i > 1000 {
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCoalesce_NewRangeReader(t *testing.T) {
+	t.Parallel()
+
+	content := tests.GenTestByteSeq(100)
+	c, gc := newTestClient(t, content, coalesce.Options{MaxLength: 48})
+	close(gc.gate)
+	object := c.Bucket("bucket").Object("obj")
+
+	testCases := []struct {
+		name    string
+		off     int64
+		length  int64
+		want    []byte
+		wantErr error
+	}{
+		{"section", 3, 5, content[3:8], nil},
+		{"max length", 10, 48, content[10:58], nil},
+		{"truncated", 90, 20, content[90:], nil},
+		{"to the end", 50, -1, content[50:], nil},
+		{"too long", 0, 60, content[:60], nil},
+		{"at the end", 100, 10, nil, io.EOF},
+		{"past the end", 120, 10, nil, io.EOF},
+	}
+	for _, tc := range testCases {
+		got, err := tests.ReadRange(context.Background(), object, tc.off, tc.length)
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s: NewRangeReader() error got %v, want %v", tc.name, err, tc.wantErr)
+		}
+		if diff := cmp.Diff(tc.want, got); err == nil && diff != "" {
+			t.Errorf("%s: ReadAll() = (-want, +got):\n%s", tc.name, diff)
+		}
+	}
+
+	if _, err := tests.ReadRange(context.Background(), c.Bucket("bucket").Object("missing"), 0, 1); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("NewRangeReader(missing) error got %v, want %v", err, fs.ErrNotExist)
+	}
+	if got := c.Stats().Bypasses; got != 2 {
+		t.Errorf("Bypasses = got %d, want 2", got)
+	}
+}
+
+func TestCoalesce_Drop(t *testing.T) {
+	t.Parallel()
+
+	content := tests.GenTestByteSeq(100)
+	mc := mem.NewClient()
+	mc.MemBucket("bucket").Put("obj", content)
+	// The first response breaks after 5 bytes.
+	fc := fault.NewClient(mc, fault.Options{Rules: []fault.Rule{{Kind: fault.Drop, Bytes: 5, Count: 1}}})
+	c := coalesce.NewClient(fc, coalesce.Options{})
+	defer c.Close()
+	object := c.Bucket("bucket").Object("obj")
+
+	// A broken response isn't mistaken for the end of the object.
+	if got, err := tests.ReadRange(context.Background(), object, 10, 20); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("ReadRange() = got (%q, %v), want error %v", got, err, io.ErrUnexpectedEOF)
+	}
+	got, err := tests.ReadRange(context.Background(), object, 10, 20)
+	if err != nil {
+		t.Fatalf("ReadRange() failed: %v", err)
+	}
+	if diff := cmp.Diff(content[10:30], got); diff != "" {
+		t.Errorf("ReadRange() = (-want, +got):\n%s", diff)
+	}
+}
+
+func TestCoalesce_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	content := tests.GenTestByteSeq(1000)
+	c, gc := newTestClient(t, content, coalesce.Options{})
+	object := c.Bucket("bucket").Object("obj")
+
+	var wg sync.WaitGroup
+	read := func(off, length int64) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := tests.ReadRange(context.Background(), object, off, length)
+			if err != nil {
+				t.Errorf("ReadRange(%d, %d) failed: %v", off, length, err)
+				return
+			}
+			if diff := cmp.Diff(content[off:off+length], got); diff != "" {
+				t.Errorf("ReadRange(%d, %d) = (-want, +got):\n%s", off, length, diff)
+			}
+		}()
+	}
+	read(0, 300)
+	waitFor(t, func() bool { return c.Stats().Flights == 1 })
+	// Ranges within the flight join it, and others start their own.
+	for _, r := range []struct{ off, length int64 }{
+		{0, 300}, {0, 10}, {5, 100}, {200, 50}, {100, 200},
+	} {
+		read(r.off, r.length)
+	}
+	read(290, 20)
+	waitFor(t, func() bool { s := c.Stats(); return s.Joins == 5 && s.Flights == 2 })
+	close(gc.gate)
+	wg.Wait()
+
+	if got := atomic.LoadInt64(&gc.calls); got != 2 {
+		t.Errorf("upstream requests = got %d, want 2", got)
+	}
+}
+
+func TestCoalesce_FlightTimeout(t *testing.T) {
+	t.Parallel()
+
+	content := tests.GenTestByteSeq(100)
+	c, gc := newTestClient(t, content, coalesce.Options{FlightTimeout: 20 * time.Millisecond})
+	object := c.Bucket("bucket").Object("obj")
+
+	// Upstream never responds, but readers don't wait forever.
+	if _, err := tests.ReadRange(context.Background(), object, 0, 10); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ReadRange() error got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if got := atomic.LoadInt64(&gc.canceled); got != 1 {
+		t.Errorf("canceled upstream requests = got %d, want 1", got)
+	}
+}
+
+func TestCoalesce_Cancel(t *testing.T) {
+	t.Parallel()
+
+	content := tests.GenTestByteSeq(100)
+	c, gc := newTestClient(t, content, coalesce.Options{})
+	object := c.Bucket("bucket").Object("obj")
+
+	// A caller that gives up doesn't affect the others.
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error)
+	go func() {
+		_, err := tests.ReadRange(ctx, object, 0, 20)
+		canceled <- err
+	}()
+	waitFor(t, func() bool { return c.Stats().Flights == 1 })
+	result := make(chan []byte)
+	go func() {
+		got, err := tests.ReadRange(context.Background(), object, 10, 10)
+		if err != nil {
+			t.Errorf("ReadRange() failed: %v", err)
+		}
+		result <- got
+	}()
+	waitFor(t, func() bool { return c.Stats().Joins == 1 })
+	cancel()
+	if err := <-canceled; !errors.Is(err, context.Canceled) {
+		t.Errorf("ReadRange(canceled) error got %v, want %v", err, context.Canceled)
+	}
+	close(gc.gate)
+	if diff := cmp.Diff(content[10:20], <-result); diff != "" {
+		t.Errorf("ReadRange() = (-want, +got):\n%s", diff)
+	}
+	if got := atomic.LoadInt64(&gc.canceled); got != 0 {
+		t.Errorf("canceled upstream requests = got %d, want 0", got)
+	}
+
+	// The flight is canceled when all the callers give up.
+	c, gc = newTestClient(t, content, coalesce.Options{})
+	object = c.Bucket("bucket").Object("obj")
+	ctx, cancel = context.WithCancel(context.Background())
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := tests.ReadRange(ctx, object, 0, 10)
+			canceled <- err
+		}()
+	}
+	waitFor(t, func() bool { return c.Stats().Joins == 1 })
+	cancel()
+	for i := 0; i < 2; i++ {
+		if err := <-canceled; !errors.Is(err, context.Canceled) {
+			t.Errorf("ReadRange(canceled) error got %v, want %v", err, context.Canceled)
+		}
+	}
+	waitFor(t, func() bool { return atomic.LoadInt64(&gc.canceled) == 1 })
+
+	// A new caller starts a new flight.
+	close(gc.gate)
+	got, err := tests.ReadRange(context.Background(), object, 0, 10)
+	if err != nil {
+		t.Fatalf("ReadRange() failed: %v", err)
+	}
+	if diff := cmp.Diff(content[:10], got); diff != "" {
+		t.Errorf("ReadRange() = (-want, +got):\n%s", diff)
+	}
+	if got := c.Stats().Flights; got != 2 {
+		t.Errorf("Flights = got %d, want 2", got)
+	}
+}
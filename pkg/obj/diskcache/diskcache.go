@@ -0,0 +1,398 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diskcache
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+)
+
+// A decorator of obj.Client that keeps aligned pages of objects in files
+// in a local directory, so that restarted processes start warm.
+// Objects are assumed to be immutable, as ycd files are.
+//
+// Each page is a file named after a hash of the bucket, object, page size
+// and offset. The file has a header of the magic, the data length and
+// its CRC32C followed by the data. Files are written to temporary names
+// and renamed when complete, and the header is checked when the directory
+// is loaded and the checksum whenever a page is read.
+
+const (
+	// DefaultPageSize is the default size of a page in bytes.
+	DefaultPageSize = 1024 * 1024
+	// DefaultMaxBytes is the default size cap of the directory in bytes.
+	DefaultMaxBytes = 1024 * 1024 * 1024
+
+	pageExt    = ".page"
+	tempPrefix = ".tmp-"
+	headerSize = 12
+)
+
+var magic = [4]byte{'P', 'I', 'P', '1'}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	ErrNoDir   = errors.New("diskcache: directory not specified")
+	ErrCorrupt = errors.New("diskcache: corrupt page")
+)
+
+// Options configures a Client.
+type Options struct {
+	// Dir is the directory of the page files. It's created if missing.
+	Dir string
+	// PageSize is the size of the aligned pages cached. DefaultPageSize if 0.
+	// Pages of a different page size in Dir aren't used.
+	PageSize int64
+	// MaxBytes caps the total size of the page files.
+	// The least recently used pages are removed over it. DefaultMaxBytes if 0.
+	MaxBytes int64
+}
+
+// Stats are counters of a Client.
+type Stats struct {
+	// Hits is the number of pages read from files.
+	Hits int64
+	// Misses is the number of pages read from upstream.
+	Misses int64
+	// Evictions is the number of files removed over MaxBytes.
+	Evictions int64
+	// Corrupt is the number of files found corrupt and removed.
+	Corrupt int64
+	// WriteErrors is the number of pages that couldn't be written.
+	WriteErrors int64
+	// Pages and Bytes are the number and the total size of the files.
+	Pages int
+	Bytes int64
+}
+
+type Client struct {
+	c    obj.Client
+	opts Options
+
+	mu    sync.Mutex
+	files map[string]*list.Element
+	lru   *list.List
+	bytes int64
+	stats Stats
+}
+
+type file struct {
+	name string
+	size int64
+}
+
+type Bucket struct {
+	c    *Client
+	b    obj.Bucket
+	name string
+}
+
+type Object struct {
+	c      *Client
+	o      obj.Object
+	bucket string
+	name   string
+}
+
+// NewClient returns a client that caches pages read with c in opts.Dir.
+// It loads the pages left in the directory, removing temporary and
+// malformed files. Closing the returned client closes c.
+func NewClient(c obj.Client, opts Options) (obj.Client, error) {
+	if opts.Dir == "" {
+		return nil, ErrNoDir
+	}
+	if opts.PageSize <= 0 {
+		opts.PageSize = DefaultPageSize
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultMaxBytes
+	}
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+	client := &Client{
+		c:     c,
+		opts:  opts,
+		files: make(map[string]*list.Element),
+		lru:   list.New(),
+	}
+	if err := client.load(); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// load indexes the page files in the directory, the most recently used first.
+func (c *Client) load() error {
+	entries, err := os.ReadDir(c.opts.Dir)
+	if err != nil {
+		return err
+	}
+	type loaded struct {
+		file
+		mtime time.Time
+	}
+	var pages []loaded
+	for _, e := range entries {
+		name := e.Name()
+		path := filepath.Join(c.opts.Dir, name)
+		if strings.HasPrefix(name, tempPrefix) {
+			// Left by a crash while writing.
+			os.Remove(path)
+			continue
+		}
+		if !strings.HasSuffix(name, pageExt) || !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if err := checkHeader(path, info.Size()); err != nil {
+			os.Remove(path)
+			c.stats.Corrupt++
+			continue
+		}
+		pages = append(pages, loaded{file{name, info.Size()}, info.ModTime()})
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].mtime.After(pages[j].mtime) })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range pages {
+		f := p.file
+		c.files[f.name] = c.lru.PushBack(&f)
+		c.bytes += f.size
+	}
+	c.evictLocked()
+	return nil
+}
+
+// checkHeader checks that the file at path of size bytes has a valid header.
+func checkHeader(path string, size int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var h [headerSize]byte
+	if _, err := io.ReadFull(f, h[:]); err != nil {
+		return ErrCorrupt
+	}
+	if [4]byte{h[0], h[1], h[2], h[3]} != magic ||
+		int64(binary.LittleEndian.Uint32(h[4:8]))+headerSize != size {
+		return ErrCorrupt
+	}
+	return nil
+}
+
+func (c *Client) Bucket(name string) obj.Bucket {
+	return &Bucket{c: c, b: c.c.Bucket(name), name: name}
+}
+
+func (c *Client) Close() error {
+	return c.c.Close()
+}
+
+// Stats returns the counters of c.
+func (c *Client) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Pages = c.lru.Len()
+	s.Bytes = c.bytes
+	return s
+}
+
+// fileName returns the name of the file of the page at off.
+func (c *Client) fileName(bucket, object string, off int64) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d\x00%d", bucket, object, c.opts.PageSize, off)))
+	return hex.EncodeToString(sum[:16]) + pageExt
+}
+
+// read returns the data of the file name if it's cached and intact.
+func (c *Client) read(name string) ([]byte, bool) {
+	c.mu.Lock()
+	elem, ok := c.files[name]
+	if ok {
+		c.lru.MoveToFront(elem)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	path := filepath.Join(c.opts.Dir, name)
+	b, err := os.ReadFile(path)
+	if err == nil {
+		var data []byte
+		data, err = decode(b)
+		if err == nil {
+			// Keep the order across restarts.
+			now := time.Now()
+			os.Chtimes(path, now, now)
+			c.mu.Lock()
+			c.stats.Hits++
+			c.mu.Unlock()
+			return data, true
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if errors.Is(err, ErrCorrupt) {
+		c.stats.Corrupt++
+	}
+	if elem, ok := c.files[name]; ok {
+		c.removeLocked(elem)
+	}
+	return nil, false
+}
+
+func decode(b []byte) ([]byte, error) {
+	if len(b) < headerSize || [4]byte{b[0], b[1], b[2], b[3]} != magic {
+		return nil, ErrCorrupt
+	}
+	data := b[headerSize:]
+	if int(binary.LittleEndian.Uint32(b[4:8])) != len(data) ||
+		binary.LittleEndian.Uint32(b[8:12]) != crc32.Checksum(data, crc32cTable) {
+		return nil, ErrCorrupt
+	}
+	return data, nil
+}
+
+// write stores data in the file name atomically.
+func (c *Client) write(name string, data []byte) {
+	size := int64(headerSize + len(data))
+	if err := c.writeFile(name, data); err != nil {
+		c.mu.Lock()
+		c.stats.WriteErrors++
+		c.mu.Unlock()
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.files[name]; ok {
+		// Written concurrently by another reader.
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.files[name] = c.lru.PushFront(&file{name, size})
+	c.bytes += size
+	c.evictLocked()
+}
+
+func (c *Client) writeFile(name string, data []byte) error {
+	f, err := os.CreateTemp(c.opts.Dir, tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	var h [headerSize]byte
+	copy(h[:], magic[:])
+	binary.LittleEndian.PutUint32(h[4:8], uint32(len(data)))
+	binary.LittleEndian.PutUint32(h[8:12], crc32.Checksum(data, crc32cTable))
+	_, err = f.Write(h[:])
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(c.opts.Dir, name))
+}
+
+// evictLocked removes the least recently used files over MaxBytes.
+func (c *Client) evictLocked() {
+	for c.bytes > c.opts.MaxBytes && c.lru.Len() > 0 {
+		c.removeLocked(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *Client) removeLocked(elem *list.Element) {
+	f := c.lru.Remove(elem).(*file)
+	delete(c.files, f.name)
+	c.bytes -= f.size
+	os.Remove(filepath.Join(c.opts.Dir, f.name))
+}
+
+func (b *Bucket) Object(name string) obj.Object {
+	return &Object{c: b.c, o: b.b.Object(name), bucket: b.name, name: name}
+}
+
+func (b *Bucket) List(ctx context.Context, q *obj.Query) obj.ObjectIterator {
+	return b.b.List(ctx, q)
+}
+
+func (o *Object) Attrs(ctx context.Context) (*obj.ObjectAttrs, error) {
+	return o.o.Attrs(ctx)
+}
+
+// NewRangeReader reads the range from cached pages, reading missing pages
+// from upstream and storing them. The first page is read before it returns.
+// Reads to the end of the object (length < 0) aren't cached.
+func (o *Object) NewRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 || length < 0 {
+		return o.o.NewRangeReader(ctx, offset, length)
+	}
+	return obj.NewPageReader(ctx, offset, length, o.c.opts.PageSize, o.page)
+}
+
+// page returns the page at off.
+func (o *Object) page(ctx context.Context, off int64) ([]byte, error) {
+	c := o.c
+	name := c.fileName(o.bucket, o.name, off)
+	if data, ok := c.read(name); ok {
+		return data, nil
+	}
+
+	// A short page is only written if the object ends there;
+	// a broken response mustn't outlive the read.
+	data, err := obj.ReadFull(ctx, o.o, off, c.opts.PageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.stats.Misses++
+	c.mu.Unlock()
+	c.write(name, data)
+	return data, nil
+}
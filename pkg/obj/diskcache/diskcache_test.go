@@ -0,0 +1,242 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diskcache_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/diskcache"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/fault"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/mem"
+	"github.com/googlecloudplatform/pi-delivery/pkg/tests"
+)
+
+func newTestClient(t *testing.T, mc obj.Client, opts diskcache.Options) *diskcache.Client {
+	t.Helper()
+	c, err := diskcache.NewClient(mc, opts)
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+	return c.(*diskcache.Client)
+}
+
+func TestDiskCache_NewRangeReader(t *testing.T) {
+	t.Parallel()
+
+	content := tests.GenTestByteSeq(100)
+	mc := mem.NewClient()
+	mc.MemBucket("bucket").Put("obj", content)
+	c := newTestClient(t, mc, diskcache.Options{Dir: t.TempDir(), PageSize: 16})
+	o := c.Bucket("bucket").Object("obj")
+
+	testCases := []struct {
+		name        string
+		off, length int64
+		want        []byte
+		wantErr     error
+	}{
+		{"first page", 0, 16, content[:16], nil},
+		{"within page", 3, 5, content[3:8], nil},
+		{"across pages", 10, 50, content[10:60], nil},
+		{"past end", 90, 20, content[90:], nil},
+		{"last page", 96, 4, content[96:], nil},
+		{"to end", 95, -1, content[95:], nil},
+		{"empty", 10, 0, nil, nil},
+		{"out of range", 100, 10, nil, io.EOF},
+	}
+	for _, tc := range testCases {
+		got, err := tests.ReadRange(context.Background(), o, tc.off, tc.length)
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s: ReadRange(%d, %d) error got %v, want %v", tc.name, tc.off, tc.length, err, tc.wantErr)
+		}
+		if diff := cmp.Diff(tc.want, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("%s: ReadRange(%d, %d) = (-want, +got):\n%s", tc.name, tc.off, tc.length, diff)
+		}
+	}
+	if _, err := tests.ReadRange(context.Background(), c.Bucket("bucket").Object("missing"), 0, 10); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadRange(missing) error got %v, want %v", err, fs.ErrNotExist)
+	}
+
+	// All pages but the one at 64 are read; the last one is short.
+	got := c.Stats()
+	if diff := cmp.Diff(diskcache.Stats{Hits: 4, Misses: 6, Pages: 6, Bytes: 6*12 + 84}, got); diff != "" {
+		t.Errorf("Stats() = (-want, +got):\n%s", diff)
+	}
+}
+
+func TestDiskCache_Reload(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	content := tests.GenTestByteSeq(100)
+	mc := mem.NewClient()
+	mc.MemBucket("bucket").Put("obj", content)
+	c := newTestClient(t, mc, diskcache.Options{Dir: dir, PageSize: 16})
+	if _, err := tests.ReadRange(context.Background(), c.Bucket("bucket").Object("obj"), 0, 100); err != nil {
+		t.Fatalf("ReadRange() failed: %v", err)
+	}
+
+	// A crash left a partial file behind.
+	if err := os.WriteFile(filepath.Join(dir, ".tmp-123"), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The restarted client reads everything from the disk.
+	c = newTestClient(t, mem.NewClient(), diskcache.Options{Dir: dir, PageSize: 16})
+	got, err := tests.ReadRange(context.Background(), c.Bucket("bucket").Object("obj"), 0, 100)
+	if err != nil {
+		t.Fatalf("ReadRange() failed: %v", err)
+	}
+	if diff := cmp.Diff(content, got); diff != "" {
+		t.Errorf("ReadRange() = (-want, +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(diskcache.Stats{Hits: 7, Pages: 7, Bytes: 7*12 + 100}, c.Stats()); diff != "" {
+		t.Errorf("Stats() = (-want, +got):\n%s", diff)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".tmp-123")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("temporary file = got %v, want removed", err)
+	}
+
+	// Pages of another page size aren't used.
+	c = newTestClient(t, mem.NewClient(), diskcache.Options{Dir: dir, PageSize: 32})
+	if _, err := tests.ReadRange(context.Background(), c.Bucket("bucket").Object("obj"), 0, 100); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadRange(page size 32) error got %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func TestDiskCache_Drop(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	content := tests.GenTestByteSeq(100)
+	mc := mem.NewClient()
+	mc.MemBucket("bucket").Put("obj", content)
+	// The first response breaks after 5 bytes.
+	fc := fault.NewClient(mc, fault.Options{Rules: []fault.Rule{{Kind: fault.Drop, Bytes: 5, Count: 1}}})
+	c := newTestClient(t, fc, diskcache.Options{Dir: dir, PageSize: 16})
+	object := c.Bucket("bucket").Object("obj")
+
+	// A broken response isn't written as a short page.
+	if got, err := tests.ReadRange(context.Background(), object, 0, 16); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("ReadRange() = got (%q, %v), want error %v", got, err, io.ErrUnexpectedEOF)
+	}
+	if got := c.Stats().Pages; got != 0 {
+		t.Errorf("Stats().Pages = got %d, want 0", got)
+	}
+	if _, err := tests.ReadRange(context.Background(), object, 0, 16); err != nil {
+		t.Fatalf("ReadRange() failed: %v", err)
+	}
+
+	// The restarted client reads the whole page from the disk.
+	c = newTestClient(t, mem.NewClient(), diskcache.Options{Dir: dir, PageSize: 16})
+	got, err := tests.ReadRange(context.Background(), c.Bucket("bucket").Object("obj"), 0, 16)
+	if err != nil {
+		t.Fatalf("ReadRange() failed: %v", err)
+	}
+	if diff := cmp.Diff(content[:16], got); diff != "" {
+		t.Errorf("ReadRange() = (-want, +got):\n%s", diff)
+	}
+}
+
+func TestDiskCache_Corrupt(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	content := tests.GenTestByteSeq(64)
+	mc := mem.NewClient()
+	mc.MemBucket("bucket").Put("obj", content)
+	c := newTestClient(t, mc, diskcache.Options{Dir: dir, PageSize: 16})
+	o := c.Bucket("bucket").Object("obj")
+	if _, err := tests.ReadRange(context.Background(), o, 0, 64); err != nil {
+		t.Fatalf("ReadRange() failed: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.page"))
+	if err != nil || len(files) != 4 {
+		t.Fatalf("Glob() = %v, %v, want 4 files", files, err)
+	}
+	// Flip a byte of the data of one file and truncate another.
+	b, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)-1] ^= 0xff
+	if err := os.WriteFile(files[0], b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(files[1], 20); err != nil {
+		t.Fatal(err)
+	}
+
+	// The truncated file is dropped on reload and the flipped one on read.
+	c = newTestClient(t, mc, diskcache.Options{Dir: dir, PageSize: 16})
+	got, err := tests.ReadRange(context.Background(), c.Bucket("bucket").Object("obj"), 0, 64)
+	if err != nil {
+		t.Fatalf("ReadRange() failed: %v", err)
+	}
+	if diff := cmp.Diff(content, got); diff != "" {
+		t.Errorf("ReadRange() = (-want, +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(diskcache.Stats{Hits: 2, Misses: 2, Corrupt: 2, Pages: 4, Bytes: 4 * 28}, c.Stats()); diff != "" {
+		t.Errorf("Stats() = (-want, +got):\n%s", diff)
+	}
+}
+
+func TestDiskCache_Evict(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	content := tests.GenTestByteSeq(160)
+	mc := mem.NewClient()
+	mc.MemBucket("bucket").Put("obj", content)
+	// Each file is 28 bytes; 3 fit.
+	opts := diskcache.Options{Dir: dir, PageSize: 16, MaxBytes: 90}
+	c := newTestClient(t, mc, opts)
+	o := c.Bucket("bucket").Object("obj")
+	for _, page := range []int64{0, 1, 2, 0, 3, 4} {
+		if _, err := tests.ReadRange(context.Background(), o, page*16, 16); err != nil {
+			t.Fatalf("ReadRange(%d) failed: %v", page*16, err)
+		}
+	}
+	if diff := cmp.Diff(diskcache.Stats{Hits: 1, Misses: 5, Evictions: 2, Pages: 3, Bytes: 3 * 28}, c.Stats()); diff != "" {
+		t.Errorf("Stats() = (-want, +got):\n%s", diff)
+	}
+
+	// 0, 3 and 4 are left. A smaller cap keeps the most recent ones.
+	opts.MaxBytes = 60
+	c = newTestClient(t, mem.NewClient(), opts)
+	o = c.Bucket("bucket").Object("obj")
+	for _, tc := range []struct {
+		page    int64
+		wantErr error
+	}{
+		{0, fs.ErrNotExist},
+		{3, nil},
+		{4, nil},
+	} {
+		if _, err := tests.ReadRange(context.Background(), o, tc.page*16, 16); !errors.Is(err, tc.wantErr) {
+			t.Errorf("ReadRange(%d) error got %v, want %v", tc.page*16, err, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fault
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+)
+
+// A decorator of obj.Client that injects faults into range requests
+// according to rules. Random decisions are drawn from a seeded source,
+// so a sequential workload sees the same faults in every run.
+
+// ErrInjected is the error of injected failures.
+var ErrInjected = errors.New("fault: injected failure")
+
+// Kind is the kind of a fault.
+type Kind int
+
+const (
+	// None injects only Delay and Bandwidth.
+	None Kind = iota
+	// Error fails NewRangeReader with Rule.Err.
+	Error
+	// ShortRead ends the response with io.EOF after Rule.Bytes bytes.
+	ShortRead
+	// Drop fails a read with Rule.Err after Rule.Bytes bytes,
+	// like a broken connection.
+	Drop
+	// Corrupt inverts the bits of the byte at Rule.Bytes in the response.
+	Corrupt
+)
+
+var kindNames = []string{"none", "error", "short", "drop", "corrupt"}
+
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return "unknown"
+	}
+	return kindNames[k]
+}
+
+// Rule selects range requests and the fault injected into them.
+type Rule struct {
+	// Prefix selects objects whose names start with it.
+	Prefix string
+	// Skip is the number of matching requests passed through before
+	// the rule starts injecting.
+	Skip int
+	// Count is the maximum number of faults injected. Unlimited if 0.
+	Count int
+	// Rate is the probability of injecting into a matching request.
+	// 1 if 0.
+	Rate float64
+
+	// Kind is the kind of the fault.
+	Kind Kind
+	// Err is the error of Error and Drop faults.
+	// &obj.TransientError{Err: ErrInjected} for Error and
+	// io.ErrUnexpectedEOF for Drop if nil.
+	Err error
+	// Bytes is the offset in the response of ShortRead, Drop and Corrupt faults.
+	Bytes int64
+	// Delay delays the first byte of the response.
+	Delay time.Duration
+	// Bandwidth throttles the response to Bandwidth bytes per second.
+	// Unlimited if 0.
+	Bandwidth int64
+}
+
+func (r *Rule) err() error {
+	if r.Err != nil {
+		return r.Err
+	}
+	if r.Kind == Drop {
+		return io.ErrUnexpectedEOF
+	}
+	return &obj.TransientError{Err: ErrInjected}
+}
+
+// Options configures a Client.
+type Options struct {
+	// Seed seeds the random decisions of rules with Rate.
+	Seed int64
+	// Rules are evaluated in order for each range request. The first
+	// rule that decides to inject a fault is applied.
+	Rules []Rule
+}
+
+type Client struct {
+	c        obj.Client
+	rules    []Rule
+	injected int64
+
+	mu      sync.Mutex
+	rnd     *rand.Rand
+	matched []int
+	fired   []int
+}
+
+type Bucket struct {
+	c *Client
+	b obj.Bucket
+}
+
+type Object struct {
+	c    *Client
+	o    obj.Object
+	name string
+}
+
+// NewClient returns a client that injects faults into range requests made with c.
+// Closing the returned client closes c.
+func NewClient(c obj.Client, opts Options) obj.Client {
+	return &Client{
+		c:       c,
+		rules:   opts.Rules,
+		rnd:     rand.New(rand.NewSource(opts.Seed)),
+		matched: make([]int, len(opts.Rules)),
+		fired:   make([]int, len(opts.Rules)),
+	}
+}
+
+func (c *Client) Bucket(name string) obj.Bucket {
+	return &Bucket{c: c, b: c.c.Bucket(name)}
+}
+
+func (c *Client) Close() error {
+	return c.c.Close()
+}
+
+// Injected returns the number of faults injected.
+func (c *Client) Injected() int64 {
+	return atomic.LoadInt64(&c.injected)
+}
+
+// pick returns the rule applied to a range request of name, or nil.
+func (c *Client) pick(name string) *Rule {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.rules {
+		r := &c.rules[i]
+		if !strings.HasPrefix(name, r.Prefix) {
+			continue
+		}
+		c.matched[i]++
+		if c.matched[i] <= r.Skip || (r.Count > 0 && c.fired[i] >= r.Count) {
+			continue
+		}
+		if r.Rate > 0 && c.rnd.Float64() >= r.Rate {
+			continue
+		}
+		c.fired[i]++
+		atomic.AddInt64(&c.injected, 1)
+		return r
+	}
+	return nil
+}
+
+func (b *Bucket) Object(name string) obj.Object {
+	return &Object{c: b.c, o: b.b.Object(name), name: name}
+}
+
+func (b *Bucket) List(ctx context.Context, q *obj.Query) obj.ObjectIterator {
+	return b.b.List(ctx, q)
+}
+
+func (o *Object) Attrs(ctx context.Context) (*obj.ObjectAttrs, error) {
+	return o.o.Attrs(ctx)
+}
+
+func (o *Object) NewRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	r := o.c.pick(o.name)
+	if r == nil {
+		return o.o.NewRangeReader(ctx, offset, length)
+	}
+	if r.Kind == Error {
+		if err := sleep(ctx, r.Delay); err != nil {
+			return nil, err
+		}
+		return nil, r.err()
+	}
+	rd, err := o.o.NewRangeReader(ctx, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return &reader{ctx: ctx, rd: rd, rule: r}, nil
+}
+
+// reader injects the fault of rule into rd.
+type reader struct {
+	ctx   context.Context
+	rd    io.ReadCloser
+	rule  *Rule
+	start time.Time
+	n     int64
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	rule := r.rule
+	if r.start.IsZero() {
+		if err := sleep(r.ctx, rule.Delay); err != nil {
+			return 0, err
+		}
+		r.start = time.Now()
+	}
+	switch rule.Kind {
+	case ShortRead, Drop:
+		if r.n >= rule.Bytes {
+			if rule.Kind == ShortRead {
+				return 0, io.EOF
+			}
+			return 0, rule.err()
+		}
+		if rem := rule.Bytes - r.n; int64(len(p)) > rem {
+			p = p[:rem]
+		}
+	}
+
+	n, err := r.rd.Read(p)
+	if rule.Kind == Corrupt && r.n <= rule.Bytes && rule.Bytes < r.n+int64(n) {
+		p[rule.Bytes-r.n] ^= 0xff
+	}
+	r.n += int64(n)
+
+	if rule.Bandwidth > 0 {
+		due := r.start.Add(time.Duration(float64(r.n) / float64(rule.Bandwidth) * float64(time.Second)))
+		if serr := sleep(r.ctx, time.Until(due)); serr != nil {
+			return n, serr
+		}
+	}
+	return n, err
+}
+
+func (r *reader) Close() error {
+	return r.rd.Close()
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
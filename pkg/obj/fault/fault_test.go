@@ -0,0 +1,221 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fault_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/fault"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/mem"
+	"github.com/googlecloudplatform/pi-delivery/pkg/resultset"
+	"github.com/googlecloudplatform/pi-delivery/pkg/tests"
+	"github.com/googlecloudplatform/pi-delivery/pkg/unpack"
+)
+
+func newTestClient(t *testing.T, content []byte, opts fault.Options) *fault.Client {
+	t.Helper()
+	mc := mem.NewClient()
+	mc.MemBucket("bucket").Put("dir/obj", content)
+	mc.MemBucket("bucket").Put("other", content)
+	c := fault.NewClient(mc, opts).(*fault.Client)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestFault_Kinds(t *testing.T) {
+	t.Parallel()
+
+	content := tests.GenTestByteSeq(100)
+	corrupted := append([]byte{}, content[10:60]...)
+	corrupted[5] ^= 0xff
+
+	testCases := []struct {
+		name    string
+		rule    fault.Rule
+		want    []byte
+		wantErr error
+	}{
+		{"none", fault.Rule{}, content[10:60], nil},
+		{"error", fault.Rule{Kind: fault.Error}, nil, fault.ErrInjected},
+		{"custom error", fault.Rule{Kind: fault.Error, Err: &obj.NotExistError{Err: fault.ErrInjected}}, nil, fs.ErrNotExist},
+		{"short read", fault.Rule{Kind: fault.ShortRead, Bytes: 20}, content[10:30], nil},
+		{"drop", fault.Rule{Kind: fault.Drop, Bytes: 20}, content[10:30], io.ErrUnexpectedEOF},
+		{"drop at once", fault.Rule{Kind: fault.Drop}, []byte{}, io.ErrUnexpectedEOF},
+		{"corrupt", fault.Rule{Kind: fault.Corrupt, Bytes: 5}, corrupted, nil},
+		{"other object", fault.Rule{Prefix: "other", Kind: fault.Error}, content[10:60], nil},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			c := newTestClient(t, content, fault.Options{Rules: []fault.Rule{tc.rule}})
+			got, err := tests.ReadRange(context.Background(), c.Bucket("bucket").Object("dir/obj"), 10, 50)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("ReadRange() error got %v, want %v", err, tc.wantErr)
+			}
+			if diff := cmp.Diff(tc.want, got, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("ReadRange() = (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFault_Schedule(t *testing.T) {
+	t.Parallel()
+
+	content := tests.GenTestByteSeq(10)
+	run := func(rules []fault.Rule) []bool {
+		c := newTestClient(t, content, fault.Options{Seed: 42, Rules: rules})
+		var failed []bool
+		for i := 0; i < 20; i++ {
+			_, err := tests.ReadRange(context.Background(), c.Bucket("bucket").Object("dir/obj"), 0, -1)
+			failed = append(failed, err != nil)
+		}
+		return failed
+	}
+
+	got := run([]fault.Rule{{Kind: fault.Error, Skip: 2, Count: 3}})
+	want := make([]bool, 20)
+	want[2], want[3], want[4] = true, true, true
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("skip and count = (-want, +got):\n%s", diff)
+	}
+
+	rules := []fault.Rule{{Kind: fault.Error, Rate: 0.5}}
+	first := run(rules)
+	if diff := cmp.Diff(first, run(rules)); diff != "" {
+		t.Errorf("the same seed = (-want, +got):\n%s", diff)
+	}
+	n := 0
+	for _, f := range first {
+		if f {
+			n++
+		}
+	}
+	if n == 0 || n == len(first) {
+		t.Errorf("rate 0.5 = got %d faults in %d requests", n, len(first))
+	}
+}
+
+func TestFault_Latency(t *testing.T) {
+	t.Parallel()
+
+	content := tests.GenTestByteSeq(1000)
+	c := newTestClient(t, content, fault.Options{Rules: []fault.Rule{
+		{Prefix: "dir/", Delay: 50 * time.Millisecond},
+		{Bandwidth: 10000},
+	}})
+	bucket := c.Bucket("bucket")
+
+	start := time.Now()
+	rd, err := bucket.Object("dir/obj").NewRangeReader(context.Background(), 0, -1)
+	if err != nil {
+		t.Fatalf("NewRangeReader() failed: %v", err)
+	}
+	if d := time.Since(start); d >= 50*time.Millisecond {
+		t.Errorf("NewRangeReader() took %v, want the delay at the first read", d)
+	}
+	if _, err := io.ReadAll(rd); err != nil {
+		t.Errorf("ReadAll() failed: %v", err)
+	}
+	rd.Close()
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("first byte = got %v, want >= 50ms", d)
+	}
+
+	// 1000 bytes at 10000 bytes/s.
+	start = time.Now()
+	if _, err := tests.ReadRange(context.Background(), bucket.Object("other"), 0, -1); err != nil {
+		t.Errorf("ReadRange() failed: %v", err)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("throttled read = got %v, want >= 100ms", d)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := tests.ReadRange(ctx, bucket.Object("dir/obj"), 0, -1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ReadRange(deadline) error got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+// TestFault_ResultSet checks that resultset.Reader recovers from dropped
+// connections and transient errors.
+func TestFault_ResultSet(t *testing.T) {
+	t.Parallel()
+
+	mc := mem.NewClient()
+	set, err := tests.NewResultSet(mc.MemBucket("bucket"), tests.PiDecimal, 10, 300)
+	if err != nil {
+		t.Fatalf("NewResultSet() failed: %v", err)
+	}
+	c := fault.NewClient(mc, fault.Options{Seed: 1, Rules: []fault.Rule{
+		{Kind: fault.Error, Count: 2},
+		{Kind: fault.Drop, Bytes: 40, Rate: 0.5, Count: 5},
+	}}).(*fault.Client)
+
+	ctx := context.Background()
+	rd := set.NewReaderWithOptions(ctx, c.Bucket("bucket"), resultset.ReaderOptions{
+		Retry: resultset.RetryPolicy{
+			MaxAttempts:    10,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     time.Millisecond,
+			Multiplier:     1,
+		},
+	})
+	defer rd.Close()
+	got, err := io.ReadAll(unpack.NewReader(ctx, rd))
+	if err != nil {
+		t.Fatalf("ReadAll() failed: %v", err)
+	}
+	if diff := cmp.Diff(tests.PiDecimal[2:], string(got)); diff != "" {
+		t.Errorf("ReadAll() = (-want, +got):\n%s", diff)
+	}
+	if got := c.Injected(); got < 3 {
+		t.Errorf("Injected() = got %d, want >= 3", got)
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	t.Parallel()
+
+	got, err := fault.ParseRules("kind=error, rate=0.1; prefix=Pi - Dec,kind=drop,bytes=4096,count=2,skip=1;delay=1s,bandwidth=1000;kind=notfound;")
+	if err != nil {
+		t.Fatalf("ParseRules() failed: %v", err)
+	}
+	want := []fault.Rule{
+		{Kind: fault.Error, Rate: 0.1},
+		{Prefix: "Pi - Dec", Kind: fault.Drop, Bytes: 4096, Count: 2, Skip: 1},
+		{Delay: time.Second, Bandwidth: 1000},
+		{Kind: fault.Error, Err: fs.ErrNotExist},
+	}
+	if diff := cmp.Diff(want, got, cmpopts.EquateErrors()); diff != "" {
+		t.Errorf("ParseRules() = (-want, +got):\n%s", diff)
+	}
+
+	for _, spec := range []string{"kind", "kind=boom", "rate=2", "delay=1", "color=red"} {
+		if _, err := fault.ParseRules(spec); !errors.Is(err, fault.ErrInvalidRule) {
+			t.Errorf("ParseRules(%q) error got %v, want %v", spec, err, fault.ErrInvalidRule)
+		}
+	}
+}
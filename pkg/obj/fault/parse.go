@@ -0,0 +1,116 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fault
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+)
+
+// ErrInvalidRule is returned by ParseRules for a malformed rule.
+var ErrInvalidRule = errors.New("fault: invalid rule")
+
+// ParseRules parses rules separated by semicolons. Each rule is a list
+// of comma-separated key=value pairs:
+//   - prefix: Rule.Prefix
+//   - skip, count: Rule.Skip and Rule.Count
+//   - rate: Rule.Rate, e.g. 0.1
+//   - kind: none, error, notfound, fatal, short, drop or corrupt.
+//     error injects a transient error, notfound an error matching
+//     fs.ErrNotExist and fatal a permanent error.
+//   - bytes: Rule.Bytes
+//   - delay: Rule.Delay, e.g. 200ms
+//   - bandwidth: Rule.Bandwidth in bytes per second
+//
+// For example, "kind=error,rate=0.1;kind=drop,bytes=4096,count=2;delay=1s".
+func ParseRules(spec string) ([]Rule, error) {
+	var rules []Rule
+	for _, rs := range strings.Split(spec, ";") {
+		rs = strings.TrimSpace(rs)
+		if rs == "" {
+			continue
+		}
+		rule, err := parseRule(rs)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseRule(s string) (Rule, error) {
+	var rule Rule
+	for _, kv := range strings.Split(s, ",") {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			return rule, fmt.Errorf("%w: %q is not key=value", ErrInvalidRule, kv)
+		}
+		key, value := strings.TrimSpace(kv[:i]), strings.TrimSpace(kv[i+1:])
+		var err error
+		switch key {
+		case "prefix":
+			rule.Prefix = value
+		case "skip":
+			rule.Skip, err = strconv.Atoi(value)
+		case "count":
+			rule.Count, err = strconv.Atoi(value)
+		case "rate":
+			rule.Rate, err = strconv.ParseFloat(value, 64)
+			if err == nil && (rule.Rate < 0 || rule.Rate > 1) {
+				err = errors.New("out of range")
+			}
+		case "kind":
+			err = rule.setKind(value)
+		case "bytes":
+			rule.Bytes, err = strconv.ParseInt(value, 10, 64)
+		case "delay":
+			rule.Delay, err = time.ParseDuration(value)
+		case "bandwidth":
+			rule.Bandwidth, err = strconv.ParseInt(value, 10, 64)
+		default:
+			err = errors.New("unknown key")
+		}
+		if err != nil {
+			return rule, fmt.Errorf("%w: %s: %v", ErrInvalidRule, kv, err)
+		}
+	}
+	return rule, nil
+}
+
+func (r *Rule) setKind(value string) error {
+	switch value {
+	case "notfound":
+		r.Kind = Error
+		r.Err = &obj.NotExistError{Err: ErrInjected}
+		return nil
+	case "fatal":
+		r.Kind = Error
+		r.Err = ErrInjected
+		return nil
+	}
+	for k, name := range kindNames {
+		if name == value {
+			r.Kind = Kind(k)
+			return nil
+		}
+	}
+	return errors.New("unknown kind")
+}
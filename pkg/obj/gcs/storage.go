@@ -16,10 +16,14 @@ package gcs
 
 import (
 	"context"
+	"errors"
 	"io"
+	"net/http"
 
 	"cloud.google.com/go/storage"
 	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
@@ -58,6 +62,79 @@ func (b *Bucket) Object(name string) obj.Object {
 	return &Object{h: b.h.Object(name)}
 }
 
+// List returns an iterator over objects selected by q.
+func (b *Bucket) List(ctx context.Context, q *obj.Query) obj.ObjectIterator {
+	query := &storage.Query{}
+	pageSize := 0
+	if q != nil {
+		query.Prefix = q.Prefix
+		query.StartOffset = q.StartOffset
+		pageSize = q.PageSize
+	}
+	query.SetAttrSelection([]string{"Name", "Size", "CRC32C", "Generation", "Etag", "Updated"})
+	it := b.h.Objects(ctx, query)
+	it.PageInfo().MaxSize = pageSize
+	return &ObjectIterator{it: it}
+}
+
+// ObjectIterator iterates over objects in a bucket.
+type ObjectIterator struct {
+	it *storage.ObjectIterator
+}
+
+func (it *ObjectIterator) Next() (*obj.ObjectAttrs, error) {
+	attrs, err := it.it.Next()
+	if err == iterator.Done {
+		return nil, obj.Done
+	}
+	if err != nil {
+		return nil, classify(err)
+	}
+	return convertAttrs(attrs), nil
+}
+
+func (o *Object) Attrs(ctx context.Context) (*obj.ObjectAttrs, error) {
+	attrs, err := o.h.Attrs(ctx)
+	if err != nil {
+		return nil, classify(err)
+	}
+	return convertAttrs(attrs), nil
+}
+
+func convertAttrs(attrs *storage.ObjectAttrs) *obj.ObjectAttrs {
+	return &obj.ObjectAttrs{
+		Name:       attrs.Name,
+		Size:       attrs.Size,
+		CRC32C:     attrs.CRC32C,
+		HasCRC32C:  true,
+		Generation: attrs.Generation,
+		ETag:       attrs.Etag,
+		Updated:    attrs.Updated,
+	}
+}
+
 func (o *Object) NewRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
-	return o.h.NewRangeReader(ctx, offset, length)
+	rd, err := o.h.NewRangeReader(ctx, offset, length)
+	if err != nil {
+		return nil, classify(err)
+	}
+	return rd, nil
+}
+
+// classify wraps err with obj.TransientError if the request can be retried,
+// or with obj.NotExistError if the bucket or the object doesn't exist.
+func classify(err error) error {
+	if errors.Is(err, storage.ErrObjectNotExist) || errors.Is(err, storage.ErrBucketNotExist) {
+		return &obj.NotExistError{Err: err}
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.Code == http.StatusRequestTimeout,
+			apiErr.Code == http.StatusTooManyRequests,
+			apiErr.Code >= http.StatusInternalServerError:
+			return &obj.TransientError{Err: err}
+		}
+	}
+	return err
 }
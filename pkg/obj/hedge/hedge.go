@@ -0,0 +1,359 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hedge
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+)
+
+// A decorator of obj.Client that hedges slow range requests.
+// If a range request doesn't produce its first byte within a delay,
+// a second request of the same range is issued and whichever produces
+// the first byte first is used. The other one is canceled.
+// The delay is a percentile of recent first byte latencies.
+
+const (
+	// DefaultPercentile is the default percentile of the hedge delay.
+	DefaultPercentile = 0.95
+	// DefaultBudget is the default fraction of requests that can be hedged.
+	DefaultBudget = 0.05
+	// DefaultInitialDelay is the default hedge delay until enough
+	// latencies are observed.
+	DefaultInitialDelay = 100 * time.Millisecond
+	// DefaultMinDelay is the default lower bound of the hedge delay.
+	DefaultMinDelay = 5 * time.Millisecond
+	// DefaultMaxDelay is the default upper bound of the hedge delay.
+	DefaultMaxDelay = 2 * time.Second
+
+	// samples is the number of recent latencies the delay is computed from.
+	samples = 256
+	// minSamples is the number of latencies needed to compute the delay.
+	minSamples = 32
+	// updateInterval is the number of new latencies the delay is recomputed after.
+	updateInterval = 16
+	// budgetBurst is the maximum number of hedges saved up in the budget.
+	budgetBurst = 10
+	// firstReadSize is the size of the read the first byte is awaited with.
+	firstReadSize = 32 * 1024
+)
+
+// Options configures a Client.
+type Options struct {
+	// Percentile of recent first byte latencies, in (0, 1], a request is
+	// hedged after. DefaultPercentile if 0.
+	Percentile float64
+	// Budget is the maximum fraction of requests that are hedged.
+	// Each request earns Budget of a hedge, up to a small burst.
+	// DefaultBudget if 0. Negative disables hedging.
+	Budget float64
+	// InitialDelay is the hedge delay until enough latencies are observed.
+	// DefaultInitialDelay if 0.
+	InitialDelay time.Duration
+	// MinDelay and MaxDelay bound the hedge delay.
+	// DefaultMinDelay and DefaultMaxDelay if 0.
+	MinDelay time.Duration
+	MaxDelay time.Duration
+}
+
+// Stats are counters of a Client.
+type Stats struct {
+	// Requests is the number of range requests.
+	Requests int64
+	// Hedged is the number of range requests that were hedged.
+	Hedged int64
+	// HedgeWins is the number of hedged requests won by the hedge.
+	HedgeWins int64
+}
+
+type Client struct {
+	c     obj.Client
+	opts  Options
+	stats Stats
+
+	mu      sync.Mutex
+	tokens  float64
+	lat     []time.Duration
+	next    int
+	pending int
+	delay   time.Duration
+}
+
+type Bucket struct {
+	c *Client
+	b obj.Bucket
+}
+
+type Object struct {
+	c *Client
+	o obj.Object
+}
+
+// NewClient returns a client that hedges range requests made with c.
+// Closing the returned client closes c.
+func NewClient(c obj.Client, opts Options) obj.Client {
+	if opts.Percentile <= 0 || opts.Percentile > 1 {
+		opts.Percentile = DefaultPercentile
+	}
+	if opts.Budget == 0 {
+		opts.Budget = DefaultBudget
+	}
+	if opts.InitialDelay <= 0 {
+		opts.InitialDelay = DefaultInitialDelay
+	}
+	if opts.MinDelay <= 0 {
+		opts.MinDelay = DefaultMinDelay
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = DefaultMaxDelay
+	}
+	return &Client{
+		c:     c,
+		opts:  opts,
+		lat:   make([]time.Duration, 0, samples),
+		delay: opts.InitialDelay,
+	}
+}
+
+func (c *Client) Bucket(name string) obj.Bucket {
+	return &Bucket{c: c, b: c.c.Bucket(name)}
+}
+
+func (c *Client) Close() error {
+	return c.c.Close()
+}
+
+// Stats returns the counters of c.
+func (c *Client) Stats() Stats {
+	return Stats{
+		Requests:  atomic.LoadInt64(&c.stats.Requests),
+		Hedged:    atomic.LoadInt64(&c.stats.Hedged),
+		HedgeWins: atomic.LoadInt64(&c.stats.HedgeWins),
+	}
+}
+
+// Delay returns the current hedge delay.
+func (c *Client) Delay() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.delay
+}
+
+// earn adds a request to the budget.
+func (c *Client) earn() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokens += c.opts.Budget
+	if c.tokens > budgetBurst {
+		c.tokens = budgetBurst
+	}
+}
+
+// spend takes a hedge from the budget if there is one.
+func (c *Client) spend() bool {
+	if c.opts.Budget < 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tokens < 1 {
+		return false
+	}
+	c.tokens--
+	return true
+}
+
+// observe records the first byte latency of a request.
+func (c *Client) observe(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.lat) < samples {
+		c.lat = append(c.lat, d)
+	} else {
+		c.lat[c.next] = d
+		c.next = (c.next + 1) % samples
+	}
+	c.pending++
+	if len(c.lat) < minSamples || c.pending < updateInterval {
+		return
+	}
+	c.pending = 0
+
+	sorted := make([]time.Duration, len(c.lat))
+	copy(sorted, c.lat)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	delay := sorted[int(c.opts.Percentile*float64(len(sorted)-1))]
+	if delay < c.opts.MinDelay {
+		delay = c.opts.MinDelay
+	}
+	if delay > c.opts.MaxDelay {
+		delay = c.opts.MaxDelay
+	}
+	c.delay = delay
+}
+
+func (b *Bucket) Object(name string) obj.Object {
+	return &Object{c: b.c, o: b.b.Object(name)}
+}
+
+func (b *Bucket) List(ctx context.Context, q *obj.Query) obj.ObjectIterator {
+	return b.b.List(ctx, q)
+}
+
+func (o *Object) Attrs(ctx context.Context) (*obj.ObjectAttrs, error) {
+	return o.o.Attrs(ctx)
+}
+
+// attempt is a range request that has produced its first bytes or failed.
+type attempt struct {
+	id     int
+	rd     *reader
+	err    error
+	hedge  bool
+	cancel context.CancelFunc
+}
+
+// NewRangeReader issues a range request and, if the first byte doesn't
+// arrive within the hedge delay and the budget allows, a hedge request.
+// It returns the reader of whichever request produces the first byte
+// first and cancels the other. An error is returned only when all the
+// issued requests fail.
+func (o *Object) NewRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	c := o.c
+	atomic.AddInt64(&c.stats.Requests, 1)
+	c.earn()
+
+	results := make(chan *attempt, 2)
+	var cancels []context.CancelFunc
+	start := func(hedge bool) {
+		actx, cancel := context.WithCancel(ctx)
+		a := &attempt{id: len(cancels), hedge: hedge, cancel: cancel}
+		cancels = append(cancels, cancel)
+		go func() {
+			a.rd, a.err = o.first(actx, offset, length)
+			results <- a
+		}()
+	}
+	// discard cancels the attempts other than winner and closes
+	// the readers of the pending ones.
+	discard := func(winner, pending int) {
+		for id, cancel := range cancels {
+			if id != winner {
+				cancel()
+			}
+		}
+		for ; pending > 0; pending-- {
+			if a := <-results; a.rd != nil {
+				a.rd.Close()
+			}
+		}
+	}
+
+	start(false)
+	timer := time.NewTimer(c.Delay())
+	defer timer.Stop()
+
+	var firstErr error
+	pending := 1
+	for {
+		select {
+		case <-timer.C:
+			if c.spend() {
+				atomic.AddInt64(&c.stats.Hedged, 1)
+				start(true)
+				pending++
+			}
+		case a := <-results:
+			pending--
+			if a.err != nil {
+				a.cancel()
+				if firstErr == nil {
+					firstErr = a.err
+				}
+				if pending > 0 {
+					continue
+				}
+				return nil, firstErr
+			}
+			if a.hedge {
+				atomic.AddInt64(&c.stats.HedgeWins, 1)
+			}
+			a.rd.cancel = a.cancel
+			if pending > 0 {
+				go discard(a.id, pending)
+			}
+			return a.rd, nil
+		case <-ctx.Done():
+			discard(-1, pending)
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// first issues a range request and waits for its first bytes.
+func (o *Object) first(ctx context.Context, offset, length int64) (*reader, error) {
+	start := time.Now()
+	rd, err := o.o.NewRangeReader(ctx, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	size := int64(firstReadSize)
+	if length >= 0 && length < size {
+		size = length
+	}
+	head := make([]byte, size)
+	n, err := rd.Read(head)
+	if n == 0 && err != nil && err != io.EOF {
+		rd.Close()
+		return nil, err
+	}
+	o.c.observe(time.Since(start))
+	return &reader{head: bytes.NewReader(head[:n]), err: err, rd: rd}, nil
+}
+
+// reader returns the first bytes and then reads the rest from rd.
+type reader struct {
+	head *bytes.Reader
+	// err is the error the first read returned with the first bytes.
+	err    error
+	rd     io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.head.Len() > 0 {
+		return r.head.Read(p)
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	return r.rd.Read(p)
+}
+
+// Close closes the request and releases its context.
+func (r *reader) Close() error {
+	err := r.rd.Close()
+	if r.cancel != nil {
+		r.cancel()
+	}
+	return err
+}
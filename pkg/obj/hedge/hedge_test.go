@@ -0,0 +1,197 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hedge_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/hedge"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/mem"
+	"github.com/googlecloudplatform/pi-delivery/pkg/tests"
+)
+
+// slowClient delays the range requests by the delay of each call.
+type slowClient struct {
+	obj.Client
+	delay    func(call int64) time.Duration
+	calls    int64
+	canceled int64
+}
+
+type slowBucket struct {
+	obj.Bucket
+	c *slowClient
+}
+
+type slowObject struct {
+	obj.Object
+	c *slowClient
+}
+
+func (c *slowClient) Bucket(name string) obj.Bucket {
+	return &slowBucket{Bucket: c.Client.Bucket(name), c: c}
+}
+
+func (b *slowBucket) Object(name string) obj.Object {
+	return &slowObject{Object: b.Bucket.Object(name), c: b.c}
+}
+
+func (o *slowObject) NewRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	call := atomic.AddInt64(&o.c.calls, 1) - 1
+	select {
+	case <-time.After(o.c.delay(call)):
+	case <-ctx.Done():
+		atomic.AddInt64(&o.c.canceled, 1)
+		return nil, ctx.Err()
+	}
+	return o.Object.NewRangeReader(ctx, offset, length)
+}
+
+func newTestClient(t *testing.T, content []byte, delay func(int64) time.Duration, opts hedge.Options) (*hedge.Client, *slowClient) {
+	t.Helper()
+	mc := mem.NewClient()
+	mc.MemBucket("bucket").Put("obj", content)
+	sc := &slowClient{Client: mc, delay: delay}
+	c := hedge.NewClient(sc, opts).(*hedge.Client)
+	t.Cleanup(func() { c.Close() })
+	return c, sc
+}
+
+func TestHedge_SlowFirst(t *testing.T) {
+	t.Parallel()
+
+	content := tests.GenTestByteSeq(100000)
+	delay := func(call int64) time.Duration {
+		if call == 0 {
+			return time.Hour
+		}
+		return 0
+	}
+	c, sc := newTestClient(t, content, delay, hedge.Options{Budget: 1, InitialDelay: 10 * time.Millisecond})
+	object := c.Bucket("bucket").Object("obj")
+
+	got, err := tests.ReadRange(context.Background(), object, 10, 50000)
+	if err != nil {
+		t.Fatalf("ReadRange() failed: %v", err)
+	}
+	if diff := cmp.Diff(content[10:50010], got); diff != "" {
+		t.Errorf("ReadRange() = (-want, +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(hedge.Stats{Requests: 1, Hedged: 1, HedgeWins: 1}, c.Stats()); diff != "" {
+		t.Errorf("Stats() = (-want, +got):\n%s", diff)
+	}
+	for i := 0; atomic.LoadInt64(&sc.canceled) != 1; i++ {
+		if i > 1000 {
+			t.Fatal("the slow request wasn't canceled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHedge_Budget(t *testing.T) {
+	t.Parallel()
+
+	content := tests.GenTestByteSeq(100)
+	// Every request is slower than the delay.
+	c, _ := newTestClient(t, content, func(int64) time.Duration { return 20 * time.Millisecond },
+		hedge.Options{Budget: 0.5, InitialDelay: time.Millisecond})
+	object := c.Bucket("bucket").Object("obj")
+
+	for i := 0; i < 10; i++ {
+		got, err := tests.ReadRange(context.Background(), object, 0, 10)
+		if err != nil {
+			t.Fatalf("ReadRange() failed: %v", err)
+		}
+		if diff := cmp.Diff(content[:10], got); diff != "" {
+			t.Errorf("ReadRange() = (-want, +got):\n%s", diff)
+		}
+	}
+	if got := c.Stats().Hedged; got != 5 {
+		t.Errorf("Hedged = got %d, want 5", got)
+	}
+
+	c, sc := newTestClient(t, content, func(int64) time.Duration { return 20 * time.Millisecond },
+		hedge.Options{Budget: -1, InitialDelay: time.Millisecond})
+	object = c.Bucket("bucket").Object("obj")
+	for i := 0; i < 3; i++ {
+		if _, err := tests.ReadRange(context.Background(), object, 0, 10); err != nil {
+			t.Fatalf("ReadRange() failed: %v", err)
+		}
+	}
+	if got := atomic.LoadInt64(&sc.calls); got != 3 {
+		t.Errorf("upstream requests = got %d, want 3", got)
+	}
+}
+
+func TestHedge_Delay(t *testing.T) {
+	t.Parallel()
+
+	content := tests.GenTestByteSeq(100)
+	opts := hedge.Options{
+		InitialDelay: time.Second,
+		MinDelay:     3 * time.Millisecond,
+	}
+	c, _ := newTestClient(t, content, func(int64) time.Duration { return 0 }, opts)
+	object := c.Bucket("bucket").Object("obj")
+
+	if got := c.Delay(); got != time.Second {
+		t.Errorf("Delay() = got %v, want %v", got, time.Second)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := tests.ReadRange(context.Background(), object, 0, 10); err != nil {
+			t.Fatalf("ReadRange() failed: %v", err)
+		}
+	}
+	if got := c.Delay(); got != opts.MinDelay {
+		t.Errorf("Delay() = got %v, want %v", got, opts.MinDelay)
+	}
+}
+
+func TestHedge_Errors(t *testing.T) {
+	t.Parallel()
+
+	content := tests.GenTestByteSeq(100)
+	c, sc := newTestClient(t, content, func(int64) time.Duration { return 0 }, hedge.Options{Budget: 1})
+	bucket := c.Bucket("bucket")
+
+	if _, err := tests.ReadRange(context.Background(), bucket.Object("missing"), 0, 1); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("NewRangeReader(missing) error got %v, want %v", err, fs.ErrNotExist)
+	}
+	if _, err := tests.ReadRange(context.Background(), bucket.Object("obj"), 100, 1); err != io.EOF {
+		t.Errorf("NewRangeReader(past the end) error got %v, want %v", err, io.EOF)
+	}
+	if got := atomic.LoadInt64(&sc.calls); got != 2 {
+		t.Errorf("upstream requests = got %d, want 2", got)
+	}
+
+	c, _ = newTestClient(t, content, func(int64) time.Duration { return time.Hour },
+		hedge.Options{Budget: 1, InitialDelay: time.Millisecond})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := tests.ReadRange(ctx, c.Bucket("bucket").Object("obj"), 0, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("NewRangeReader(deadline) error got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if got := c.Stats().Hedged; got != 1 {
+		t.Errorf("Hedged = got %d, want 1", got)
+	}
+}
@@ -0,0 +1,409 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpobj
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+)
+
+// Implementations for plain HTTP servers and CDNs that support range requests.
+// A bucket is a path under the base URL and an object is a path in the bucket.
+
+var ErrInvalidURL = errors.New("httpobj: invalid URL")
+var ErrInvalidName = errors.New("httpobj: invalid bucket or object name")
+var ErrInvalidRange = errors.New("httpobj: invalid range")
+var ErrRangeNotSupported = errors.New("httpobj: server doesn't support range requests")
+var ErrInvalidResponse = errors.New("httpobj: invalid response")
+var ErrListNotSupported = errors.New("httpobj: listing objects is not supported over HTTP")
+
+// maxDrainBytes is the maximum number of unread bytes discarded on Close
+// so that the connection can be reused.
+const maxDrainBytes = 64 * 1024
+
+// Options configures a Client.
+type Options struct {
+	// HTTPClient makes the requests. A client with a transport that keeps
+	// enough idle connections for concurrent range requests if nil.
+	HTTPClient *http.Client
+	// Header is added to every request, e.g. Authorization.
+	Header http.Header
+}
+
+// StatusError is returned for an unexpected HTTP status.
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("httpobj: %s %s: %s", e.Method, e.URL, e.Status)
+}
+
+type Client struct {
+	base   *url.URL
+	client *http.Client
+	header http.Header
+	// ownTransport is closed on Close.
+	ownTransport *http.Transport
+}
+
+type Bucket struct {
+	c    *Client
+	base *url.URL
+	err  error
+}
+
+type Object struct {
+	c    *Client
+	name string
+	url  string
+	err  error
+}
+
+// NewClient returns a new client for objects under baseURL.
+// Buckets are paths under baseURL. Use "." as the bucket name for baseURL itself.
+func NewClient(baseURL string, opts Options) (obj.Client, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	if (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidURL, baseURL)
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+		base.RawPath = ""
+	}
+
+	c := &Client{
+		base:   base,
+		client: opts.HTTPClient,
+		header: opts.Header.Clone(),
+	}
+	if c.client == nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		// The default of 2 idle connections per host would drop most
+		// connections of parallel range requests.
+		t.MaxIdleConnsPerHost = 64
+		c.client = &http.Client{Transport: t}
+		c.ownTransport = t
+	}
+	return c, nil
+}
+
+// resolve returns the URL of name relative to base.
+// Each segment of name is escaped, so names can contain spaces and '#'.
+func resolve(base *url.URL, name string, dir bool) (*url.URL, error) {
+	if !fs.ValidPath(name) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	if name == "." {
+		return base, nil
+	}
+	segments := strings.Split(name, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	ref := strings.Join(segments, "/")
+	if dir {
+		ref += "/"
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidName, err)
+	}
+	return base.ResolveReference(u), nil
+}
+
+func (c *Client) Bucket(name string) obj.Bucket {
+	u, err := resolve(c.base, name, true)
+	return &Bucket{c: c, base: u, err: err}
+}
+
+// Close closes idle connections of the client's own transport.
+func (c *Client) Close() error {
+	if c.ownTransport != nil {
+		c.ownTransport.CloseIdleConnections()
+	}
+	return nil
+}
+
+func (b *Bucket) Object(name string) obj.Object {
+	if b.err != nil {
+		return &Object{c: b.c, err: b.err}
+	}
+	u, err := resolve(b.base, name, false)
+	if err != nil {
+		return &Object{c: b.c, err: err}
+	}
+	return &Object{c: b.c, name: name, url: u.String()}
+}
+
+// List always fails with ErrListNotSupported because plain HTTP servers
+// have no standard way to list files.
+func (b *Bucket) List(ctx context.Context, q *obj.Query) obj.ObjectIterator {
+	return errIterator{ErrListNotSupported}
+}
+
+// errIterator always fails with err.
+type errIterator struct {
+	err error
+}
+
+func (it errIterator) Next() (*obj.ObjectAttrs, error) {
+	return nil, it.err
+}
+
+// Attrs returns the attributes of the object from the headers of a HEAD request.
+// CRC32C is set if the server returns an x-goog-hash header like Cloud Storage does.
+func (o *Object) Attrs(ctx context.Context) (*obj.ObjectAttrs, error) {
+	if o.err != nil {
+		return nil, o.err
+	}
+	req, err := o.newRequest(ctx, http.MethodHead)
+	if err != nil {
+		return nil, err
+	}
+	res, err := o.c.client.Do(req)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+	discard(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return nil, statusError(o.url, res)
+	}
+
+	attrs := &obj.ObjectAttrs{
+		Name: o.name,
+		Size: res.ContentLength,
+		ETag: res.Header.Get("ETag"),
+	}
+	if t, err := http.ParseTime(res.Header.Get("Last-Modified")); err == nil {
+		attrs.Updated = t
+	}
+	if g, err := strconv.ParseInt(res.Header.Get("X-Goog-Generation"), 10, 64); err == nil {
+		attrs.Generation = g
+	}
+	for _, v := range res.Header.Values("X-Goog-Hash") {
+		for _, h := range strings.Split(v, ",") {
+			if b64 := strings.TrimPrefix(strings.TrimSpace(h), "crc32c="); b64 != h {
+				if b, err := base64.StdEncoding.DecodeString(b64); err == nil && len(b) == 4 {
+					attrs.CRC32C = binary.BigEndian.Uint32(b)
+					attrs.HasCRC32C = true
+				}
+			}
+		}
+	}
+	return attrs, nil
+}
+
+// newRequest returns a request for the object with the client's headers.
+func (o *Object) newRequest(ctx context.Context, method string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, o.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range o.c.header {
+		req.Header[k] = v
+	}
+	return req, nil
+}
+
+// NewRangeReader returns a reader for the section [offset, offset+length) of the object,
+// or to the end of the object if length is negative, with a GET request with a Range header.
+// The section is truncated at the end of the object. It returns io.EOF if
+// offset is at or past the end of the object, and an error that matches
+// fs.ErrNotExist if the object doesn't exist.
+// Throttling and server errors are returned as obj.TransientError.
+// A body shorter than the section fails with io.ErrUnexpectedEOF.
+func (o *Object) NewRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	if o.err != nil {
+		return nil, o.err
+	}
+	if offset < 0 {
+		return nil, fmt.Errorf("%w: negative offset %d", ErrInvalidRange, offset)
+	}
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	req, err := o.newRequest(ctx, http.MethodGet)
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	} else {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	}
+	// Ranges of a compressed representation aren't ranges of the object.
+	req.Header.Set("Accept-Encoding", "identity")
+
+	res, err := o.c.client.Do(req)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+
+	var size int64
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+		start, end, err := parseContentRange(res.Header.Get("Content-Range"))
+		if err == nil && (start != offset || (length >= 0 && end >= offset+length)) {
+			err = fmt.Errorf("%w: Content-Range %q for range [%d, %d)",
+				ErrInvalidResponse, res.Header.Get("Content-Range"), offset, offset+length)
+		}
+		if err != nil {
+			discard(res.Body)
+			return nil, err
+		}
+		size = end - start + 1
+	case http.StatusOK:
+		// The server ignored the Range header and sends the whole object.
+		if offset != 0 {
+			discard(res.Body)
+			return nil, fmt.Errorf("%w: %s", ErrRangeNotSupported, o.url)
+		}
+		size = res.ContentLength
+		if length >= 0 && (size < 0 || size > length) {
+			size = length
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		discard(res.Body)
+		return nil, io.EOF
+	default:
+		discard(res.Body)
+		return nil, statusError(o.url, res)
+	}
+
+	if res.StatusCode == http.StatusPartialContent && res.ContentLength >= 0 && res.ContentLength != size {
+		discard(res.Body)
+		return nil, fmt.Errorf("%w: Content-Length %d for Content-Range of %d bytes",
+			ErrInvalidResponse, res.ContentLength, size)
+	}
+	// A 200 response may carry more of the object than was asked for.
+	longer := res.StatusCode == http.StatusOK && (res.ContentLength < 0 || res.ContentLength > size)
+	return &bodyReader{body: res.Body, remaining: size, longer: longer}, nil
+}
+
+// statusError returns an error for an unexpected status of res.
+func statusError(u string, res *http.Response) error {
+	err := &StatusError{Method: res.Request.Method, URL: u, StatusCode: res.StatusCode, Status: res.Status}
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return &obj.NotExistError{Err: err}
+	case res.StatusCode == http.StatusRequestTimeout,
+		res.StatusCode == http.StatusTooManyRequests,
+		res.StatusCode >= http.StatusInternalServerError:
+		return &obj.TransientError{Err: err}
+	}
+	return err
+}
+
+// parseContentRange parses a Content-Range header value "bytes start-end/size".
+func parseContentRange(s string) (start, end int64, err error) {
+	invalid := fmt.Errorf("%w: Content-Range %q", ErrInvalidResponse, s)
+	r := strings.TrimPrefix(s, "bytes ")
+	if r == s {
+		return 0, 0, invalid
+	}
+	if i := strings.IndexByte(r, '/'); i >= 0 {
+		r = r[:i]
+	}
+	i := strings.IndexByte(r, '-')
+	if i < 0 {
+		return 0, 0, invalid
+	}
+	start, err = strconv.ParseInt(r[:i], 10, 64)
+	if err != nil {
+		return 0, 0, invalid
+	}
+	end, err = strconv.ParseInt(r[i+1:], 10, 64)
+	if err != nil || end < start {
+		return 0, 0, invalid
+	}
+	return start, end, nil
+}
+
+// discard reads and closes body so the connection can be reused.
+func discard(body io.ReadCloser) {
+	io.CopyN(io.Discard, body, maxDrainBytes)
+	body.Close()
+}
+
+// bodyReader reads the expected number of bytes of a response body.
+// It fails with io.ErrUnexpectedEOF if the body is short and stops at the
+// expected length if the body is long.
+type bodyReader struct {
+	body io.ReadCloser
+	// longer is set if the body may be longer than the bytes expected.
+	longer bool
+
+	// mu serializes Read and Close.
+	mu sync.Mutex
+	// remaining is the number of bytes expected, or negative if unknown.
+	remaining int64
+}
+
+func (r *bodyReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+	if r.remaining > 0 && int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.body.Read(p)
+	if r.remaining > 0 {
+		r.remaining -= int64(n)
+		if err == io.EOF && r.remaining > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	return n, err
+}
+
+// Close closes the body. Short remainders are read first so that
+// the connection can be reused, unless the body is longer than expected.
+func (r *bodyReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.longer || r.remaining < 0 || r.remaining > maxDrainBytes {
+		return r.body.Close()
+	}
+	io.CopyN(io.Discard, r.body, maxDrainBytes)
+	return r.body.Close()
+}
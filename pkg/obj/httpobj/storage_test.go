@@ -0,0 +1,384 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpobj_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/httpobj"
+)
+
+const testObjectPath = "/pi/bucket/Pi - Dec - Chudnovsky/Pi - Dec - Chudnovsky - 0.ycd"
+const testObjectName = "Pi - Dec - Chudnovsky/Pi - Dec - Chudnovsky - 0.ycd"
+
+var testContent = []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+
+// newTestServer returns a server that serves testContent at testObjectPath
+// with Range support, and counts new connections.
+func newTestServer(t *testing.T, handler http.HandlerFunc) (*httptest.Server, *int32) {
+	t.Helper()
+	if handler == nil {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != testObjectPath {
+				http.NotFound(w, r)
+				return
+			}
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(testContent))
+		}
+	}
+	conns := new(int32)
+	s := httptest.NewUnstartedServer(handler)
+	s.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(conns, 1)
+		}
+	}
+	s.Start()
+	t.Cleanup(s.Close)
+	return s, conns
+}
+
+func newTestObject(t *testing.T, baseURL string, opts httpobj.Options) obj.Object {
+	t.Helper()
+	c, err := httpobj.NewClient(baseURL+"/pi", opts)
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c.Bucket("bucket").Object(testObjectName)
+}
+
+func TestHTTP_NewRangeReader(t *testing.T) {
+	t.Parallel()
+
+	s, conns := newTestServer(t, nil)
+	object := newTestObject(t, s.URL, httpobj.Options{})
+	testCases := []struct {
+		name    string
+		off     int64
+		length  int64
+		want    []byte
+		wantErr error
+	}{
+		{"whole", 0, int64(len(testContent)), testContent, nil},
+		{"section", 3, 5, testContent[3:8], nil},
+		{"to the end", 10, -1, testContent[10:], nil},
+		{"truncated", 30, 100, testContent[30:], nil},
+		{"empty", 5, 0, []byte{}, nil},
+		{"past the end", 100, -1, nil, io.EOF},
+		{"negative offset", -1, 1, nil, httpobj.ErrInvalidRange},
+	}
+	for _, tc := range testCases {
+		rd, err := object.NewRangeReader(context.Background(), tc.off, tc.length)
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s: NewRangeReader() error got %v, want %v", tc.name, err, tc.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		got, err := io.ReadAll(rd)
+		rd.Close()
+		if err != nil {
+			t.Errorf("%s: ReadAll() failed: %v", tc.name, err)
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("%s: ReadAll() = (-want, +got):\n%s", tc.name, diff)
+		}
+	}
+	if got := atomic.LoadInt32(conns); got != 1 {
+		t.Errorf("connections = got %d, want 1", got)
+	}
+}
+
+func TestHTTP_Responses(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		handler http.HandlerFunc
+		off     int64
+		wantErr error
+	}{
+		{
+			"not found",
+			func(w http.ResponseWriter, r *http.Request) {
+				http.NotFound(w, r)
+			},
+			0, fs.ErrNotExist,
+		},
+		{
+			"forbidden",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusForbidden)
+			},
+			0, new(httpobj.StatusError),
+		},
+		{
+			"unavailable",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			0, new(obj.TransientError),
+		},
+		{
+			"range ignored",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Write(testContent)
+			},
+			1, httpobj.ErrRangeNotSupported,
+		},
+		{
+			"wrong range",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Range", "bytes 0-9/36")
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(testContent[:10])
+			},
+			5, httpobj.ErrInvalidResponse,
+		},
+		{
+			"wrong length",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Range", "bytes 0-9/36")
+				w.Header().Set("Content-Length", "5")
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(testContent[:5])
+			},
+			0, httpobj.ErrInvalidResponse,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			s, _ := newTestServer(t, tc.handler)
+			_, err := newTestObject(t, s.URL, httpobj.Options{}).NewRangeReader(context.Background(), tc.off, 10)
+			switch want := tc.wantErr.(type) {
+			case *httpobj.StatusError:
+				if !errors.As(err, &want) || want.StatusCode != http.StatusForbidden {
+					t.Errorf("NewRangeReader() error got %v, want 403", err)
+				}
+			case *obj.TransientError:
+				if !errors.As(err, &want) {
+					t.Errorf("NewRangeReader() error got %v, want a transient error", err)
+				}
+			default:
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("NewRangeReader() error got %v, want %v", err, tc.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTP_WholeObject(t *testing.T) {
+	t.Parallel()
+
+	// A server without Range support is fine for reads from the beginning.
+	s, _ := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testContent)
+	})
+	rd, err := newTestObject(t, s.URL, httpobj.Options{}).NewRangeReader(context.Background(), 0, 10)
+	if err != nil {
+		t.Fatalf("NewRangeReader() failed: %v", err)
+	}
+	defer rd.Close()
+	got, err := io.ReadAll(rd)
+	if err != nil {
+		t.Fatalf("ReadAll() failed: %v", err)
+	}
+	if diff := cmp.Diff(testContent[:10], got); diff != "" {
+		t.Errorf("ReadAll() = (-want, +got):\n%s", diff)
+	}
+}
+
+func TestHTTP_WholeObjectClose(t *testing.T) {
+	t.Parallel()
+
+	// A server without Range support sends a large object, of which
+	// 10 bytes are read. Close doesn't read the rest.
+	const size = 256 * 1024 * 1024
+	written := make(chan int64, 1)
+	s, _ := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(size))
+		n, _ := io.CopyN(w, zeroReader{}, size)
+		written <- n
+	})
+	rd, err := newTestObject(t, s.URL, httpobj.Options{}).NewRangeReader(context.Background(), 0, 10)
+	if err != nil {
+		t.Fatalf("NewRangeReader() failed: %v", err)
+	}
+	if _, err := io.ReadAll(rd); err != nil {
+		t.Fatalf("ReadAll() failed: %v", err)
+	}
+	if err := rd.Close(); err != nil {
+		t.Errorf("Close() failed: %v", err)
+	}
+	if n := <-written; n == size {
+		t.Errorf("the server wrote the whole object of %d bytes", n)
+	}
+}
+
+// zeroReader reads zeros.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestHTTP_ShortBody(t *testing.T) {
+	t.Parallel()
+
+	s, _ := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Range", "bytes 0-9/36")
+		w.Header().Set("Content-Length", "10")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(testContent[:4])
+	})
+	rd, err := newTestObject(t, s.URL, httpobj.Options{}).NewRangeReader(context.Background(), 0, 10)
+	if err != nil {
+		t.Fatalf("NewRangeReader() failed: %v", err)
+	}
+	defer rd.Close()
+	if _, err := io.ReadAll(rd); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("ReadAll() error got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestHTTP_Header(t *testing.T) {
+	t.Parallel()
+
+	s, _ := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if got := r.Header.Get("Accept-Encoding"); got != "identity" {
+			http.Error(w, "Accept-Encoding: "+got, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Range", "bytes 2-3/36")
+		w.Header().Set("Content-Length", strconv.Itoa(2))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(testContent[2:4])
+	})
+	header := http.Header{}
+	header.Set("Authorization", "Bearer token")
+	rd, err := newTestObject(t, s.URL, httpobj.Options{Header: header}).NewRangeReader(context.Background(), 2, 2)
+	if err != nil {
+		t.Fatalf("NewRangeReader() failed: %v", err)
+	}
+	defer rd.Close()
+	got, err := io.ReadAll(rd)
+	if err != nil {
+		t.Fatalf("ReadAll() failed: %v", err)
+	}
+	if diff := cmp.Diff(testContent[2:4], got); diff != "" {
+		t.Errorf("ReadAll() = (-want, +got):\n%s", diff)
+	}
+}
+
+func TestHTTP_Names(t *testing.T) {
+	t.Parallel()
+
+	if _, err := httpobj.NewClient("ftp://example.com/", httpobj.Options{}); !errors.Is(err, httpobj.ErrInvalidURL) {
+		t.Errorf("NewClient(ftp) error got %v, want %v", err, httpobj.ErrInvalidURL)
+	}
+
+	s, _ := newTestServer(t, nil)
+	c, err := httpobj.NewClient(s.URL+"/pi/bucket", httpobj.Options{})
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+	defer c.Close()
+	testCases := []struct {
+		bucket, object string
+		wantErr        error
+	}{
+		{".", testObjectName, nil},
+		{".", "../bucket/" + testObjectName, httpobj.ErrInvalidName},
+		{"..", testObjectName, httpobj.ErrInvalidName},
+		{".", "missing", fs.ErrNotExist},
+	}
+	for _, tc := range testCases {
+		rd, err := c.Bucket(tc.bucket).Object(tc.object).NewRangeReader(context.Background(), 0, -1)
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("NewRangeReader(%q, %q) error got %v, want %v", tc.bucket, tc.object, err, tc.wantErr)
+		}
+		if err == nil {
+			rd.Close()
+		}
+	}
+}
+
+func TestHTTP_Attrs(t *testing.T) {
+	t.Parallel()
+
+	updated := time.Date(2022, 3, 14, 1, 59, 26, 0, time.UTC)
+	s, _ := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != testObjectPath {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", `"pi"`)
+		w.Header().Add("X-Goog-Hash", "crc32c=AAAABQ==,md5=c2VjcmV0")
+		http.ServeContent(w, r, "", updated, bytes.NewReader(testContent))
+	})
+	c, err := httpobj.NewClient(s.URL+"/pi", httpobj.Options{})
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	got, err := c.Bucket("bucket").Object(testObjectName).Attrs(ctx)
+	if err != nil {
+		t.Fatalf("Attrs() failed: %v", err)
+	}
+	want := &obj.ObjectAttrs{
+		Name:      testObjectName,
+		Size:      int64(len(testContent)),
+		CRC32C:    5,
+		HasCRC32C: true,
+		ETag:      `"pi"`,
+		Updated:   updated,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Attrs() = (-want, +got):\n%s", diff)
+	}
+
+	if _, err := c.Bucket("bucket").Object("missing").Attrs(ctx); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Attrs(missing) error got %v, want %v", err, fs.ErrNotExist)
+	}
+	if _, err := c.Bucket("bucket").List(ctx, nil).Next(); !errors.Is(err, httpobj.ErrListNotSupported) {
+		t.Errorf("List().Next() error got %v, want %v", err, httpobj.ErrListNotSupported)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instrumented
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Cost sums up the requests made with a context, e.g. for an API call.
+type Cost struct {
+	// Requests is the number of requests.
+	Requests int64
+	// Bytes is the number of bytes read.
+	Bytes int64
+	// Errors is the number of failed requests.
+	Errors int64
+	// Latency is the sum of request latencies. It can be longer than the
+	// elapsed time if requests are made concurrently.
+	Latency time.Duration
+}
+
+type costContextKey struct{}
+
+// NewCostContext returns a context that sums up the requests made with it
+// or its descendants into the returned Cost.
+func NewCostContext(ctx context.Context) (context.Context, *Cost) {
+	c := &Cost{}
+	return context.WithValue(ctx, costContextKey{}, c), c
+}
+
+func costFromContext(ctx context.Context) *Cost {
+	c, _ := ctx.Value(costContextKey{}).(*Cost)
+	return c
+}
+
+func (c *Cost) add(m *Measurement) {
+	atomic.AddInt64(&c.Requests, 1)
+	atomic.AddInt64(&c.Bytes, m.Bytes)
+	if m.ErrorClass != ClassOK {
+		atomic.AddInt64(&c.Errors, 1)
+	}
+	atomic.AddInt64((*int64)(&c.Latency), int64(m.Latency))
+}
+
+// Snapshot returns a copy of c safe to read while c is updated.
+func (c *Cost) Snapshot() Cost {
+	return Cost{
+		Requests: atomic.LoadInt64(&c.Requests),
+		Bytes:    atomic.LoadInt64(&c.Bytes),
+		Errors:   atomic.LoadInt64(&c.Errors),
+		Latency:  time.Duration(atomic.LoadInt64((*int64)(&c.Latency))),
+	}
+}
+
+// MarshalLogObject adds the cost to a zap log line as an object.
+func (c Cost) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddInt64("requests", c.Requests)
+	enc.AddInt64("bytes", c.Bytes)
+	enc.AddInt64("errors", c.Errors)
+	enc.AddDuration("latency", c.Latency)
+	return nil
+}
@@ -0,0 +1,266 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instrumented
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+)
+
+// Decorators of obj.Client, obj.Bucket and obj.Object that measure requests.
+
+// Operations measured.
+const (
+	OpRangeRead = "range_read"
+	OpAttrs     = "attrs"
+	OpList      = "list"
+)
+
+// Error classes of measurements.
+const (
+	ClassOK        = "ok"
+	ClassNotFound  = "not_found"
+	ClassTransient = "transient"
+	ClassBroken    = "broken_response"
+	ClassCanceled  = "canceled"
+	ClassDeadline  = "deadline_exceeded"
+	ClassOther     = "other"
+)
+
+// Measurement is a measurement of a request.
+type Measurement struct {
+	// Op is the operation, one of OpRangeRead, OpAttrs and OpList.
+	Op string
+	// Bucket is the name of the bucket.
+	Bucket string
+	// Prefix is the object name prefix returned by Options.Prefix,
+	// or the prefix of a List query.
+	Prefix string
+	// Bytes is the number of bytes read by a range read.
+	Bytes int64
+	// Latency is the duration of the request. For a range read, it's from
+	// the request to Close, including the time the caller spent reading.
+	Latency time.Duration
+	// ErrorClass classifies the error of the request. ClassOK if succeeded.
+	ErrorClass string
+}
+
+// Sink receives measurements. Record must be safe for concurrent use.
+type Sink interface {
+	Record(ctx context.Context, m *Measurement)
+}
+
+// Options configures the decorators.
+type Options struct {
+	// Sink receives measurements. Only costs in contexts are recorded if nil.
+	Sink Sink
+	// Prefix returns the object name prefix measurements are grouped by.
+	// The directory of the object name if nil,
+	// e.g. "Pi - Dec - Chudnovsky" for "Pi - Dec - Chudnovsky/Pi - Dec - Chudnovsky - 0.ycd".
+	Prefix func(name string) string
+}
+
+func (o *Options) prefix(name string) string {
+	if o.Prefix != nil {
+		return o.Prefix(name)
+	}
+	return path.Dir(name)
+}
+
+// record sends m to the sink and adds it to the cost in ctx.
+func (o *Options) record(ctx context.Context, m *Measurement) {
+	if o.Sink != nil {
+		o.Sink.Record(ctx, m)
+	}
+	if c := costFromContext(ctx); c != nil {
+		c.add(m)
+	}
+}
+
+// ErrorClass returns the error class of err for measurements.
+// A read that ends with io.EOF is ClassOK.
+func ErrorClass(err error) string {
+	var transient *obj.TransientError
+	switch {
+	case err == nil, err == io.EOF:
+		return ClassOK
+	case errors.Is(err, context.Canceled):
+		return ClassCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return ClassDeadline
+	case errors.Is(err, fs.ErrNotExist):
+		return ClassNotFound
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return ClassBroken
+	case errors.As(err, &transient):
+		return ClassTransient
+	}
+	return ClassOther
+}
+
+type Client struct {
+	c    obj.Client
+	opts Options
+}
+
+type Bucket struct {
+	b    obj.Bucket
+	name string
+	opts *Options
+}
+
+type Object struct {
+	o      obj.Object
+	bucket string
+	name   string
+	opts   *Options
+}
+
+// NewClient returns a client that measures the requests made with c.
+// Closing the returned client closes c.
+func NewClient(c obj.Client, opts Options) obj.Client {
+	return &Client{c: c, opts: opts}
+}
+
+func (c *Client) Bucket(name string) obj.Bucket {
+	return &Bucket{b: c.c.Bucket(name), name: name, opts: &c.opts}
+}
+
+func (c *Client) Close() error {
+	return c.c.Close()
+}
+
+func (b *Bucket) Object(name string) obj.Object {
+	return &Object{o: b.b.Object(name), bucket: b.name, name: name, opts: b.opts}
+}
+
+// List returns an iterator that records a measurement when the listing ends.
+func (b *Bucket) List(ctx context.Context, q *obj.Query) obj.ObjectIterator {
+	prefix := ""
+	if q != nil {
+		prefix = q.Prefix
+	}
+	return &objectIterator{
+		ctx:   ctx,
+		it:    b.b.List(ctx, q),
+		start: time.Now(),
+		m: Measurement{
+			Op:     OpList,
+			Bucket: b.name,
+			Prefix: prefix,
+		},
+		opts: b.opts,
+	}
+}
+
+type objectIterator struct {
+	ctx   context.Context
+	it    obj.ObjectIterator
+	start time.Time
+	m     Measurement
+	opts  *Options
+	done  bool
+}
+
+func (it *objectIterator) Next() (*obj.ObjectAttrs, error) {
+	attrs, err := it.it.Next()
+	if err != nil && !it.done {
+		it.done = true
+		it.m.Latency = time.Since(it.start)
+		if err != obj.Done {
+			it.m.ErrorClass = ErrorClass(err)
+		} else {
+			it.m.ErrorClass = ClassOK
+		}
+		it.opts.record(it.ctx, &it.m)
+	}
+	return attrs, err
+}
+
+func (o *Object) measurement(op string) *Measurement {
+	return &Measurement{
+		Op:     op,
+		Bucket: o.bucket,
+		Prefix: o.opts.prefix(o.name),
+	}
+}
+
+func (o *Object) Attrs(ctx context.Context) (*obj.ObjectAttrs, error) {
+	start := time.Now()
+	attrs, err := o.o.Attrs(ctx)
+	m := o.measurement(OpAttrs)
+	m.Latency = time.Since(start)
+	m.ErrorClass = ErrorClass(err)
+	o.opts.record(ctx, m)
+	return attrs, err
+}
+
+// NewRangeReader returns a reader that counts bytes read and records
+// a measurement on Close. A failed request is recorded immediately.
+func (o *Object) NewRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	start := time.Now()
+	rd, err := o.o.NewRangeReader(ctx, offset, length)
+	m := o.measurement(OpRangeRead)
+	if err != nil {
+		m.Latency = time.Since(start)
+		m.ErrorClass = ErrorClass(err)
+		o.opts.record(ctx, m)
+		return nil, err
+	}
+	return &reader{
+		ctx:   ctx,
+		rd:    rd,
+		start: start,
+		m:     m,
+		opts:  o.opts,
+	}, nil
+}
+
+// reader counts bytes read from a range reader.
+type reader struct {
+	ctx   context.Context
+	rd    io.ReadCloser
+	start time.Time
+	m     *Measurement
+	opts  *Options
+	err   error
+	once  sync.Once
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	r.m.Bytes += int64(n)
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+func (r *reader) Close() error {
+	err := r.rd.Close()
+	r.once.Do(func() {
+		r.m.Latency = time.Since(r.start)
+		r.m.ErrorClass = ErrorClass(r.err)
+		r.opts.record(r.ctx, r.m)
+	})
+	return err
+}
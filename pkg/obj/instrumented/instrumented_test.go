@@ -0,0 +1,162 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instrumented_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/instrumented"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/mem"
+	"go.opencensus.io/stats/view"
+)
+
+type recordingSink struct {
+	mu sync.Mutex
+	ms []instrumented.Measurement
+}
+
+func (s *recordingSink) Record(ctx context.Context, m *instrumented.Measurement) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ms = append(s.ms, *m)
+}
+
+func newTestClient(t *testing.T, sink instrumented.Sink) obj.Client {
+	t.Helper()
+	mc := mem.NewClient()
+	mc.MemBucket("bucket").Put("dir/obj", []byte("0123456789"))
+	mc.MemBucket("bucket").Put("obj", []byte("abc"))
+	c := instrumented.NewClient(mc, instrumented.Options{Sink: sink})
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestInstrumented_Record(t *testing.T) {
+	t.Parallel()
+
+	sink := &recordingSink{}
+	bucket := newTestClient(t, sink).Bucket("bucket")
+	ctx, cost := instrumented.NewCostContext(context.Background())
+
+	rd, err := bucket.Object("dir/obj").NewRangeReader(ctx, 2, 5)
+	if err != nil {
+		t.Fatalf("NewRangeReader() failed: %v", err)
+	}
+	if _, err := io.ReadAll(rd); err != nil {
+		t.Fatalf("ReadAll() failed: %v", err)
+	}
+	rd.Close()
+	rd.Close()
+
+	if _, err := bucket.Object("dir/missing").NewRangeReader(ctx, 0, -1); err == nil {
+		t.Error("NewRangeReader(missing) succeeded, want error")
+	}
+	if _, err := bucket.Object("obj").Attrs(ctx); err != nil {
+		t.Errorf("Attrs() failed: %v", err)
+	}
+	it := bucket.List(ctx, &obj.Query{Prefix: "dir/"})
+	for {
+		if _, err := it.Next(); err != nil {
+			break
+		}
+	}
+	it.Next()
+
+	want := []instrumented.Measurement{
+		{Op: instrumented.OpRangeRead, Bucket: "bucket", Prefix: "dir", Bytes: 5, ErrorClass: instrumented.ClassOK},
+		{Op: instrumented.OpRangeRead, Bucket: "bucket", Prefix: "dir", ErrorClass: instrumented.ClassNotFound},
+		{Op: instrumented.OpAttrs, Bucket: "bucket", Prefix: ".", ErrorClass: instrumented.ClassOK},
+		{Op: instrumented.OpList, Bucket: "bucket", Prefix: "dir/", ErrorClass: instrumented.ClassOK},
+	}
+	if diff := cmp.Diff(want, sink.ms, cmpopts.IgnoreFields(instrumented.Measurement{}, "Latency")); diff != "" {
+		t.Errorf("measurements = (-want, +got):\n%s", diff)
+	}
+
+	got := cost.Snapshot()
+	if got.Requests != 4 || got.Bytes != 5 || got.Errors != 1 {
+		t.Errorf("cost = got %+v, want 4 requests, 5 bytes and 1 error", got)
+	}
+}
+
+func TestInstrumented_ErrorClass(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		err  error
+		want string
+	}{
+		{nil, instrumented.ClassOK},
+		{io.EOF, instrumented.ClassOK},
+		{fmt.Errorf("read: %w", context.Canceled), instrumented.ClassCanceled},
+		{context.DeadlineExceeded, instrumented.ClassDeadline},
+		{&obj.NotExistError{Err: errors.New("404")}, instrumented.ClassNotFound},
+		{io.ErrUnexpectedEOF, instrumented.ClassBroken},
+		{&obj.TransientError{Err: errors.New("503")}, instrumented.ClassTransient},
+		{errors.New("boom"), instrumented.ClassOther},
+	}
+	for _, tc := range testCases {
+		if got := instrumented.ErrorClass(tc.err); got != tc.want {
+			t.Errorf("ErrorClass(%v) = got %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestInstrumented_OpenCensus(t *testing.T) {
+	if err := view.Register(instrumented.Views...); err != nil {
+		t.Fatalf("view.Register() failed: %v", err)
+	}
+	defer view.Unregister(instrumented.Views...)
+
+	bucket := newTestClient(t, instrumented.OpenCensusSink{}).Bucket("bucket")
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		rd, err := bucket.Object("dir/obj").NewRangeReader(ctx, 0, -1)
+		if err != nil {
+			t.Fatalf("NewRangeReader() failed: %v", err)
+		}
+		io.ReadAll(rd)
+		rd.Close()
+	}
+
+	rows, err := view.RetrieveData(instrumented.BytesReadView.Name)
+	if err != nil {
+		t.Fatalf("RetrieveData() failed: %v", err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("RetrieveData() = got %d rows, want 1", len(rows))
+	}
+	if got := rows[0].Data.(*view.SumData).Value; got != 30 {
+		t.Errorf("bytes read = got %v, want 30", got)
+	}
+
+	rows, err = view.RetrieveData(instrumented.LatencyView.Name)
+	if err != nil {
+		t.Fatalf("RetrieveData() failed: %v", err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("RetrieveData() = got %d rows, want 1", len(rows))
+	}
+	if got := rows[0].Data.(*view.DistributionData).Count; got != 3 {
+		t.Errorf("latency count = got %v, want 3", got)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instrumented
+
+import (
+	"context"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+// OpenCensus measures and tags recorded by OpenCensusSink.
+var (
+	MeasureBytes   = stats.Int64("pi.delivery/obj/bytes_read", "Bytes read from object storage", stats.UnitBytes)
+	MeasureLatency = stats.Float64("pi.delivery/obj/latency", "Latency of object storage requests", stats.UnitMilliseconds)
+
+	KeyOp     = tag.MustNewKey("op")
+	KeyBucket = tag.MustNewKey("bucket")
+	KeyPrefix = tag.MustNewKey("prefix")
+	KeyError  = tag.MustNewKey("error")
+)
+
+var tagKeys = []tag.Key{KeyOp, KeyBucket, KeyPrefix, KeyError}
+
+// Views of the measures. Register them with view.Register() to export.
+var (
+	RequestCountView = &view.View{
+		Name:        "pi.delivery/obj/request_count",
+		Description: "Number of object storage requests",
+		Measure:     MeasureLatency,
+		TagKeys:     tagKeys,
+		Aggregation: view.Count(),
+	}
+	BytesReadView = &view.View{
+		Name:        "pi.delivery/obj/bytes_read",
+		Description: "Bytes read from object storage",
+		Measure:     MeasureBytes,
+		TagKeys:     tagKeys,
+		Aggregation: view.Sum(),
+	}
+	LatencyView = &view.View{
+		Name:        "pi.delivery/obj/latency",
+		Description: "Latency distribution of object storage requests",
+		Measure:     MeasureLatency,
+		TagKeys:     tagKeys,
+		Aggregation: view.Distribution(1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000),
+	}
+)
+
+// Views are all the views of OpenCensusSink.
+var Views = []*view.View{RequestCountView, BytesReadView, LatencyView}
+
+// OpenCensusSink records measurements to OpenCensus.
+type OpenCensusSink struct{}
+
+var _ Sink = OpenCensusSink{}
+
+func (OpenCensusSink) Record(ctx context.Context, m *Measurement) {
+	stats.RecordWithTags(ctx,
+		[]tag.Mutator{
+			tag.Upsert(KeyOp, m.Op),
+			tag.Upsert(KeyBucket, m.Bucket),
+			tag.Upsert(KeyPrefix, m.Prefix),
+			tag.Upsert(KeyError, m.ErrorClass),
+		},
+		MeasureBytes.M(m.Bytes),
+		MeasureLatency.M(float64(m.Latency)/1e6),
+	)
+}
@@ -0,0 +1,390 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+)
+
+// Implementations for the local file system.
+// A bucket is a directory under the root and an object is a file in it.
+// Slashes in object names are directory separators, so a y-cruncher output
+// directory can be used as a bucket as it is.
+
+var ErrInvalidName = errors.New("local: invalid bucket or object name")
+var ErrInvalidRange = errors.New("local: invalid range")
+var ErrMmapUnsupported = errors.New("local: mmap is not supported on this platform")
+var ErrClosed = errors.New("local: client is closed")
+
+// Options configures a Client.
+type Options struct {
+	// Mmap maps files into memory instead of reading them with ReadAt.
+	// Files stay mapped until the client and all the readers of them
+	// are closed.
+	Mmap bool
+}
+
+type Client struct {
+	root string
+	opts Options
+
+	mu   sync.Mutex
+	maps map[string][]byte
+	// readers is the number of open readers of mapped files.
+	readers int
+	closed  bool
+}
+
+type Bucket struct {
+	c   *Client
+	dir string
+	err error
+}
+
+type Object struct {
+	c    *Client
+	name string
+	path string
+	err  error
+}
+
+// NewClient returns a new client object for the directory root.
+// Buckets are subdirectories of root. Use "." as the bucket name for root itself.
+func NewClient(root string, opts Options) (obj.Client, error) {
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%w: %s is not a directory", ErrInvalidName, root)
+	}
+	if opts.Mmap && !mmapSupported {
+		return nil, ErrMmapUnsupported
+	}
+	return &Client{
+		root: root,
+		opts: opts,
+		maps: make(map[string][]byte),
+	}, nil
+}
+
+func (c *Client) Bucket(name string) obj.Bucket {
+	if !fs.ValidPath(name) {
+		return &Bucket{c: c, err: fmt.Errorf("%w: %q", ErrInvalidName, name)}
+	}
+	return &Bucket{c: c, dir: filepath.Join(c.root, filepath.FromSlash(name))}
+}
+
+// Close unmaps mapped files. If readers of them are still open, the files
+// are unmapped when the last one is closed instead.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if c.readers > 0 {
+		return nil
+	}
+	return c.unmapLocked()
+}
+
+// unmapLocked unmaps all the mapped files. c.mu must be held.
+func (c *Client) unmapLocked() error {
+	var err error
+	for path, data := range c.maps {
+		if e := munmap(data); e != nil && err == nil {
+			err = e
+		}
+		delete(c.maps, path)
+	}
+	return err
+}
+
+// release is called when a reader of a mapped file is closed.
+func (c *Client) release() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readers--
+	if c.closed && c.readers == 0 {
+		return c.unmapLocked()
+	}
+	return nil
+}
+
+func (b *Bucket) Object(name string) obj.Object {
+	if b.err != nil {
+		return &Object{c: b.c, err: b.err}
+	}
+	if !fs.ValidPath(name) {
+		return &Object{c: b.c, err: fmt.Errorf("%w: %q", ErrInvalidName, name)}
+	}
+	return &Object{c: b.c, name: name, path: filepath.Join(b.dir, filepath.FromSlash(name))}
+}
+
+// List returns an iterator over files selected by q. Only the directories
+// that can contain names with q.Prefix are walked, when Next is first called.
+// Files are listed all at once, so q.PageSize is ignored.
+func (b *Bucket) List(ctx context.Context, q *obj.Query) obj.ObjectIterator {
+	if q == nil {
+		q = &obj.Query{}
+	}
+	return &objectIterator{ctx: ctx, b: b, q: *q}
+}
+
+// objectIterator lists files in a bucket.
+type objectIterator struct {
+	ctx    context.Context
+	b      *Bucket
+	q      obj.Query
+	walked bool
+	attrs  []*obj.ObjectAttrs
+	err    error
+}
+
+func (it *objectIterator) Next() (*obj.ObjectAttrs, error) {
+	if !it.walked {
+		it.walked = true
+		it.attrs, it.err = it.b.walk(it.ctx, it.q)
+	}
+	if it.err != nil {
+		return nil, it.err
+	}
+	if len(it.attrs) == 0 {
+		return nil, obj.Done
+	}
+	attrs := it.attrs[0]
+	it.attrs = it.attrs[1:]
+	return attrs, nil
+}
+
+// walk returns the attributes of files selected by q sorted by name.
+func (b *Bucket) walk(ctx context.Context, q obj.Query) ([]*obj.ObjectAttrs, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	// The deepest directory that contains all the names with the prefix.
+	start := "."
+	if i := strings.LastIndexByte(q.Prefix, '/'); i > 0 {
+		start = q.Prefix[:i]
+	}
+	if !fs.ValidPath(start) {
+		return nil, nil
+	}
+
+	var list []*obj.ObjectAttrs
+	err := fs.WalkDir(os.DirFS(b.dir), start, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && name == start {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if dir := name + "/"; name != "." && !strings.HasPrefix(dir, q.Prefix) && !strings.HasPrefix(q.Prefix, dir) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !strings.HasPrefix(name, q.Prefix) || name < q.StartOffset {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		list = append(list, fileAttrs(name, fi))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// WalkDir sorts entries per directory, which isn't the order of full names.
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+func (o *Object) Attrs(ctx context.Context) (*obj.ObjectAttrs, error) {
+	if o.err != nil {
+		return nil, o.err
+	}
+	fi, err := os.Stat(o.path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, &obj.NotExistError{Err: fmt.Errorf("%s is not a regular file", o.path)}
+	}
+	return fileAttrs(o.name, fi), nil
+}
+
+// fileAttrs returns the attributes of a file. Checksums would require reading
+// the whole file, so they are left empty.
+func fileAttrs(name string, fi fs.FileInfo) *obj.ObjectAttrs {
+	return &obj.ObjectAttrs{
+		Name:    name,
+		Size:    fi.Size(),
+		ETag:    fmt.Sprintf("%x-%x", fi.ModTime().UnixNano(), fi.Size()),
+		Updated: fi.ModTime(),
+	}
+}
+
+// NewRangeReader returns a reader for the section [offset, offset+length) of the file,
+// or to the end of the file if length is negative. The section is truncated
+// at the end of the file. It returns io.EOF if offset is at or past the end
+// of the file, and an error wrapping fs.ErrNotExist if the file doesn't exist.
+func (o *Object) NewRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	if o.err != nil {
+		return nil, o.err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		return nil, fmt.Errorf("%w: negative offset %d", ErrInvalidRange, offset)
+	}
+	if o.c.opts.Mmap {
+		return o.newMmapReader(offset, length)
+	}
+
+	f, err := os.Open(o.path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	end, err := sectionEnd(fi.Size(), offset, length)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &fileReader{
+		SectionReader: io.NewSectionReader(f, offset, end-offset),
+		f:             f,
+	}, nil
+}
+
+func (o *Object) newMmapReader(offset, length int64) (io.ReadCloser, error) {
+	data, err := o.c.mapping(o.path)
+	if err != nil {
+		return nil, err
+	}
+	end, err := sectionEnd(int64(len(data)), offset, length)
+	if err != nil {
+		o.c.release()
+		return nil, err
+	}
+	return &mmapReader{c: o.c, rd: bytes.NewReader(data[offset:end])}, nil
+}
+
+// sectionEnd returns the end of the section [offset, offset+length)
+// truncated at size.
+func sectionEnd(size, offset, length int64) (int64, error) {
+	if offset >= size {
+		return 0, io.EOF
+	}
+	if length < 0 || offset+length > size {
+		return size, nil
+	}
+	return offset + length, nil
+}
+
+// mapping returns the contents of the file at path mapped into memory.
+// The caller must call c.release when it's done with them.
+func (c *Client) mapping(path string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrClosed
+	}
+	if data, ok := c.maps[path]; ok {
+		c.readers++
+		return data, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() != int64(int(fi.Size())) {
+		return nil, fmt.Errorf("%w: %s is too large to map", ErrMmapUnsupported, path)
+	}
+	data, err := mmap(f, int(fi.Size()))
+	if err != nil {
+		return nil, &fs.PathError{Op: "mmap", Path: path, Err: err}
+	}
+	c.maps[path] = data
+	c.readers++
+	return data, nil
+}
+
+// fileReader reads a section of a file and closes the file on Close.
+type fileReader struct {
+	*io.SectionReader
+	f *os.File
+}
+
+func (r *fileReader) Close() error {
+	return r.f.Close()
+}
+
+// mmapReader reads a section of a mapped file. The client keeps the file
+// mapped until the reader is closed. Close waits for an in-flight Read
+// so that the file isn't unmapped while it's being read.
+type mmapReader struct {
+	c  *Client
+	mu sync.Mutex
+	rd *bytes.Reader
+}
+
+func (r *mmapReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rd == nil {
+		return 0, fs.ErrClosed
+	}
+	return r.rd.Read(p)
+}
+
+func (r *mmapReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rd == nil {
+		return nil
+	}
+	r.rd = nil
+	return r.c.release()
+}
@@ -0,0 +1,345 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/local"
+	"github.com/googlecloudplatform/pi-delivery/pkg/resultset"
+	"github.com/googlecloudplatform/pi-delivery/pkg/unpack"
+	"github.com/googlecloudplatform/pi-delivery/pkg/ycd"
+)
+
+var testOptions = []struct {
+	name string
+	opts local.Options
+}{
+	{"ReadAt", local.Options{}},
+	{"Mmap", local.Options{Mmap: true}},
+}
+
+func newTestClient(t *testing.T, root string, opts local.Options) *local.Client {
+	t.Helper()
+	c, err := local.NewClient(root, opts)
+	if errors.Is(err, local.ErrMmapUnsupported) {
+		t.Skip("mmap is not supported")
+	}
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c.(*local.Client)
+}
+
+func TestLocal_NewRangeReader(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	content := []byte("0123456789abcdef")
+	if err := os.MkdirAll(filepath.Join(root, "bucket", "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "bucket", "dir", "obj"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name    string
+		off     int64
+		length  int64
+		want    []byte
+		wantErr error
+	}{
+		{"whole", 0, int64(len(content)), content, nil},
+		{"section", 3, 5, content[3:8], nil},
+		{"to the end", 10, -1, content[10:], nil},
+		{"truncated", 10, 100, content[10:], nil},
+		{"empty", 5, 0, []byte{}, nil},
+		{"at the end", int64(len(content)), 1, nil, io.EOF},
+		{"past the end", 100, -1, nil, io.EOF},
+		{"negative offset", -1, 1, nil, local.ErrInvalidRange},
+	}
+	for _, o := range testOptions {
+		o := o
+		t.Run(o.name, func(t *testing.T) {
+			t.Parallel()
+			object := newTestClient(t, root, o.opts).Bucket("bucket").Object("dir/obj")
+			for _, tc := range testCases {
+				rd, err := object.NewRangeReader(context.Background(), tc.off, tc.length)
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("%s: NewRangeReader() error got %v, want %v", tc.name, err, tc.wantErr)
+				}
+				if err != nil {
+					continue
+				}
+				got, err := io.ReadAll(rd)
+				rd.Close()
+				if err != nil {
+					t.Errorf("%s: ReadAll() failed: %v", tc.name, err)
+				}
+				if diff := cmp.Diff(tc.want, got); diff != "" {
+					t.Errorf("%s: ReadAll() = (-want, +got):\n%s", tc.name, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestLocal_Errors(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "obj"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if _, err := local.NewClient(filepath.Join(root, "obj"), local.Options{}); !errors.Is(err, local.ErrInvalidName) {
+		t.Errorf("NewClient(file) error got %v, want %v", err, local.ErrInvalidName)
+	}
+	if _, err := local.NewClient(filepath.Join(root, "missing"), local.Options{}); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("NewClient(missing) error got %v, want %v", err, fs.ErrNotExist)
+	}
+
+	for _, o := range testOptions {
+		c := newTestClient(t, root, o.opts)
+		testCases := []struct {
+			bucket, object string
+			wantErr        error
+		}{
+			{".", "obj", nil},
+			{".", "missing", fs.ErrNotExist},
+			{".", "../obj", local.ErrInvalidName},
+			{".", "/obj", local.ErrInvalidName},
+			{"..", "obj", local.ErrInvalidName},
+		}
+		for _, tc := range testCases {
+			rd, err := c.Bucket(tc.bucket).Object(tc.object).NewRangeReader(ctx, 0, -1)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("%s: NewRangeReader(%q, %q) error got %v, want %v", o.name, tc.bucket, tc.object, err, tc.wantErr)
+			}
+			if err == nil {
+				rd.Close()
+			}
+		}
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, err := c.Bucket(".").Object("obj").NewRangeReader(canceled, 0, -1); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: NewRangeReader(canceled) error got %v, want %v", o.name, err, context.Canceled)
+		}
+	}
+}
+
+func TestLocal_MmapClose(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	content := []byte("0123456789abcdef")
+	if err := os.WriteFile(filepath.Join(root, "obj"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t, root, local.Options{Mmap: true})
+	o := c.Bucket(".").Object("obj")
+	rd, err := o.NewRangeReader(context.Background(), 0, -1)
+	if err != nil {
+		t.Fatalf("NewRangeReader() failed: %v", err)
+	}
+
+	// The file stays mapped for the open reader.
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if _, err := o.NewRangeReader(context.Background(), 0, -1); !errors.Is(err, local.ErrClosed) {
+		t.Errorf("NewRangeReader() after Close() error got %v, want %v", err, local.ErrClosed)
+	}
+	got, err := io.ReadAll(rd)
+	if err != nil {
+		t.Fatalf("ReadAll() failed: %v", err)
+	}
+	if diff := cmp.Diff(content, got); diff != "" {
+		t.Errorf("ReadAll() = (-want, +got):\n%s", diff)
+	}
+	if err := rd.Close(); err != nil {
+		t.Errorf("reader Close() failed: %v", err)
+	}
+	if _, err := rd.Read(make([]byte, 1)); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("Read() after Close() error got %v, want %v", err, fs.ErrClosed)
+	}
+}
+
+func TestLocal_MmapCloseDuringRead(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	if err := os.WriteFile(filepath.Join(root, "obj"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t, root, local.Options{Mmap: true})
+	rd, err := c.Bucket(".").Object("obj").NewRangeReader(context.Background(), 0, -1)
+	if err != nil {
+		t.Fatalf("NewRangeReader() failed: %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+
+	// The reader is closed, and the file unmapped, while it's being read,
+	// e.g. when a read is canceled.
+	done := make(chan error)
+	go func() {
+		p := make([]byte, 16)
+		for {
+			if _, err := rd.Read(p); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	if err := rd.Close(); err != nil {
+		t.Errorf("reader Close() failed: %v", err)
+	}
+	if err := <-done; err != io.EOF && !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("Read() error got %v, want %v or %v", err, io.EOF, fs.ErrClosed)
+	}
+}
+
+func TestLocal_ResultSet(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	prefix := "Pi - Dec - Chudnovsky"
+	if err := os.Mkdir(filepath.Join(root, prefix), 0755); err != nil {
+		t.Fatal(err)
+	}
+	rnd := rand.New(rand.NewSource(1))
+	var sb strings.Builder
+	for i := 0; i < 1000; i++ {
+		sb.WriteByte(byte('0' + rnd.Intn(10)))
+	}
+	digits := sb.String()
+
+	enc, err := ycd.NewEncoder(10, 300, func(blockID int64) (string, io.WriteCloser, error) {
+		name := prefix + "/" + ycd.FileName(prefix, blockID)
+		f, err := os.Create(filepath.Join(root, filepath.FromSlash(name)))
+		return name, f, err
+	})
+	if err != nil {
+		t.Fatalf("NewEncoder() failed: %v", err)
+	}
+	set, err := enc.Encode(strings.NewReader("3." + digits))
+	if err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+
+	for _, o := range testOptions {
+		o := o
+		t.Run(o.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			bucket := newTestClient(t, root, o.opts).Bucket(".")
+
+			rd := resultset.ResultSet(set).NewReader(ctx, bucket)
+			defer rd.Close()
+			got, err := io.ReadAll(unpack.NewReader(ctx, rd))
+			if err != nil {
+				t.Fatalf("ReadAll() failed: %v", err)
+			}
+			if diff := cmp.Diff(digits, string(got)); diff != "" {
+				t.Errorf("ReadAll() = (-want, +got):\n%s", diff)
+			}
+
+			section := make([]byte, 500)
+			n, err := unpack.NewReader(ctx, rd).ReadAt(section, 250)
+			if err != nil {
+				t.Fatalf("ReadAt() failed: %v", err)
+			}
+			if diff := cmp.Diff(digits[250:750], string(section[:n])); diff != "" {
+				t.Errorf("ReadAt() = (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLocal_ListAttrs(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	for _, name := range []string{"a/2", "b", "a/1", "a-1", "a/c/3", "d/4"} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	bucket := newTestClient(t, root, local.Options{}).Bucket(".")
+
+	testCases := []struct {
+		q    *obj.Query
+		want []string
+	}{
+		{nil, []string{"a-1", "a/1", "a/2", "a/c/3", "b", "d/4"}},
+		{&obj.Query{Prefix: "a/"}, []string{"a/1", "a/2", "a/c/3"}},
+		{&obj.Query{Prefix: "a/c"}, []string{"a/c/3"}},
+		{&obj.Query{Prefix: "a", StartOffset: "a/2"}, []string{"a/2", "a/c/3"}},
+		{&obj.Query{Prefix: "x/y"}, nil},
+	}
+	for _, tc := range testCases {
+		var got []string
+		it := bucket.List(ctx, tc.q)
+		for {
+			attrs, err := it.Next()
+			if err == obj.Done {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Next() failed: %v", err)
+			}
+			got = append(got, attrs.Name)
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("List(%+v) = (-want, +got):\n%s", tc.q, diff)
+		}
+	}
+
+	attrs, err := bucket.Object("a/c/3").Attrs(ctx)
+	if err != nil {
+		t.Fatalf("Attrs() failed: %v", err)
+	}
+	if attrs.Name != "a/c/3" || attrs.Size != 5 || attrs.ETag == "" || attrs.Updated.IsZero() {
+		t.Errorf("Attrs() = got %+v, want a/c/3 of 5 bytes", attrs)
+	}
+	for _, name := range []string{"missing", "a"} {
+		if _, err := bucket.Object(name).Attrs(ctx); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Attrs(%q) error got %v, want %v", name, err, fs.ErrNotExist)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+)
+
+// In-memory implementations, mainly for tests.
+// Buckets are created on first use and objects are byte slices.
+
+var ErrInvalidRange = errors.New("mem: invalid range")
+
+type Client struct {
+	mu      sync.Mutex
+	buckets map[string]*Bucket
+}
+
+type Bucket struct {
+	mu         sync.RWMutex
+	objects    map[string]*object
+	generation int64
+}
+
+// object is the content and the attributes of an object.
+type object struct {
+	data  []byte
+	attrs obj.ObjectAttrs
+}
+
+type Object struct {
+	b    *Bucket
+	name string
+}
+
+// NewClient returns a new in-memory client without buckets.
+func NewClient() *Client {
+	return &Client{buckets: make(map[string]*Bucket)}
+}
+
+// Bucket returns the bucket specified by name, creating it if it doesn't exist.
+// The returned bucket is a *Bucket.
+func (c *Client) Bucket(name string) obj.Bucket {
+	return c.MemBucket(name)
+}
+
+// MemBucket is the same as Bucket but returns the *Bucket to write objects to.
+func (c *Client) MemBucket(name string) *Bucket {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.buckets[name]
+	if !ok {
+		b = &Bucket{objects: make(map[string]*object)}
+		c.buckets[name] = b
+	}
+	return b
+}
+
+func (c *Client) Close() error {
+	return nil
+}
+
+// Put stores data as the object name, replacing the object if it exists.
+// The bucket keeps data, so the caller must not modify it afterwards.
+// Each Put gets a new generation.
+func (b *Bucket) Put(name string, data []byte) {
+	crc := crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.generation++
+	b.objects[name] = &object{
+		data: data,
+		attrs: obj.ObjectAttrs{
+			Name:       name,
+			Size:       int64(len(data)),
+			CRC32C:     crc,
+			HasCRC32C:  true,
+			Generation: b.generation,
+			ETag:       strconv.FormatInt(b.generation, 10),
+			Updated:    time.Now(),
+		},
+	}
+}
+
+// Delete deletes the object name if it exists.
+func (b *Bucket) Delete(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.objects, name)
+}
+
+// Create returns a writer that stores the written bytes as the object name on Close.
+func (b *Bucket) Create(name string) io.WriteCloser {
+	return &objectWriter{b: b, name: name}
+}
+
+func (b *Bucket) Object(name string) obj.Object {
+	return &Object{b: b, name: name}
+}
+
+// List returns an iterator over a snapshot of objects selected by q.
+func (b *Bucket) List(ctx context.Context, q *obj.Query) obj.ObjectIterator {
+	if q == nil {
+		q = &obj.Query{}
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	it := &objectIterator{ctx: ctx}
+	for name, o := range b.objects {
+		if strings.HasPrefix(name, q.Prefix) && name >= q.StartOffset {
+			attrs := o.attrs
+			it.attrs = append(it.attrs, &attrs)
+		}
+	}
+	sort.Slice(it.attrs, func(i, j int) bool { return it.attrs[i].Name < it.attrs[j].Name })
+	return it
+}
+
+// objectIterator iterates over a snapshot of objects.
+type objectIterator struct {
+	ctx   context.Context
+	attrs []*obj.ObjectAttrs
+}
+
+func (it *objectIterator) Next() (*obj.ObjectAttrs, error) {
+	if err := it.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(it.attrs) == 0 {
+		return nil, obj.Done
+	}
+	attrs := it.attrs[0]
+	it.attrs = it.attrs[1:]
+	return attrs, nil
+}
+
+// get returns the object or an error that matches fs.ErrNotExist.
+func (o *Object) get() (*object, error) {
+	o.b.mu.RLock()
+	defer o.b.mu.RUnlock()
+	object, ok := o.b.objects[o.name]
+	if !ok {
+		return nil, &obj.NotExistError{Err: fmt.Errorf("mem: object %q doesn't exist", o.name)}
+	}
+	return object, nil
+}
+
+func (o *Object) Attrs(ctx context.Context) (*obj.ObjectAttrs, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	object, err := o.get()
+	if err != nil {
+		return nil, err
+	}
+	attrs := object.attrs
+	return &attrs, nil
+}
+
+// NewRangeReader returns a reader for the section [offset, offset+length) of the object,
+// or to the end of the object if length is negative. The section is truncated
+// at the end of the object. It returns io.EOF if offset is at or past the end
+// of the object, and an error that matches fs.ErrNotExist if the object doesn't exist.
+func (o *Object) NewRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	object, err := o.get()
+	if err != nil {
+		return nil, err
+	}
+	data := object.data
+	if offset < 0 {
+		return nil, fmt.Errorf("%w: negative offset %d", ErrInvalidRange, offset)
+	}
+	if offset >= int64(len(data)) {
+		return nil, io.EOF
+	}
+	end := int64(len(data))
+	if length >= 0 && offset+length < end {
+		end = offset + length
+	}
+	return io.NopCloser(bytes.NewReader(data[offset:end])), nil
+}
+
+// objectWriter buffers an object until Close.
+type objectWriter struct {
+	b    *Bucket
+	name string
+	buf  bytes.Buffer
+}
+
+func (w *objectWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *objectWriter) Close() error {
+	w.b.Put(w.name, w.buf.Bytes())
+	return nil
+}
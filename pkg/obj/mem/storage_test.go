@@ -0,0 +1,145 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem_test
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/mem"
+)
+
+func TestMem_NewRangeReader(t *testing.T) {
+	t.Parallel()
+
+	content := []byte("0123456789abcdef")
+	c := mem.NewClient()
+	defer c.Close()
+	c.MemBucket("bucket").Put("obj", content)
+	w := c.MemBucket("bucket").Create("written")
+	if _, err := w.Write(content[:4]); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+
+	testCases := []struct {
+		name    string
+		object  string
+		off     int64
+		length  int64
+		want    []byte
+		wantErr error
+	}{
+		{"whole", "obj", 0, int64(len(content)), content, nil},
+		{"section", "obj", 3, 5, content[3:8], nil},
+		{"to the end", "obj", 10, -1, content[10:], nil},
+		{"truncated", "obj", 10, 100, content[10:], nil},
+		{"empty", "obj", 5, 0, []byte{}, nil},
+		{"past the end", "obj", 16, 1, nil, io.EOF},
+		{"negative offset", "obj", -1, 1, nil, mem.ErrInvalidRange},
+		{"missing", "missing", 0, -1, nil, fs.ErrNotExist},
+		{"written", "written", 0, -1, content[:4], nil},
+	}
+	bucket := c.Bucket("bucket")
+	for _, tc := range testCases {
+		rd, err := bucket.Object(tc.object).NewRangeReader(context.Background(), tc.off, tc.length)
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s: NewRangeReader() error got %v, want %v", tc.name, err, tc.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		got, err := io.ReadAll(rd)
+		rd.Close()
+		if err != nil {
+			t.Errorf("%s: ReadAll() failed: %v", tc.name, err)
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("%s: ReadAll() = (-want, +got):\n%s", tc.name, diff)
+		}
+	}
+
+	if _, err := c.Bucket("other").Object("obj").NewRangeReader(context.Background(), 0, -1); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("NewRangeReader() in another bucket error got %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func TestMem_ListAttrs(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := mem.NewClient()
+	b := c.MemBucket("bucket")
+	for _, name := range []string{"a/2", "b", "a/1", "a-1", "a/3"} {
+		b.Put(name, []byte(name))
+	}
+
+	testCases := []struct {
+		q    *obj.Query
+		want []string
+	}{
+		{nil, []string{"a-1", "a/1", "a/2", "a/3", "b"}},
+		{&obj.Query{Prefix: "a/"}, []string{"a/1", "a/2", "a/3"}},
+		{&obj.Query{Prefix: "a", StartOffset: "a/2"}, []string{"a/2", "a/3"}},
+		{&obj.Query{Prefix: "c"}, nil},
+	}
+	for _, tc := range testCases {
+		var got []string
+		it := b.List(ctx, tc.q)
+		for {
+			attrs, err := it.Next()
+			if err == obj.Done {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Next() failed: %v", err)
+			}
+			got = append(got, attrs.Name)
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("List(%+v) = (-want, +got):\n%s", tc.q, diff)
+		}
+	}
+
+	attrs, err := b.Object("a/1").Attrs(ctx)
+	if err != nil {
+		t.Fatalf("Attrs() failed: %v", err)
+	}
+	// CRC32C of "a/1" with the Castagnoli polynomial.
+	want := crc32.Checksum([]byte("a/1"), crc32.MakeTable(crc32.Castagnoli))
+	if attrs.Name != "a/1" || attrs.Size != 3 || !attrs.HasCRC32C || attrs.CRC32C != want {
+		t.Errorf("Attrs() = got %+v, want a/1 of 3 bytes with CRC32C %x", attrs, want)
+	}
+	b.Put("a/1", []byte("new"))
+	updated, err := b.Object("a/1").Attrs(ctx)
+	if err != nil {
+		t.Fatalf("Attrs() failed: %v", err)
+	}
+	if updated.Generation <= attrs.Generation || updated.ETag == attrs.ETag {
+		t.Errorf("Attrs() after Put = got %+v, want a new generation and etag", updated)
+	}
+	b.Delete("a/1")
+	if _, err := b.Object("a/1").Attrs(ctx); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Attrs() after Delete error got %v, want %v", err, fs.ErrNotExist)
+	}
+}
@@ -13,6 +13,44 @@ import (
 	obj "github.com/googlecloudplatform/pi-delivery/pkg/obj"
 )
 
+// MockObjectIterator is a mock of ObjectIterator interface.
+type MockObjectIterator struct {
+	ctrl     *gomock.Controller
+	recorder *MockObjectIteratorMockRecorder
+}
+
+// MockObjectIteratorMockRecorder is the mock recorder for MockObjectIterator.
+type MockObjectIteratorMockRecorder struct {
+	mock *MockObjectIterator
+}
+
+// NewMockObjectIterator creates a new mock instance.
+func NewMockObjectIterator(ctrl *gomock.Controller) *MockObjectIterator {
+	mock := &MockObjectIterator{ctrl: ctrl}
+	mock.recorder = &MockObjectIteratorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockObjectIterator) EXPECT() *MockObjectIteratorMockRecorder {
+	return m.recorder
+}
+
+// Next mocks base method.
+func (m *MockObjectIterator) Next() (*obj.ObjectAttrs, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Next")
+	ret0, _ := ret[0].(*obj.ObjectAttrs)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Next indicates an expected call of Next.
+func (mr *MockObjectIteratorMockRecorder) Next() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Next", reflect.TypeOf((*MockObjectIterator)(nil).Next))
+}
+
 // MockClient is a mock of Client interface.
 type MockClient struct {
 	ctrl     *gomock.Controller
@@ -87,6 +125,20 @@ func (m *MockBucket) EXPECT() *MockBucketMockRecorder {
 	return m.recorder
 }
 
+// List mocks base method.
+func (m *MockBucket) List(ctx context.Context, q *obj.Query) obj.ObjectIterator {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx, q)
+	ret0, _ := ret[0].(obj.ObjectIterator)
+	return ret0
+}
+
+// List indicates an expected call of List.
+func (mr *MockBucketMockRecorder) List(ctx, q interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockBucket)(nil).List), ctx, q)
+}
+
 // Object mocks base method.
 func (m *MockBucket) Object(name string) obj.Object {
 	m.ctrl.T.Helper()
@@ -124,6 +176,21 @@ func (m *MockObject) EXPECT() *MockObjectMockRecorder {
 	return m.recorder
 }
 
+// Attrs mocks base method.
+func (m *MockObject) Attrs(ctx context.Context) (*obj.ObjectAttrs, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Attrs", ctx)
+	ret0, _ := ret[0].(*obj.ObjectAttrs)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Attrs indicates an expected call of Attrs.
+func (mr *MockObjectMockRecorder) Attrs(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Attrs", reflect.TypeOf((*MockObject)(nil).Attrs), ctx)
+}
+
 // NewRangeReader mocks base method.
 func (m *MockObject) NewRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
 	m.ctrl.T.Helper()
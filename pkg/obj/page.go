@@ -0,0 +1,101 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package obj
+
+import (
+	"context"
+	"io"
+)
+
+// PageFunc returns the page of an object at off, a multiple of the page size.
+// A page shorter than the page size is the last page of the object.
+type PageFunc func(ctx context.Context, off int64) ([]byte, error)
+
+// NewPageReader returns a reader for the section [offset, offset+length)
+// of an object made of pages of pageSize bytes returned by page.
+// The section is truncated at the end of the object. The first page is read
+// before it returns so that errors such as a missing object are returned
+// here, and it returns io.EOF if offset is at or past the end of the object.
+// offset and length must not be negative.
+func NewPageReader(ctx context.Context, offset, length, pageSize int64, page PageFunc) (io.ReadCloser, error) {
+	r := &pageReader{
+		ctx:  ctx,
+		page: page,
+		ps:   pageSize,
+		off:  offset,
+		end:  offset + length,
+	}
+	if length == 0 {
+		return r, nil
+	}
+	if err := r.next(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// pageReader reads the pages of a range in order.
+type pageReader struct {
+	ctx  context.Context
+	page PageFunc
+	ps   int64
+	// off and end are the object offsets of the range.
+	off, end int64
+	buf      []byte
+	eof      bool
+}
+
+// next reads the page at r.off and sets r.buf to its part of the range.
+func (r *pageReader) next() error {
+	pageOff := r.off / r.ps * r.ps
+	data, err := r.page(r.ctx, pageOff)
+	if err != nil {
+		return err
+	}
+	lo := r.off - pageOff
+	if lo >= int64(len(data)) {
+		return io.EOF
+	}
+	hi := r.end - pageOff
+	if hi > int64(len(data)) {
+		hi = int64(len(data))
+	}
+	if int64(len(data)) < r.ps {
+		// Short page: the object ends here.
+		r.eof = true
+	}
+	r.buf = data[lo:hi]
+	r.off += hi - lo
+	return nil
+}
+
+func (r *pageReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.eof || r.off >= r.end {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *pageReader) Close() error {
+	r.buf = nil
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package obj_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+	"github.com/googlecloudplatform/pi-delivery/pkg/tests"
+)
+
+func TestNewPageReader(t *testing.T) {
+	t.Parallel()
+
+	content := tests.GenTestByteSeq(100)
+	errPage := errors.New("page failed")
+	var pages []int64
+	page := func(ctx context.Context, off int64) ([]byte, error) {
+		pages = append(pages, off)
+		if off == 48 {
+			return nil, errPage
+		}
+		end := off + 16
+		if end > int64(len(content)) {
+			end = int64(len(content))
+		}
+		if off > end {
+			off = end
+		}
+		return content[off:end], nil
+	}
+
+	testCases := []struct {
+		name        string
+		off, length int64
+		want        []byte
+		wantErr     error
+		wantPages   []int64
+	}{
+		{"within a page", 3, 5, content[3:8], nil, []int64{0}},
+		{"across pages", 10, 30, content[10:40], nil, []int64{0, 16, 32}},
+		{"truncated", 90, 20, content[90:], nil, []int64{80, 96}},
+		{"short page", 96, 4, content[96:], nil, []int64{96}},
+		{"empty", 10, 0, nil, nil, nil},
+		{"at the end", 100, 10, nil, io.EOF, []int64{96}},
+		{"past the end", 120, 10, nil, io.EOF, []int64{112}},
+		{"error", 50, 10, nil, errPage, []int64{48}},
+		{"error later", 40, 10, content[40:48], errPage, []int64{32, 48}},
+	}
+	for _, tc := range testCases {
+		pages = nil
+		var got []byte
+		rd, err := obj.NewPageReader(context.Background(), tc.off, tc.length, 16, page)
+		if err == nil {
+			got, err = io.ReadAll(rd)
+			rd.Close()
+		}
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s: error got %v, want %v", tc.name, err, tc.wantErr)
+		}
+		if diff := cmp.Diff(tc.want, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("%s: ReadAll() = (-want, +got):\n%s", tc.name, diff)
+		}
+		if diff := cmp.Diff(tc.wantPages, pages); diff != "" {
+			t.Errorf("%s: pages read = (-want, +got):\n%s", tc.name, diff)
+		}
+	}
+}
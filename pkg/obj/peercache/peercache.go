@@ -0,0 +1,385 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package peercache
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/golang/groupcache"
+	"github.com/golang/groupcache/consistenthash"
+	pb "github.com/golang/groupcache/groupcachepb"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+)
+
+// A decorator of obj.Client that shares a cache of aligned pages of objects
+// in a bucket among instances with groupcache. Each page is owned by one of
+// the peers on a consistent hash ring; other peers fetch it from the owner
+// over HTTP, which reads it from storage once and keeps it in memory. If the
+// owner can't be reached, the page is read from storage locally.
+// Objects are assumed to be immutable, as ycd files are.
+//
+// Peers can only request pages of the configured bucket. Serve the client
+// on a network only the peers can reach, and set a shared secret unless
+// the network is trusted.
+
+const (
+	// DefaultPageSize is the default size of a page in bytes.
+	DefaultPageSize = 256 * 1024
+	// DefaultCacheBytes is the default memory budget of the cache in bytes.
+	DefaultCacheBytes = 64 * 1024 * 1024
+	// DefaultReplicas is the default number of points of each peer on the ring.
+	DefaultReplicas = 50
+	// DefaultBasePath is the default path under which peers serve pages.
+	DefaultBasePath = "/_peercache/"
+	// SecretHeader is the header peers send Options.Secret in.
+	SecretHeader = "X-Peercache-Secret"
+)
+
+var (
+	ErrPeer       = errors.New("peercache: peer request failed")
+	ErrInvalidKey = errors.New("peercache: invalid key")
+)
+
+// Options configures a Client.
+type Options struct {
+	// Bucket is the name of the bucket whose pages are shared.
+	// Other buckets are read directly.
+	Bucket string
+	// Secret is shared by the peers to authenticate their requests.
+	// Requests aren't authenticated if empty.
+	Secret string
+	// Self is the base URL of this instance as other peers reach it,
+	// e.g. "http://10.0.0.1:8080". It must be one of Peers to own pages.
+	Self string
+	// Peers are the base URLs of all the instances including Self.
+	// Call SetPeers to update them, e.g. from service discovery.
+	Peers []string
+	// BasePath is the path under the base URLs where Client is served.
+	// DefaultBasePath if empty.
+	BasePath string
+	// PageSize is the size of the aligned pages cached. DefaultPageSize if 0.
+	// All the peers must use the same page size.
+	PageSize int64
+	// CacheBytes is the memory budget of the cache. DefaultCacheBytes if 0.
+	CacheBytes int64
+	// Replicas is the number of points of each peer on the consistent hash ring.
+	// DefaultReplicas if 0.
+	Replicas int
+	// Transport is used for requests to peers. http.DefaultTransport if nil.
+	Transport http.RoundTripper
+}
+
+// Stats are counters of a Client.
+type Stats struct {
+	// Gets is the number of pages requested, including by peers.
+	Gets int64
+	// Hits is the number of pages found in the memory of this instance.
+	Hits int64
+	// PeerLoads is the number of pages fetched from peers.
+	PeerLoads int64
+	// PeerErrors is the number of failed requests to peers.
+	PeerErrors int64
+	// LocalLoads is the number of pages read from storage.
+	LocalLoads int64
+	// ServerRequests is the number of requests from peers.
+	ServerRequests int64
+}
+
+// Client is an obj.Client that caches pages among peers.
+// It's also an http.Handler that serves pages to peers under BasePath.
+type Client struct {
+	c     obj.Client
+	opts  Options
+	group *groupcache.Group
+	http  *http.Client
+
+	mu      sync.RWMutex
+	ring    *consistenthash.Map
+	getters map[string]*peer
+}
+
+type Bucket struct {
+	c *Client
+	b obj.Bucket
+}
+
+type Object struct {
+	c    *Client
+	o    obj.Object
+	name string
+}
+
+var (
+	registerOnce sync.Once
+	// pickers maps group names to their clients.
+	pickers   sync.Map
+	numGroups int64
+)
+
+// NewClient returns a client that shares pages read with c among opts.Peers.
+// Closing the returned client closes c.
+//
+// Each client registers a groupcache group, which can't be unregistered,
+// so create one per process and bucket client.
+func NewClient(c obj.Client, opts Options) *Client {
+	if opts.BasePath == "" {
+		opts.BasePath = DefaultBasePath
+	}
+	if opts.PageSize <= 0 {
+		opts.PageSize = DefaultPageSize
+	}
+	if opts.CacheBytes <= 0 {
+		opts.CacheBytes = DefaultCacheBytes
+	}
+	if opts.Replicas <= 0 {
+		opts.Replicas = DefaultReplicas
+	}
+	client := &Client{
+		c:    c,
+		opts: opts,
+		http: &http.Client{Transport: opts.Transport},
+	}
+	client.SetPeers(opts.Peers...)
+
+	registerOnce.Do(func() {
+		groupcache.RegisterPerGroupPeerPicker(func(name string) groupcache.PeerPicker {
+			if c, ok := pickers.Load(name); ok {
+				return picker{c.(*Client)}
+			}
+			return nil
+		})
+	})
+	name := fmt.Sprintf("pi-peercache-%d", atomic.AddInt64(&numGroups, 1))
+	pickers.Store(name, client)
+	client.group = groupcache.NewGroup(name, opts.CacheBytes, groupcache.GetterFunc(client.load))
+	return client
+}
+
+// SetPeers replaces the peers with base URLs peers, which should include Self.
+func (c *Client) SetPeers(peers ...string) {
+	ring := consistenthash.New(c.opts.Replicas, nil)
+	ring.Add(peers...)
+	getters := make(map[string]*peer, len(peers))
+	for _, p := range peers {
+		getters[p] = &peer{
+			url:    strings.TrimSuffix(p, "/") + c.opts.BasePath,
+			secret: c.opts.Secret,
+			client: c.http,
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ring = ring
+	c.getters = getters
+}
+
+// pickPeer returns the peer that owns key, or false if it's this instance.
+func (c *Client) pickPeer(key string) (*peer, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.ring.IsEmpty() {
+		return nil, false
+	}
+	if owner := c.ring.Get(key); owner != c.opts.Self {
+		return c.getters[owner], true
+	}
+	return nil, false
+}
+
+// picker implements groupcache.PeerPicker.
+type picker struct {
+	c *Client
+}
+
+func (p picker) PickPeer(key string) (groupcache.ProtoGetter, bool) {
+	peer, ok := p.c.pickPeer(key)
+	if !ok {
+		return nil, false
+	}
+	return peer, true
+}
+
+func (c *Client) Bucket(name string) obj.Bucket {
+	if name != c.opts.Bucket {
+		return c.c.Bucket(name)
+	}
+	return &Bucket{c: c, b: c.c.Bucket(name)}
+}
+
+func (c *Client) Close() error {
+	return c.c.Close()
+}
+
+// Stats returns the counters of c.
+func (c *Client) Stats() Stats {
+	s := &c.group.Stats
+	return Stats{
+		Gets:           s.Gets.Get(),
+		Hits:           s.CacheHits.Get(),
+		PeerLoads:      s.PeerLoads.Get(),
+		PeerErrors:     s.PeerErrors.Get(),
+		LocalLoads:     s.LocalLoads.Get(),
+		ServerRequests: s.ServerRequests.Get(),
+	}
+}
+
+// ServeHTTP serves a page of the bucket to a peer. Requests without
+// the secret are forbidden. Pages this instance doesn't own are refused
+// so that peers with different views of the ring don't forward requests
+// to each other in a loop.
+func (c *Client) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if c.opts.Secret != "" && subtle.ConstantTimeCompare([]byte(req.Header.Get(SecretHeader)), []byte(c.opts.Secret)) != 1 {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	key := req.URL.Query().Get("key")
+	if _, err := parseKey(key); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, remote := c.pickPeer(key); remote {
+		http.Error(w, "not the owner", http.StatusMisdirectedRequest)
+		return
+	}
+	c.group.Stats.ServerRequests.Add(1)
+
+	var data []byte
+	if err := c.group.Get(req.Context(), key, groupcache.AllocatingByteSliceSink(&data)); err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, fs.ErrNotExist) {
+			code = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
+
+// pageKey identifies a page of an object in the bucket.
+type pageKey struct {
+	object string
+	off    int64
+}
+
+// String returns the groupcache key of k.
+func (k pageKey) String() string {
+	return strconv.FormatInt(k.off, 10) + "/" + k.object
+}
+
+func parseKey(s string) (pageKey, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return pageKey{}, fmt.Errorf("%w: %q", ErrInvalidKey, s)
+	}
+	off, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || off < 0 {
+		return pageKey{}, fmt.Errorf("%w: %q: invalid offset", ErrInvalidKey, s)
+	}
+	return pageKey{object: parts[1], off: off}, nil
+}
+
+// load reads the page of key from the bucket in storage.
+func (c *Client) load(ctx context.Context, key string, dest groupcache.Sink) error {
+	k, err := parseKey(key)
+	if err != nil {
+		return err
+	}
+	// A short page is only shared if the object ends there.
+	data, err := obj.ReadFull(ctx, c.c.Bucket(c.opts.Bucket).Object(k.object), k.off, c.opts.PageSize)
+	if err != nil {
+		return err
+	}
+	return dest.SetBytes(data)
+}
+
+// peer fetches pages from a peer over HTTP.
+type peer struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func (p *peer) Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	u := p.url + "?key=" + url.QueryEscape(in.GetKey())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	if p.secret != "" {
+		req.Header.Set(SecretHeader, p.secret)
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPeer, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s: %s", ErrPeer, u, res.Status)
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPeer, err)
+	}
+	out.Value = b
+	return nil
+}
+
+func (b *Bucket) Object(name string) obj.Object {
+	return &Object{c: b.c, o: b.b.Object(name), name: name}
+}
+
+func (b *Bucket) List(ctx context.Context, q *obj.Query) obj.ObjectIterator {
+	return b.b.List(ctx, q)
+}
+
+func (o *Object) Attrs(ctx context.Context) (*obj.ObjectAttrs, error) {
+	return o.o.Attrs(ctx)
+}
+
+// NewRangeReader reads the range from shared pages.
+// The first page is fetched before it returns.
+// Reads to the end of the object (length < 0) aren't cached.
+func (o *Object) NewRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 || length < 0 {
+		return o.o.NewRangeReader(ctx, offset, length)
+	}
+	return obj.NewPageReader(ctx, offset, length, o.c.opts.PageSize, o.page)
+}
+
+// page returns the page at off.
+func (o *Object) page(ctx context.Context, off int64) ([]byte, error) {
+	var data []byte
+	key := pageKey{object: o.name, off: off}
+	if err := o.c.group.Get(ctx, key.String(), groupcache.AllocatingByteSliceSink(&data)); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
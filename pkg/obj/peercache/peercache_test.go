@@ -0,0 +1,255 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package peercache_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/fault"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/instrumented"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/mem"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/peercache"
+	"github.com/googlecloudplatform/pi-delivery/pkg/tests"
+)
+
+// countingSink counts range reads from storage.
+type countingSink struct {
+	reads int64
+}
+
+func (s *countingSink) Record(ctx context.Context, m *instrumented.Measurement) {
+	if m.Op == instrumented.OpRangeRead {
+		atomic.AddInt64(&s.reads, 1)
+	}
+}
+
+const testSecret = "secret"
+
+// newPeers returns n in-process peers sharing a storage bucket with content,
+// their URLs and a sink counting their reads from it.
+func newPeers(t *testing.T, n int, content []byte) ([]*peercache.Client, []string, *countingSink) {
+	t.Helper()
+	mc := mem.NewClient()
+	mc.MemBucket("bucket").Put("dir/obj", content)
+	mc.MemBucket("other").Put("dir/obj", content)
+	sink := &countingSink{}
+
+	handlers := make([]http.Handler, n)
+	var urls []string
+	for i := 0; i < n; i++ {
+		i := i
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			handlers[i].ServeHTTP(w, req)
+		}))
+		t.Cleanup(srv.Close)
+		urls = append(urls, srv.URL)
+	}
+	var clients []*peercache.Client
+	for i := 0; i < n; i++ {
+		c := peercache.NewClient(instrumented.NewClient(mc, instrumented.Options{Sink: sink}), peercache.Options{
+			Bucket:   "bucket",
+			Secret:   testSecret,
+			Self:     urls[i],
+			Peers:    urls,
+			PageSize: 16,
+		})
+		mux := http.NewServeMux()
+		mux.Handle(peercache.DefaultBasePath, c)
+		handlers[i] = mux
+		clients = append(clients, c)
+	}
+	return clients, urls, sink
+}
+
+func TestPeerCache_Shared(t *testing.T) {
+	t.Parallel()
+
+	content := tests.GenTestByteSeq(200)
+	clients, _, sink := newPeers(t, 3, content)
+	ctx := context.Background()
+
+	for _, c := range clients {
+		o := c.Bucket("bucket").Object("dir/obj")
+		for _, r := range []struct{ off, length int64 }{
+			{0, 200},
+			{5, 30},
+			{190, 20},
+		} {
+			got, err := tests.ReadRange(ctx, o, r.off, r.length)
+			if err != nil {
+				t.Fatalf("ReadRange(%d, %d) failed: %v", r.off, r.length, err)
+			}
+			end := r.off + r.length
+			if end > int64(len(content)) {
+				end = int64(len(content))
+			}
+			if diff := cmp.Diff(content[r.off:end], got); diff != "" {
+				t.Errorf("ReadRange(%d, %d) = (-want, +got):\n%s", r.off, r.length, diff)
+			}
+		}
+	}
+
+	// Each of the 13 pages is read from storage once by its owner.
+	if got := atomic.LoadInt64(&sink.reads); got != 13 {
+		t.Errorf("storage reads = got %d, want 13", got)
+	}
+	var total peercache.Stats
+	for _, c := range clients {
+		s := c.Stats()
+		total.LocalLoads += s.LocalLoads
+		total.PeerLoads += s.PeerLoads
+		total.PeerErrors += s.PeerErrors
+		total.ServerRequests += s.ServerRequests
+	}
+	if total.LocalLoads != 13 || total.PeerErrors != 0 || total.PeerLoads == 0 || total.PeerLoads != total.ServerRequests {
+		t.Errorf("Stats() = got %+v, want 13 local loads, no peer errors, and peer loads served by peers", total)
+	}
+
+	if _, err := tests.ReadRange(ctx, clients[0].Bucket("bucket").Object("missing"), 0, 10); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadRange(missing) error got %v, want %v", err, fs.ErrNotExist)
+	}
+
+	// Other buckets are read directly.
+	before, reads := clients[0].Stats(), atomic.LoadInt64(&sink.reads)
+	for i := 0; i < 2; i++ {
+		if _, err := tests.ReadRange(ctx, clients[0].Bucket("other").Object("dir/obj"), 0, 200); err != nil {
+			t.Fatalf("ReadRange(other) failed: %v", err)
+		}
+	}
+	if got := atomic.LoadInt64(&sink.reads) - reads; got != 2 {
+		t.Errorf("storage reads of other = got %d, want 2", got)
+	}
+	if got := clients[0].Stats(); got != before {
+		t.Errorf("Stats() = got %+v, want %+v", got, before)
+	}
+}
+
+func TestPeerCache_PeerDown(t *testing.T) {
+	t.Parallel()
+
+	content := tests.GenTestByteSeq(200)
+	clients, urls, sink := newPeers(t, 1, content)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	// Pages owned by the unreachable peer are read from storage locally.
+	c := clients[0]
+	c.SetPeers(urls[0], down.URL)
+	for i := 0; i < 2; i++ {
+		got, err := tests.ReadRange(context.Background(), c.Bucket("bucket").Object("dir/obj"), 0, 200)
+		if err != nil {
+			t.Fatalf("ReadRange() failed: %v", err)
+		}
+		if diff := cmp.Diff(content, got); diff != "" {
+			t.Errorf("ReadRange() = (-want, +got):\n%s", diff)
+		}
+	}
+	if got := atomic.LoadInt64(&sink.reads); got != 13 {
+		t.Errorf("storage reads = got %d, want 13", got)
+	}
+	if s := c.Stats(); s.PeerErrors == 0 || s.LocalLoads != 13 || s.Hits != 13 {
+		t.Errorf("Stats() = got %+v, want peer errors, 13 local loads and 13 hits", s)
+	}
+}
+
+func TestPeerCache_Drop(t *testing.T) {
+	t.Parallel()
+
+	content := tests.GenTestByteSeq(200)
+	mc := mem.NewClient()
+	mc.MemBucket("bucket").Put("dir/obj", content)
+	// The first response breaks after 5 bytes.
+	fc := fault.NewClient(mc, fault.Options{Rules: []fault.Rule{{Kind: fault.Drop, Bytes: 5, Count: 1}}})
+	c := peercache.NewClient(fc, peercache.Options{Bucket: "bucket", Secret: testSecret, PageSize: 16})
+	o := c.Bucket("bucket").Object("dir/obj")
+
+	// A broken response isn't cached as a short page.
+	if got, err := tests.ReadRange(context.Background(), o, 0, 16); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("ReadRange() = got (%q, %v), want error %v", got, err, io.ErrUnexpectedEOF)
+	}
+	got, err := tests.ReadRange(context.Background(), o, 0, 16)
+	if err != nil {
+		t.Fatalf("ReadRange() failed: %v", err)
+	}
+	if diff := cmp.Diff(content[:16], got); diff != "" {
+		t.Errorf("ReadRange() = (-want, +got):\n%s", diff)
+	}
+}
+
+func TestPeerCache_ServeHTTP(t *testing.T) {
+	t.Parallel()
+
+	content := tests.GenTestByteSeq(200)
+	clients, urls, _ := newPeers(t, 1, content)
+	c := clients[0]
+
+	get := func(path, secret string) (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodGet, urls[0]+peercache.DefaultBasePath+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(peercache.SecretHeader, secret)
+		return http.DefaultClient.Do(req)
+	}
+
+	testCases := []struct {
+		path   string
+		secret string
+		want   int
+	}{
+		{"?key=16/dir/obj", testSecret, http.StatusOK},
+		{"?key=0/missing", testSecret, http.StatusNotFound},
+		{"?key=dir/obj", testSecret, http.StatusBadRequest},
+		{"?key=-16/dir/obj", testSecret, http.StatusBadRequest},
+		{"?key=16/", testSecret, http.StatusBadRequest},
+		{"?key=16/dir/obj", "", http.StatusForbidden},
+		{"?key=16/dir/obj", "wrong", http.StatusForbidden},
+	}
+	for _, tc := range testCases {
+		res, err := get(tc.path, tc.secret)
+		if err != nil {
+			t.Fatalf("Get(%q) failed: %v", tc.path, err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tc.want {
+			t.Errorf("Get(%q) = got %d, want %d", tc.path, res.StatusCode, tc.want)
+		}
+		if tc.want == http.StatusOK {
+			if diff := cmp.Diff(content[16:32], body); diff != "" {
+				t.Errorf("Get(%q) = (-want, +got):\n%s", tc.path, diff)
+			}
+		}
+	}
+
+	// Pages owned by another peer are refused.
+	c.SetPeers("http://other.invalid")
+	res, err := get("?key=16/dir/obj", testSecret)
+	if err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusMisdirectedRequest {
+		t.Errorf("Get() = got %d, want %d", res.StatusCode, http.StatusMisdirectedRequest)
+	}
+}
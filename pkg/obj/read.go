@@ -0,0 +1,50 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package obj
+
+import (
+	"context"
+	"io"
+)
+
+// ReadFull reads the section [offset, offset+length) of o, with length > 0,
+// with a single range reader. It returns fewer than length bytes with a nil
+// error only if o ends within the section. A response that ends early may
+// also be a broken one, so the size of o is checked with Attrs before a
+// short section is returned; if o doesn't end there, the bytes read are
+// returned with io.ErrUnexpectedEOF. Decorators that keep what they read
+// must not keep such a section.
+func ReadFull(ctx context.Context, o Object, offset, length int64) ([]byte, error) {
+	rd, err := o.NewRangeReader(ctx, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+	data := make([]byte, length)
+	n, err := io.ReadFull(rd, data)
+	data = data[:n]
+	if err != io.EOF && err != io.ErrUnexpectedEOF {
+		return data, err
+	}
+
+	attrs, err := o.Attrs(ctx)
+	if err != nil {
+		return data, err
+	}
+	if offset+int64(n) != attrs.Size {
+		return data, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
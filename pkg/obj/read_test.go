@@ -0,0 +1,65 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package obj_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/fault"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/mem"
+	"github.com/googlecloudplatform/pi-delivery/pkg/tests"
+)
+
+func TestReadFull(t *testing.T) {
+	t.Parallel()
+
+	content := tests.GenTestByteSeq(100)
+	testCases := []struct {
+		name    string
+		rule    fault.Rule
+		off     int64
+		length  int64
+		want    []byte
+		wantErr error
+	}{
+		{"full", fault.Rule{}, 10, 20, content[10:30], nil},
+		{"end of object", fault.Rule{}, 90, 20, content[90:], nil},
+		{"past the end", fault.Rule{}, 100, 20, nil, io.EOF},
+		{"drop", fault.Rule{Kind: fault.Drop, Bytes: 5}, 10, 20, content[10:15], io.ErrUnexpectedEOF},
+		{"short read", fault.Rule{Kind: fault.ShortRead, Bytes: 5}, 10, 20, content[10:15], io.ErrUnexpectedEOF},
+		{"short read at the end", fault.Rule{Kind: fault.ShortRead, Bytes: 10}, 90, 20, content[90:], nil},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			mc := mem.NewClient()
+			mc.MemBucket("bucket").Put("obj", content)
+			c := fault.NewClient(mc, fault.Options{Rules: []fault.Rule{tc.rule}})
+			got, err := obj.ReadFull(context.Background(), c.Bucket("bucket").Object("obj"), tc.off, tc.length)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("ReadFull() error got %v, want %v", err, tc.wantErr)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("ReadFull() = (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -16,9 +16,86 @@ package obj
 
 import (
 	"context"
+	"errors"
 	"io"
+	"io/fs"
+	"time"
 )
 
+// Done is returned by ObjectIterator.Next when there are no more objects.
+var Done = errors.New("obj: no more objects in iterator")
+
+// TransientError wraps an error that a backend considers temporary,
+// such as throttling or server errors. Callers may retry the request.
+type TransientError struct {
+	Err error
+}
+
+func (e *TransientError) Error() string {
+	return "transient error: " + e.Err.Error()
+}
+
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
+// NotExistError wraps an error that a backend returns for a bucket or
+// an object that doesn't exist. It matches fs.ErrNotExist with errors.Is.
+type NotExistError struct {
+	Err error
+}
+
+func (e *NotExistError) Error() string {
+	return "not found: " + e.Err.Error()
+}
+
+func (e *NotExistError) Unwrap() error {
+	return e.Err
+}
+
+func (e *NotExistError) Is(target error) bool {
+	return target == fs.ErrNotExist
+}
+
+// ObjectAttrs are attributes of an object.
+// Fields a backend doesn't know are left as zero values.
+type ObjectAttrs struct {
+	// Name is the name of the object in the bucket.
+	Name string
+	// Size is the size of the object in bytes.
+	Size int64
+	// CRC32C is the CRC32 checksum of the object with the Castagnoli polynomial.
+	// It's valid only if HasCRC32C is true.
+	CRC32C    uint32
+	HasCRC32C bool
+	// Generation is the version of the object content if the backend
+	// versions objects.
+	Generation int64
+	// ETag is an opaque identifier that changes when the object changes.
+	ETag string
+	// Updated is the last modification time of the object.
+	Updated time.Time
+}
+
+// Query selects objects to list.
+type Query struct {
+	// Prefix selects objects whose names start with Prefix.
+	Prefix string
+	// StartOffset selects objects whose names are lexicographically equal to
+	// or greater than StartOffset, e.g. to resume listing.
+	StartOffset string
+	// PageSize is the number of objects a backend fetches per request.
+	// The backend's default if 0.
+	PageSize int
+}
+
+// ObjectIterator iterates over objects in a bucket in lexicographical order of names.
+type ObjectIterator interface {
+	// Next returns the attributes of the next object.
+	// It returns Done if there are no more objects.
+	Next() (*ObjectAttrs, error)
+}
+
 //go:generate go run github.com/golang/mock/mockgen -source=$GOFILE -destination=./mocks/storage.go
 
 // Client is an interface for object storage.
@@ -33,11 +110,18 @@ type Client interface {
 type Bucket interface {
 	// Object returns a handle to an object specified by name.
 	Object(name string) Object
+	// List returns an iterator over objects selected by q. All objects if q is nil.
+	// Objects are fetched page by page as the iterator advances.
+	List(ctx context.Context, q *Query) ObjectIterator
 }
 
 // Object is an interface to an object in object storage.
 type Object interface {
 	// NewRangeReader returns a new io.ReadCloser for the section [offset, offset+length)
-	// for the object.
+	// for the object. A Read of the reader returns when ctx is done.
+	// The reader needn't support a Close during a Read.
 	NewRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error)
+	// Attrs returns the attributes of the object. The error matches
+	// fs.ErrNotExist if the object doesn't exist.
+	Attrs(ctx context.Context) (*ObjectAttrs, error)
 }
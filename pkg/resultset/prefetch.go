@@ -0,0 +1,312 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resultset
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+	"github.com/googlecloudplatform/pi-delivery/pkg/ycd"
+)
+
+const (
+	// DefaultPrefetchDepth is the default number of chunks fetched ahead.
+	DefaultPrefetchDepth = 8
+	// DefaultPrefetchChunkSize is the default size of a chunk in bytes.
+	DefaultPrefetchChunkSize = 8 * 1024 * 1024
+)
+
+// PrefetchOptions configures a PrefetchReader.
+type PrefetchOptions struct {
+	// Depth is the maximum number of chunks fetched or buffered ahead of
+	// the consumer. DefaultPrefetchDepth if 0.
+	Depth int
+	// ChunkSize is the size of each range request in bytes.
+	// It is rounded down to a multiple of words.
+	// DefaultPrefetchChunkSize if 0.
+	ChunkSize int
+	// Retry is the retry policy for range requests. DefaultRetryPolicy() if zero.
+	Retry RetryPolicy
+}
+
+// chunk is a section of a block fetched by a range request.
+type chunk struct {
+	off  int64
+	buf  []byte
+	err  error
+	done chan struct{}
+}
+
+// PrefetchReader is a sequential reader for a ResultSet that keeps up to
+// Depth range requests in flight ahead of the consumer.
+// A chunk never spans blocks, so each chunk is a single range request.
+// Chunk buffers are reused once they are consumed.
+// PrefetchReader can be used as an UpstreamReader of unpack.UnpackReader.
+// Must be created by NewPrefetchReader() and the caller must Close() after use.
+type PrefetchReader struct {
+	set       ResultSet
+	bucket    obj.Bucket
+	ctx       context.Context
+	depth     int
+	chunkSize int
+	retry     RetryPolicy
+	stats     RetryStats
+
+	off  int64
+	cur  *chunk
+	pos  int
+	pool sync.Pool
+
+	queue chan *chunk
+	// slots limits the number of chunks not consumed yet to depth.
+	slots  chan struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+var _ io.ReadSeekCloser = new(PrefetchReader)
+var _ io.ReaderAt = new(PrefetchReader)
+var _ io.WriterTo = new(PrefetchReader)
+
+// NewPrefetchReader returns a new PrefetchReader for the result set.
+// Requests are made with ctx.
+func (s ResultSet) NewPrefetchReader(ctx context.Context, bucket obj.Bucket, opts PrefetchOptions) *PrefetchReader {
+	if opts.Depth <= 0 {
+		opts.Depth = DefaultPrefetchDepth
+	}
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultPrefetchChunkSize
+	}
+	if opts.ChunkSize < ycd.WordSize {
+		opts.ChunkSize = ycd.WordSize
+	}
+	opts.ChunkSize -= opts.ChunkSize % ycd.WordSize
+
+	r := &PrefetchReader{
+		set:       s,
+		bucket:    bucket,
+		ctx:       ctx,
+		depth:     opts.Depth,
+		chunkSize: opts.ChunkSize,
+		retry:     opts.Retry.orDefault(),
+	}
+	r.pool.New = func() interface{} {
+		return make([]byte, r.chunkSize)
+	}
+	return r
+}
+
+// start starts fetching chunks from r.off.
+func (r *PrefetchReader) start() {
+	ctx, cancel := context.WithCancel(r.ctx)
+	queue := make(chan *chunk, r.depth)
+	slots := make(chan struct{}, r.depth)
+	r.cancel = cancel
+	r.queue = queue
+	r.slots = slots
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer close(queue)
+		total := r.set.DataByteLength()
+		blockLen := r.set.BlockByteLength()
+		for off := r.off; off < total; {
+			length := int64(r.chunkSize)
+			if end := (off/blockLen + 1) * blockLen; off+length > end {
+				length = end - off
+			}
+			if off+length > total {
+				length = total - off
+			}
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			c := &chunk{
+				off:  off,
+				buf:  r.pool.Get().([]byte)[:length],
+				done: make(chan struct{}),
+			}
+			queue <- c
+			r.wg.Add(1)
+			go func() {
+				defer r.wg.Done()
+				defer close(c.done)
+				n, err := r.fetcher().readOnce(ctx, c.buf, c.off)
+				if err == io.ErrUnexpectedEOF {
+					// Short objects are reported as EOF at the end of the result set.
+					err = io.EOF
+				}
+				c.buf = c.buf[:n]
+				c.err = err
+			}()
+			off += length
+		}
+	}()
+}
+
+// stop cancels in-flight requests and returns the buffers of chunks
+// fetched ahead to the pool.
+func (r *PrefetchReader) stop() {
+	if r.queue == nil {
+		return
+	}
+	r.cancel()
+	for c := range r.queue {
+		<-c.done
+		r.pool.Put(c.buf[:cap(c.buf)])
+	}
+	r.wg.Wait()
+	r.release()
+	r.queue = nil
+	r.slots = nil
+	r.cancel = nil
+}
+
+// release returns the current chunk buffer to the pool.
+func (r *PrefetchReader) release() {
+	if r.cur != nil {
+		r.pool.Put(r.cur.buf[:cap(r.cur.buf)])
+		r.cur = nil
+		r.pos = 0
+		<-r.slots
+	}
+}
+
+// next returns the unread bytes of the current chunk, waiting for the next
+// chunk if the current chunk is consumed.
+func (r *PrefetchReader) next() ([]byte, error) {
+	if r.cur != nil && r.pos < len(r.cur.buf) {
+		return r.cur.buf[r.pos:], nil
+	}
+	if r.cur != nil && r.cur.err != nil {
+		return nil, r.cur.err
+	}
+	r.release()
+	if r.queue == nil {
+		r.start()
+	}
+	c, ok := <-r.queue
+	if !ok {
+		if err := r.ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	<-c.done
+	r.cur = c
+	if len(c.buf) == 0 {
+		if c.err != nil {
+			return nil, c.err
+		}
+		return nil, io.ErrNoProgress
+	}
+	return c.buf, nil
+}
+
+// Read reads up to len(p) bytes of packed digits at the current position.
+// Read returns io.EOF at the end of the result set.
+func (r *PrefetchReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	buf, err := r.next()
+	if len(buf) == 0 {
+		return 0, err
+	}
+	n := copy(p, buf)
+	r.pos += n
+	r.off += int64(n)
+	return n, nil
+}
+
+// WriteTo writes packed digits from the current position to the end of
+// the result set to w. Chunk buffers are passed to w directly.
+func (r *PrefetchReader) WriteTo(w io.Writer) (int64, error) {
+	written := int64(0)
+	for {
+		buf, err := r.next()
+		if len(buf) == 0 {
+			if err == io.EOF {
+				return written, nil
+			}
+			return written, err
+		}
+		n, err := w.Write(buf)
+		r.pos += n
+		r.off += int64(n)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// ReadAt reads len(p) bytes of packed digits starting at byte result offset
+// without affecting the sequential position.
+func (r *PrefetchReader) ReadAt(p []byte, off int64) (int, error) {
+	return r.fetcher().readAt(r.ctx, p, off)
+}
+
+func (r *PrefetchReader) fetcher() *fetcher {
+	return &fetcher{
+		set:    r.set,
+		bucket: r.bucket,
+		retry:  r.retry,
+		stats:  &r.stats,
+	}
+}
+
+// RetryStats returns the number of retries made by the PrefetchReader so far.
+func (r *PrefetchReader) RetryStats() RetryStats {
+	return r.stats.snapshot()
+}
+
+// Seek sets the byte result offset for the next Read().
+// Changing the offset discards chunks fetched ahead.
+func (r *PrefetchReader) Seek(offset int64, whence int) (int64, error) {
+	off := r.off
+	switch whence {
+	case io.SeekStart:
+		off = offset
+	case io.SeekCurrent:
+		off += offset
+	case io.SeekEnd:
+		off = r.set.DataByteLength() + offset
+	}
+	if off < 0 {
+		return r.off, errors.New("Seek: negative offset")
+	}
+	if r.off != off {
+		r.stop()
+		r.off = off
+	}
+	return off, nil
+}
+
+// Close cancels in-flight requests and waits for them to finish.
+func (r *PrefetchReader) Close() error {
+	r.stop()
+	return nil
+}
+
+// ResultSet returns the underlying ResultSet.
+func (r *PrefetchReader) ResultSet() ResultSet {
+	return r.set
+}
@@ -0,0 +1,198 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resultset_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/go-cmp/cmp"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/mem"
+	"github.com/googlecloudplatform/pi-delivery/pkg/resultset"
+	"github.com/googlecloudplatform/pi-delivery/pkg/tests"
+	"github.com/googlecloudplatform/pi-delivery/pkg/ycd"
+)
+
+// newPrefetchTestSet returns a hexadecimal result set of blocks blocks of blockSize digits.
+// The last block has last digits if last > 0.
+func newPrefetchTestSet(blocks int, blockSize, last int64) resultset.ResultSet {
+	set := resultset.ResultSet{}
+	for i := 0; i < blocks; i++ {
+		h := &ycd.Header{
+			Radix:     16,
+			BlockSize: blockSize,
+			BlockID:   int64(i),
+		}
+		if i == blocks-1 && last > 0 {
+			h.TotalDigits = int64(i)*blockSize + last
+		}
+		set = append(set, &ycd.YCDFile{
+			Header:           h,
+			Name:             fmt.Sprintf("Pi - Hex - Chudnovsky - %d.ycd", i),
+			FirstDigitOffset: 201,
+		})
+	}
+	return set
+}
+
+func TestPrefetchReader_IOTest(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+		set  resultset.ResultSet
+		opts resultset.PrefetchOptions
+		// length is the number of bytes stored in the objects.
+		length int
+	}{
+		{"whole blocks", newPrefetchTestSet(3, 100, 0), resultset.PrefetchOptions{Depth: 2, ChunkSize: 16}, 3 * 56},
+		{"partial blocks", newPrefetchTestSet(3, 100, 50), resultset.PrefetchOptions{Depth: 4, ChunkSize: 24}, 2*56 + 32},
+		{"unaligned chunks", newPrefetchTestSet(5, 30, 7), resultset.PrefetchOptions{Depth: 3, ChunkSize: 13}, 4*16 + 8},
+		{"large chunks", newPrefetchTestSet(4, 100, 0), resultset.PrefetchOptions{}, 4 * 56},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			testBuf := tests.GenTestByteSeq(tc.length)
+			bucket := tests.NewMockBucket(ctx, gomock.NewController(t), tc.set, testBuf)
+
+			rd := tc.set.NewPrefetchReader(ctx, bucket, tc.opts)
+			t.Cleanup(func() {
+				if err := rd.Close(); err != nil {
+					t.Errorf("Close() failed: %v", err)
+				}
+			})
+			if err := iotest.TestReader(rd, testBuf); err != nil {
+				t.Errorf("TestReader() failed: %v", err)
+			}
+
+			if _, err := rd.Seek(0, io.SeekStart); err != nil {
+				t.Fatalf("Seek() failed: %v", err)
+			}
+			buf := new(bytes.Buffer)
+			n, err := rd.WriteTo(buf)
+			if err != nil {
+				t.Fatalf("WriteTo() failed: %v", err)
+			}
+			if n != int64(len(testBuf)) {
+				t.Errorf("WriteTo(): n = got %d, want %d", n, len(testBuf))
+			}
+			if diff := cmp.Diff(testBuf, buf.Bytes()); diff != "" {
+				t.Errorf("WriteTo() = (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// slowBucket is an obj.Bucket that serves the objects of a bucket with
+// latency and records the maximum number of concurrent requests.
+type slowBucket struct {
+	obj.Bucket
+
+	mu          sync.Mutex
+	inflight    int
+	maxInflight int
+}
+
+type slowObject struct {
+	obj.Object
+	b *slowBucket
+}
+
+// newSlowBucket returns a slowBucket with buf stored as the objects of set.
+func newSlowBucket(set resultset.ResultSet, buf []byte) *slowBucket {
+	bucket := mem.NewClient().MemBucket("pi")
+	tests.PutResultSet(bucket, set, buf)
+	return &slowBucket{Bucket: bucket}
+}
+
+func (b *slowBucket) Object(name string) obj.Object {
+	return &slowObject{Object: b.Bucket.Object(name), b: b}
+}
+
+func (o *slowObject) NewRangeReader(ctx context.Context, off, length int64) (io.ReadCloser, error) {
+	o.b.mu.Lock()
+	o.b.inflight++
+	if o.b.inflight > o.b.maxInflight {
+		o.b.maxInflight = o.b.inflight
+	}
+	o.b.mu.Unlock()
+	defer func() {
+		o.b.mu.Lock()
+		o.b.inflight--
+		o.b.mu.Unlock()
+	}()
+
+	select {
+	case <-time.After(10 * time.Millisecond):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return o.Object.NewRangeReader(ctx, off, length)
+}
+
+func TestPrefetchReader_Depth(t *testing.T) {
+	t.Parallel()
+
+	const depth = 4
+	set := newPrefetchTestSet(8, 100, 0)
+	testBuf := tests.GenTestByteSeq(int(set.TotalByteLength()))
+	bucket := newSlowBucket(set, testBuf)
+	rd := set.NewPrefetchReader(context.Background(), bucket, resultset.PrefetchOptions{
+		Depth:     depth,
+		ChunkSize: 16,
+	})
+	defer rd.Close()
+
+	got, err := io.ReadAll(rd)
+	if err != nil {
+		t.Fatalf("ReadAll() failed: %v", err)
+	}
+	if diff := cmp.Diff(testBuf, got); diff != "" {
+		t.Errorf("ReadAll() = (-want, +got):\n%s", diff)
+	}
+	if bucket.maxInflight < 2 || bucket.maxInflight > depth {
+		t.Errorf("max concurrent requests = got %d, want 2 to %d", bucket.maxInflight, depth)
+	}
+}
+
+func TestPrefetchReader_Cancel(t *testing.T) {
+	t.Parallel()
+
+	set := newPrefetchTestSet(8, 100, 0)
+	bucket := newSlowBucket(set, tests.GenTestByteSeq(int(set.TotalByteLength())))
+	ctx, cancel := context.WithCancel(context.Background())
+	rd := set.NewPrefetchReader(ctx, bucket, resultset.PrefetchOptions{Depth: 4, ChunkSize: 16})
+	defer rd.Close()
+
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(rd, buf); err != nil {
+		t.Fatalf("ReadFull() failed: %v", err)
+	}
+	cancel()
+	if _, err := io.ReadAll(rd); err == nil {
+		t.Errorf("ReadAll() after cancel = got nil error, want non-nil")
+	}
+}
@@ -18,6 +18,8 @@ import (
 	"context"
 	"errors"
 	"io"
+	"sync"
+	"sync/atomic"
 
 	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
 )
@@ -27,38 +29,55 @@ import (
 // as necessary. Alternatively you can also use ReadAt to read a section of ResultSet.
 // Must be created by NewReader() and the caller must Close() after use.
 type Reader struct {
+	ctx    context.Context
 	set    ResultSet
 	bucket obj.Bucket
+	opts   ReaderOptions
+	stats  RetryStats
 	off    int64
 	rd     io.ReadCloser
 	seeked bool
 }
 
+// DefaultPartSize is the default size of a sub-range request of ReadAt
+// when Parallelism is set.
+const DefaultPartSize = 16 * 1024 * 1024
+
+// ReaderOptions configures a Reader.
+type ReaderOptions struct {
+	// Parallelism is the maximum number of concurrent range requests ReadAt makes.
+	// ReadAt reads sequentially if it's 0 or 1.
+	Parallelism int
+	// PartSize is the maximum size of each range request of ReadAt in bytes
+	// when Parallelism is greater than 1. DefaultPartSize if 0.
+	PartSize int64
+	// Retry is the retry policy for range requests. DefaultRetryPolicy() if zero.
+	Retry RetryPolicy
+}
+
 // Reader implements both io.ReaderAt and io.ReadSeekCloser
 var _ io.ReadSeekCloser = new(Reader)
 var _ io.ReaderAt = new(Reader)
 
-func readOnce(set ResultSet, bucket obj.Bucket, p []byte, off int64) (int, error) {
-	reader, err := newRangeReader(context.Background(), set, bucket, off, int64(len(p)))
-	if err != nil {
-		return 0, err
-	}
-	defer reader.Close()
-
-	return io.ReadFull(reader, p)
-}
-
-// ReadAt reads len(p) bytes of packed digits starting at byte result offset
-// (first byte in the result set is 0).
-// Returns io.EOF at the end of the result set.
-func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+// readAt reads len(p) bytes starting at off, switching objects as necessary.
+func (f *fetcher) readAt(ctx context.Context, p []byte, off int64) (int, error) {
 	n := 0
+	blockLen := f.set.BlockByteLength()
 
 	for n < len(p) {
-		read, err := readOnce(r.set, r.bucket, p[n:], off+int64(n))
+		// Read up to the end of the block.
+		length := int64(len(p) - n)
+		if blockLen > 0 {
+			if rem := blockLen - (off+int64(n))%blockLen; length > rem {
+				length = rem
+			}
+		}
+		read, err := f.readOnce(ctx, p[n:n+int(length)], off+int64(n))
 		n += read
 		if err == io.ErrUnexpectedEOF {
-			continue
+			// The object is shorter than the block, which only happens
+			// at the end of the result set.
+			return n, io.EOF
 		}
 		if err != nil {
 			return n, err
@@ -67,6 +86,121 @@ func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
 	return n, nil
 }
 
+// part is a sub-range of a parallel ReadAt within a block.
+type part struct {
+	p   []byte
+	off int64
+	n   int
+	err error
+}
+
+// splitParts splits [off, off+len(p)) into parts of at most partSize bytes
+// that don't span blocks.
+func splitParts(set ResultSet, p []byte, off, partSize int64) []*part {
+	blockLen := set.BlockByteLength()
+	var parts []*part
+	for start := int64(0); start < int64(len(p)); {
+		length := int64(len(p)) - start
+		if length > partSize {
+			length = partSize
+		}
+		if end := ((off+start)/blockLen + 1) * blockLen; off+start+length > end {
+			length = end - off - start
+		}
+		parts = append(parts, &part{p: p[start : start+length], off: off + start})
+		start += length
+	}
+	return parts
+}
+
+// readAtParallel reads parts of [off, off+len(p)) with up to parallelism
+// concurrent range requests. It returns the number of contiguous bytes read
+// from off and the first error in the order of offsets.
+func (f *fetcher) readAtParallel(ctx context.Context, p []byte, off int64, parallelism int, partSize int64) (int, error) {
+	set := f.set
+	// Don't request bytes past the end of the partial last block.
+	var eof error
+	if end := set.DataByteLength(); off+int64(len(p)) > end {
+		if off >= end {
+			return 0, io.EOF
+		}
+		p = p[:end-off]
+		eof = io.EOF
+	}
+	parts := splitParts(set, p, off, partSize)
+	if len(parts) == 1 {
+		n, err := f.readAt(ctx, p, off)
+		if err == nil {
+			err = eof
+		}
+		return n, err
+	}
+
+	// Parts are started in order, so parts skipped after a failure
+	// always come after the failed part.
+	var failed int32
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for _, pt := range parts {
+		pt := pt
+		sem <- struct{}{}
+		if atomic.LoadInt32(&failed) != 0 {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			pt.n, pt.err = f.readOnce(ctx, pt.p, pt.off)
+			if pt.err != nil && pt.err != io.EOF && pt.err != io.ErrUnexpectedEOF {
+				atomic.StoreInt32(&failed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	n := 0
+	for _, pt := range parts {
+		n += pt.n
+		if pt.n < len(pt.p) {
+			if pt.err == nil || pt.err == io.ErrUnexpectedEOF {
+				// The object ended before the end of the part.
+				return n, io.EOF
+			}
+			return n, pt.err
+		}
+	}
+	return n, eof
+}
+
+// ReadAt reads len(p) bytes of packed digits starting at byte result offset
+// (first byte in the result set is 0).
+// If Parallelism is set, a read spanning multiple blocks or larger than
+// PartSize is split into concurrent range requests.
+// Returns io.EOF at the end of the result set.
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	f := r.fetcher()
+	if r.opts.Parallelism > 1 {
+		return f.readAtParallel(r.ctx, p, off, r.opts.Parallelism, r.opts.PartSize)
+	}
+	return f.readAt(r.ctx, p, off)
+}
+
+func (r *Reader) fetcher() *fetcher {
+	return &fetcher{
+		set:    r.set,
+		bucket: r.bucket,
+		retry:  r.opts.Retry,
+		stats:  &r.stats,
+	}
+}
+
+// RetryStats returns the number of retries made by the Reader so far.
+func (r *Reader) RetryStats() RetryStats {
+	return r.stats.snapshot()
+}
+
 // Read reads len(p) bytes of packed digits at the current position.
 // Read returns at the end of each block with error == nil.
 // Callers should continue to call Read() if it needs more digits.
@@ -75,7 +209,7 @@ func (r *Reader) Read(p []byte) (int, error) {
 		if err := r.Close(); err != nil {
 			return 0, err
 		}
-		reader, err := newRangeReader(context.Background(), r.set, r.bucket, r.off, -1)
+		reader, err := r.fetcher().open(r.ctx, r.off, -1)
 		r.rd = reader
 		r.seeked = false
 		if err != nil {
@@ -22,6 +22,7 @@ import (
 	"io"
 	"testing"
 	"testing/iotest"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/google/go-cmp/cmp"
@@ -169,6 +170,7 @@ func TestResultSet_ReadAt(t *testing.T) {
 		},
 	}
 	for _, tc := range testCases {
+		tc := tc
 		t.Run(fmt.Sprintf("%s, off = %d, n = %d", tc.name, tc.off, tc.n), func(t *testing.T) {
 			t.Parallel()
 			ctx := context.Background()
@@ -287,3 +289,172 @@ func TestResultSet_IOTest(t *testing.T) {
 		})
 	}
 }
+
+func TestResultSet_ParallelReadAt(t *testing.T) {
+	t.Parallel()
+
+	set := newPrefetchTestSet(6, 100, 30)
+	// 5 blocks of 56 bytes and the last block of 16 bytes.
+	testBuf := tests.GenTestByteSeq(5*56 + 16)
+	total := len(testBuf)
+
+	for _, opts := range []resultset.ReaderOptions{
+		{Parallelism: 2, PartSize: 8},
+		{Parallelism: 4, PartSize: 24},
+		{Parallelism: 8},
+	} {
+		opts := opts
+		t.Run(fmt.Sprintf("Parallelism %d PartSize %d", opts.Parallelism, opts.PartSize), func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			bucket := tests.NewMockBucket(ctx, gomock.NewController(t), set, testBuf)
+			rd := set.NewReaderWithOptions(ctx, bucket, opts)
+			defer rd.Close()
+
+			for off := 0; off < total+8; off += 7 {
+				for _, n := range []int{1, 8, 50, 130, total} {
+					buf := make([]byte, n)
+					got, err := rd.ReadAt(buf, int64(off))
+					want := n
+					if off+n > total {
+						want = total - off
+						if want < 0 {
+							want = 0
+						}
+						if !errors.Is(err, io.EOF) {
+							t.Errorf("ReadAt(off = %d, n = %d) error got %v, want EOF", off, n, err)
+						}
+					} else if err != nil {
+						t.Errorf("ReadAt(off = %d, n = %d) failed: %v", off, n, err)
+					}
+					if got != want {
+						t.Fatalf("ReadAt(off = %d, n = %d): n = got %d, want %d", off, n, got, want)
+					}
+					if want > 0 {
+						if diff := cmp.Diff(testBuf[off:off+want], buf[:got]); diff != "" {
+							t.Fatalf("ReadAt(off = %d, n = %d) = (-want, +got):\n%s", off, n, diff)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestResultSet_ParallelReadAtError(t *testing.T) {
+	t.Parallel()
+
+	set := newPrefetchTestSet(4, 100, 0)
+	testBuf := tests.GenTestByteSeq(4 * 56)
+	errBroken := errors.New("broken object")
+
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	bucket := mock_obj.NewMockBucket(ctrl)
+	for i, f := range set {
+		i := i
+		object := mock_obj.NewMockObject(ctrl)
+		object.EXPECT().NewRangeReader(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, off, length int64) (io.ReadCloser, error) {
+				if i == 2 {
+					return nil, errBroken
+				}
+				return tests.NewTestReader(set, i, testBuf, off, length)
+			},
+		).AnyTimes()
+		bucket.EXPECT().Object(f.Name).Return(object).AnyTimes()
+	}
+
+	rd := set.NewReaderWithOptions(ctx, bucket, resultset.ReaderOptions{Parallelism: 4, PartSize: 16})
+	defer rd.Close()
+	buf := make([]byte, len(testBuf)-10)
+	n, err := rd.ReadAt(buf, 10)
+	if !errors.Is(err, errBroken) {
+		t.Errorf("ReadAt() error got %v, want %v", err, errBroken)
+	}
+	if want := 2*56 - 10; n != want {
+		t.Errorf("ReadAt(): n = got %d, want %d", n, want)
+	}
+	if diff := cmp.Diff(testBuf[10:10+n], buf[:n]); diff != "" {
+		t.Errorf("ReadAt() = (-want, +got):\n%s", diff)
+	}
+}
+
+// blockingReader cancels the request in Read and blocks until the
+// context of the request is done, as readers of backends do.
+type blockingReader struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	closed chan struct{}
+	// closedInRead is set if Close was called during Read.
+	closedInRead bool
+}
+
+func (r *blockingReader) Read(p []byte) (int, error) {
+	r.cancel()
+	<-r.ctx.Done()
+	// Give a concurrent Close time to happen.
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case <-r.closed:
+		r.closedInRead = true
+	default:
+	}
+	return 0, errors.New("read aborted")
+}
+
+func (r *blockingReader) Close() error {
+	close(r.closed)
+	return nil
+}
+
+func TestResultSet_Cancel(t *testing.T) {
+	t.Parallel()
+
+	set := newPrefetchTestSet(2, 100, 0)
+
+	t.Run("in flight", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		ctrl := gomock.NewController(t)
+		bucket := mock_obj.NewMockBucket(ctrl)
+		object := mock_obj.NewMockObject(ctrl)
+		bucket.EXPECT().Object(set[0].Name).Return(object)
+		blocking := &blockingReader{ctx: ctx, cancel: cancel, closed: make(chan struct{})}
+		object.EXPECT().NewRangeReader(gomock.Any(), gomock.Any(), gomock.Any()).Return(blocking, nil)
+
+		rd := set.NewReader(ctx, bucket)
+		defer rd.Close()
+		n, err := rd.ReadAt(make([]byte, 16), 0)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("ReadAt() error got %v, want %v", err, context.Canceled)
+		}
+		if n != 0 {
+			t.Errorf("ReadAt(): n = got %d, want 0", n)
+		}
+		select {
+		case <-blocking.closed:
+		case <-time.After(time.Second):
+			t.Errorf("the reader wasn't closed")
+		}
+		if blocking.closedInRead {
+			t.Errorf("the reader was closed during Read")
+		}
+	})
+
+	t.Run("before read", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		// No requests are expected.
+		bucket := mock_obj.NewMockBucket(gomock.NewController(t))
+		rd := set.NewReader(ctx, bucket)
+		defer rd.Close()
+		if _, err := rd.ReadAt(make([]byte, 16), 0); !errors.Is(err, context.Canceled) {
+			t.Errorf("ReadAt() error got %v, want %v", err, context.Canceled)
+		}
+		if _, err := rd.Read(make([]byte, 16)); !errors.Is(err, context.Canceled) {
+			t.Errorf("Read() error got %v, want %v", err, context.Canceled)
+		}
+	})
+}
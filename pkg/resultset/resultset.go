@@ -17,7 +17,9 @@ package resultset
 import (
 	"context"
 	"io"
+	"io/fs"
 	"sort"
+	"sync"
 
 	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
 	"github.com/googlecloudplatform/pi-delivery/pkg/ycd"
@@ -44,10 +46,22 @@ func (s ResultSet) Swap(i, j int) {
 }
 
 // NewReader returns a new ResultSetReader with bucket.
+// Range requests are made with ctx and aborted when ctx is done.
 func (s ResultSet) NewReader(ctx context.Context, bucket obj.Bucket) *Reader {
+	return s.NewReaderWithOptions(ctx, bucket, ReaderOptions{})
+}
+
+// NewReaderWithOptions returns a new ResultSetReader with bucket configured by opts.
+func (s ResultSet) NewReaderWithOptions(ctx context.Context, bucket obj.Bucket, opts ReaderOptions) *Reader {
+	if opts.Parallelism > 1 && opts.PartSize <= 0 {
+		opts.PartSize = DefaultPartSize
+	}
+	opts.Retry = opts.Retry.orDefault()
 	return &Reader{
+		ctx:    ctx,
 		bucket: bucket,
 		set:    s,
+		opts:   opts,
 	}
 }
 
@@ -100,6 +114,20 @@ func (s ResultSet) TotalByteLength() int64 {
 	return s[0].BlockByteLength() * int64(len(s))
 }
 
+// DataByteLength returns the total byte length of the digits actually stored.
+// Unlike TotalByteLength, it excludes the missing part of a partial last block.
+func (s ResultSet) DataByteLength() int64 {
+	if len(s) == 0 {
+		return 0
+	}
+	last := s.TotalDigits() - s.BlockSize()*int64(len(s)-1)
+	if last <= 0 || last >= s.BlockSize() {
+		return s.TotalByteLength()
+	}
+	dpw := int64(s.DigitsPerWord())
+	return s.BlockByteLength()*int64(len(s)-1) + (last+dpw-1)/dpw*ycd.WordSize
+}
+
 // DigitsPerWord returns the number of digits per word.
 func (s ResultSet) DigitsPerWord() int {
 	if len(s) == 0 {
@@ -126,6 +154,9 @@ func (s ResultSet) FirstDigit() byte {
 
 // newRangeReader returns a io.ReadCloser for section [off, off+length) in the resultset.
 func newRangeReader(ctx context.Context, set ResultSet, bucket obj.Bucket, off, length int64) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if off >= set.TotalByteLength() {
 		return nil, io.EOF
 	}
@@ -138,5 +169,82 @@ func newRangeReader(ctx context.Context, set ResultSet, bucket obj.Bucket, off,
 		length = blockByteLen - blockOff
 	}
 
-	return obj.NewRangeReader(ctx, blockOff+int64(set[block].FirstDigitOffset), length)
+	rd, err := obj.NewRangeReader(ctx, blockOff+int64(set[block].FirstDigitOffset), length)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return newContextReader(ctx, rd), nil
+}
+
+// contextReader aborts reads when ctx is done.
+// Readers of backends abort an in-flight Read themselves when the context
+// of NewRangeReader is done; contextReader reports the context error
+// instead of whatever error they return, and closes the underlying reader
+// once ctx is done. io.ReadCloser doesn't allow Close during a Read, so
+// the close waits for an in-flight Read to return.
+type contextReader struct {
+	ctx  context.Context
+	done chan struct{}
+
+	mu     sync.Mutex
+	rd     io.ReadCloser
+	closed bool
+}
+
+func newContextReader(ctx context.Context, rd io.ReadCloser) io.ReadCloser {
+	if ctx.Done() == nil {
+		// ctx is never canceled.
+		return rd
+	}
+	r := &contextReader{
+		ctx:  ctx,
+		rd:   rd,
+		done: make(chan struct{}),
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.close()
+		case <-r.done:
+		}
+	}()
+	return r
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if r.closed {
+		return 0, fs.ErrClosed
+	}
+	n, err := r.rd.Read(p)
+	if err != nil && r.ctx.Err() != nil {
+		return n, r.ctx.Err()
+	}
+	return n, err
+}
+
+func (r *contextReader) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	return r.rd.Close()
+}
+
+func (r *contextReader) Close() error {
+	select {
+	case <-r.done:
+	default:
+		close(r.done)
+	}
+	return r.close()
 }
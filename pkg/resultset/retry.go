@@ -0,0 +1,269 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resultset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+)
+
+// RetryPolicy configures retries of range requests.
+// The zero value is replaced with DefaultRetryPolicy().
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts for a request,
+	// including the first one. Reopening a broken response and reading
+	// it draw from the same attempts, which are renewed whenever
+	// a response makes progress. 1 disables retries.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum delay between retries.
+	MaxBackoff time.Duration
+	// Multiplier is the factor the delay grows by after each retry.
+	Multiplier float64
+	// Jitter randomizes each delay by up to ±Jitter of it (0 to 1).
+	Jitter float64
+	// Retryable reports whether err is worth retrying. IsRetryable if nil.
+	Retryable func(err error) bool
+}
+
+// DefaultRetryPolicy returns the retry policy used unless configured.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// orDefault returns DefaultRetryPolicy() if p is the zero value.
+func (p RetryPolicy) orDefault() RetryPolicy {
+	if p.MaxAttempts == 0 {
+		return DefaultRetryPolicy()
+	}
+	return p
+}
+
+// backoff returns the delay before the retry-th retry (starting at 1).
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(retry-1))
+	if max := float64(p.MaxBackoff); max > 0 && d > max {
+		d = max
+	}
+	d *= 1 + p.Jitter*(2*rand.Float64()-1)
+	return time.Duration(d)
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+// IsRetryable reports whether err is a transient error: an unexpected end
+// of a response, a dropped or refused connection, a network timeout, or
+// an obj.TransientError. Context errors and io.EOF are never retryable.
+func IsRetryable(err error) bool {
+	switch {
+	case err == nil,
+		errors.Is(err, io.EOF),
+		errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.EPIPE):
+		return true
+	}
+	var transient *obj.TransientError
+	if errors.As(err, &transient) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// RetryStats counts retries made by a reader.
+type RetryStats struct {
+	// Retries is the number of range requests retried after they failed to open.
+	Retries int64
+	// Resumes is the number of range readers reopened at the offset reached
+	// after they failed in the middle of a response.
+	Resumes int64
+}
+
+// snapshot returns a copy of s safe to read while s is updated.
+func (s *RetryStats) snapshot() RetryStats {
+	return RetryStats{
+		Retries: atomic.LoadInt64(&s.Retries),
+		Resumes: atomic.LoadInt64(&s.Resumes),
+	}
+}
+
+// fetcher makes range requests for a result set with retries.
+type fetcher struct {
+	set    ResultSet
+	bucket obj.Bucket
+	retry  RetryPolicy
+	stats  *RetryStats
+}
+
+// wait sleeps before the retry-th retry of err and reports whether to retry.
+func (f *fetcher) wait(ctx context.Context, err error, retry int) bool {
+	if retry >= f.retry.MaxAttempts || !f.retry.retryable(err) {
+		return false
+	}
+	t := time.NewTimer(f.retry.backoff(retry))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// open returns a reader for section [off, off+length) of a block, or to
+// the end of the block if length is negative. The reader transparently
+// resumes at the byte offset reached if the response breaks with a
+// retryable error.
+func (f *fetcher) open(ctx context.Context, off, length int64) (io.ReadCloser, error) {
+	end := off + length
+	if blockLen := f.set.BlockByteLength(); blockLen > 0 {
+		if blockEnd := (off/blockLen + 1) * blockLen; length < 0 || end > blockEnd {
+			end = blockEnd
+		}
+	}
+	r := &retryReader{
+		ctx: ctx,
+		f:   f,
+		off: off,
+		end: end,
+	}
+	if err := r.reopen(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// readOnce reads len(p) bytes at off with a single range request
+// within a block.
+func (f *fetcher) readOnce(ctx context.Context, p []byte, off int64) (int, error) {
+	reader, err := f.open(ctx, off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	return io.ReadFull(reader, p)
+}
+
+// retryReader is a range reader that reopens the range at the byte
+// offset reached when a read fails with a retryable error.
+type retryReader struct {
+	ctx context.Context
+	f   *fetcher
+	// off is the result set byte offset of the next byte.
+	off int64
+	// end is the result set byte offset the range ends at.
+	end int64
+	rd  io.ReadCloser
+	// attempts is the number of attempts that failed since the last
+	// progress. Reopening and reading share it so that a read makes
+	// at most MaxAttempts attempts in total.
+	attempts int
+}
+
+// reopen opens a range reader at r.off, retrying failures within
+// the attempts left.
+func (r *retryReader) reopen() error {
+	for {
+		rd, err := newRangeReader(r.ctx, r.f.set, r.f.bucket, r.off, r.end-r.off)
+		if err == nil {
+			r.rd = rd
+			return nil
+		}
+		r.attempts++
+		if !r.f.wait(r.ctx, err, r.attempts) {
+			return giveUp(err, r.attempts)
+		}
+		atomic.AddInt64(&r.f.stats.Retries, 1)
+	}
+}
+
+func (r *retryReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.rd.Read(p)
+		r.off += int64(n)
+		if n > 0 {
+			r.attempts = 0
+		}
+		if err == nil || err == io.EOF || !r.f.retry.retryable(err) {
+			return n, err
+		}
+		r.rd.Close()
+		// Resume immediately if the response made progress.
+		if n == 0 {
+			r.attempts++
+			if !r.f.wait(r.ctx, err, r.attempts) {
+				r.rd = io.NopCloser(errReader{err})
+				return 0, giveUp(err, r.attempts)
+			}
+		}
+		atomic.AddInt64(&r.f.stats.Resumes, 1)
+		if err := r.reopen(); err != nil {
+			r.rd = io.NopCloser(errReader{err})
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (r *retryReader) Close() error {
+	return r.rd.Close()
+}
+
+// giveUp returns err annotated with the number of attempts made.
+func giveUp(err error, attempts int) error {
+	if attempts > 1 {
+		return fmt.Errorf("giving up after %d attempts: %w", attempts, err)
+	}
+	return err
+}
+
+// errReader always fails with err.
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
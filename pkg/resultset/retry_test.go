@@ -0,0 +1,278 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resultset_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/mem"
+	"github.com/googlecloudplatform/pi-delivery/pkg/resultset"
+	"github.com/googlecloudplatform/pi-delivery/pkg/tests"
+)
+
+var testRetryPolicy = resultset.RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     2 * time.Millisecond,
+	Multiplier:     2,
+}
+
+// flakyBucket is an obj.Bucket that fails requests and responses
+// for the objects of a bucket.
+type flakyBucket struct {
+	obj.Bucket
+	// openErrs are returned by NewRangeReader before it succeeds.
+	// A nil error lets a call succeed.
+	openErrs []error
+	// breakAfter is the number of bytes each response returns before
+	// it fails with io.ErrUnexpectedEOF. Responses don't fail if 0.
+	breakAfter int
+	// failReads makes every response fail before returning any bytes.
+	failReads bool
+
+	mu sync.Mutex
+	// opens is the number of calls to NewRangeReader.
+	opens int
+	// offsets are the object offsets of successful requests.
+	offsets []int64
+}
+
+type flakyObject struct {
+	obj.Object
+	b *flakyBucket
+}
+
+// newFlakyBucket returns a flakyBucket with buf stored as the objects of set.
+func newFlakyBucket(set resultset.ResultSet, buf []byte) *flakyBucket {
+	bucket := mem.NewClient().MemBucket("pi")
+	tests.PutResultSet(bucket, set, buf)
+	return &flakyBucket{Bucket: bucket}
+}
+
+func (b *flakyBucket) Object(name string) obj.Object {
+	return &flakyObject{Object: b.Bucket.Object(name), b: b}
+}
+
+func (o *flakyObject) NewRangeReader(ctx context.Context, off, length int64) (io.ReadCloser, error) {
+	o.b.mu.Lock()
+	defer o.b.mu.Unlock()
+	o.b.opens++
+	if len(o.b.openErrs) > 0 {
+		err := o.b.openErrs[0]
+		o.b.openErrs = o.b.openErrs[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+	rd, err := o.Object.NewRangeReader(ctx, off, length)
+	if err != nil {
+		return nil, err
+	}
+	o.b.offsets = append(o.b.offsets, off)
+	if o.b.failReads {
+		return &brokenReader{rd: rd}, nil
+	}
+	if o.b.breakAfter > 0 {
+		return &brokenReader{rd: rd, n: o.b.breakAfter}, nil
+	}
+	return rd, nil
+}
+
+// brokenReader returns n bytes from rd and fails with io.ErrUnexpectedEOF.
+type brokenReader struct {
+	rd io.ReadCloser
+	n  int
+}
+
+func (r *brokenReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+	n, err := r.rd.Read(p)
+	r.n -= n
+	return n, err
+}
+
+func (r *brokenReader) Close() error {
+	return r.rd.Close()
+}
+
+func TestRetry_Resume(t *testing.T) {
+	t.Parallel()
+
+	set := newPrefetchTestSet(3, 100, 0)
+	testBuf := tests.GenTestByteSeq(3 * 56)
+	ctx := context.Background()
+
+	t.Run("Read", func(t *testing.T) {
+		t.Parallel()
+		bucket := newFlakyBucket(set, testBuf)
+		bucket.breakAfter = 20
+		rd := set.NewReaderWithOptions(ctx, bucket, resultset.ReaderOptions{Retry: testRetryPolicy})
+		defer rd.Close()
+		got, err := io.ReadAll(rd)
+		if err != nil {
+			t.Fatalf("ReadAll() failed: %v", err)
+		}
+		if diff := cmp.Diff(testBuf, got); diff != "" {
+			t.Errorf("ReadAll() = (-want, +got):\n%s", diff)
+		}
+		// Each 56-byte block is read in 3 responses from the offsets reached.
+		wantOffsets := []int64{201, 221, 241, 201, 221, 241, 201, 221, 241}
+		if diff := cmp.Diff(wantOffsets, bucket.offsets); diff != "" {
+			t.Errorf("requested offsets = (-want, +got):\n%s", diff)
+		}
+		if got := rd.RetryStats(); got.Resumes != 6 || got.Retries != 0 {
+			t.Errorf("RetryStats() = got %+v, want 6 resumes", got)
+		}
+	})
+
+	t.Run("ReadAt", func(t *testing.T) {
+		t.Parallel()
+		bucket := newFlakyBucket(set, testBuf)
+		bucket.breakAfter = 7
+		rd := set.NewReaderWithOptions(ctx, bucket, resultset.ReaderOptions{Retry: testRetryPolicy})
+		defer rd.Close()
+		got := make([]byte, 100)
+		if _, err := rd.ReadAt(got, 30); err != nil {
+			t.Fatalf("ReadAt() failed: %v", err)
+		}
+		if diff := cmp.Diff(testBuf[30:130], got); diff != "" {
+			t.Errorf("ReadAt() = (-want, +got):\n%s", diff)
+		}
+		if got := rd.RetryStats(); got.Resumes == 0 {
+			t.Errorf("RetryStats() = got %+v, want resumes", got)
+		}
+	})
+
+	t.Run("Prefetch", func(t *testing.T) {
+		t.Parallel()
+		bucket := newFlakyBucket(set, testBuf)
+		bucket.breakAfter = 5
+		rd := set.NewPrefetchReader(ctx, bucket, resultset.PrefetchOptions{
+			Depth:     2,
+			ChunkSize: 16,
+			Retry:     testRetryPolicy,
+		})
+		defer rd.Close()
+		got, err := io.ReadAll(rd)
+		if err != nil {
+			t.Fatalf("ReadAll() failed: %v", err)
+		}
+		if diff := cmp.Diff(testBuf, got); diff != "" {
+			t.Errorf("ReadAll() = (-want, +got):\n%s", diff)
+		}
+		if got := rd.RetryStats(); got.Resumes == 0 {
+			t.Errorf("RetryStats() = got %+v, want resumes", got)
+		}
+	})
+}
+
+func TestRetry_Open(t *testing.T) {
+	t.Parallel()
+
+	set := newPrefetchTestSet(3, 100, 0)
+	testBuf := tests.GenTestByteSeq(3 * 56)
+	errTransient := &obj.TransientError{Err: errors.New("503 Service Unavailable")}
+	errPermanent := errors.New("403 Forbidden")
+
+	testCases := []struct {
+		name        string
+		openErrs    []error
+		wantErr     error
+		wantRetries int64
+	}{
+		{"transient", []error{errTransient, errTransient}, nil, 2},
+		{"connection reset", []error{syscall.ECONNRESET}, nil, 1},
+		{"permanent", []error{errPermanent}, errPermanent, 0},
+		{"too many attempts", []error{errTransient, errTransient, errTransient, errTransient}, errTransient, 3},
+		{"canceled", []error{context.Canceled}, context.Canceled, 0},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			bucket := newFlakyBucket(set, testBuf)
+			bucket.openErrs = tc.openErrs
+			rd := set.NewReaderWithOptions(context.Background(), bucket, resultset.ReaderOptions{Retry: testRetryPolicy})
+			defer rd.Close()
+			got := make([]byte, 16)
+			_, err := rd.ReadAt(got, 0)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("ReadAt() error got %v, want %v", err, tc.wantErr)
+			}
+			if got := rd.RetryStats().Retries; got != tc.wantRetries {
+				t.Errorf("RetryStats().Retries = got %d, want %d", got, tc.wantRetries)
+			}
+		})
+	}
+}
+
+func TestRetry_AttemptBudget(t *testing.T) {
+	t.Parallel()
+
+	set := newPrefetchTestSet(3, 100, 0)
+	errTransient := &obj.TransientError{Err: errors.New("503 Service Unavailable")}
+	// Every response breaks and every resumption needs retries.
+	bucket := newFlakyBucket(set, tests.GenTestByteSeq(3*56))
+	bucket.openErrs = []error{nil, errTransient, errTransient, nil, errTransient, errTransient, nil}
+	bucket.failReads = true
+	rd := set.NewReaderWithOptions(context.Background(), bucket, resultset.ReaderOptions{Retry: testRetryPolicy})
+	defer rd.Close()
+	got := make([]byte, 16)
+	if _, err := rd.ReadAt(got, 0); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("ReadAt() error got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	// Reopening and reading share the 4 attempts.
+	if bucket.opens != testRetryPolicy.MaxAttempts {
+		t.Errorf("NewRangeReader() calls = got %d, want %d", bucket.opens, testRetryPolicy.MaxAttempts)
+	}
+}
+
+func TestRetry_IsRetryable(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{io.EOF, false},
+		{io.ErrUnexpectedEOF, true},
+		{fmt.Errorf("read: %w", io.ErrUnexpectedEOF), true},
+		{syscall.ECONNRESET, true},
+		{&obj.TransientError{Err: errors.New("500")}, true},
+		{context.Canceled, false},
+		{context.DeadlineExceeded, false},
+		{errors.New("404 Not Found"), false},
+	}
+	for _, tc := range testCases {
+		if got := resultset.IsRetryable(tc.err); got != tc.want {
+			t.Errorf("IsRetryable(%v) = got %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
@@ -17,11 +17,17 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"strconv"
 
+	"github.com/googlecloudplatform/pi-delivery/gen/index"
 	"github.com/googlecloudplatform/pi-delivery/pkg/cached"
 	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/coalesce"
 	"github.com/googlecloudplatform/pi-delivery/pkg/obj/gcs"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/hedge"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/instrumented"
 	"github.com/googlecloudplatform/pi-delivery/pkg/resultset"
 	"github.com/googlecloudplatform/pi-delivery/pkg/unpack"
 	"go.uber.org/zap"
@@ -29,63 +35,239 @@ import (
 
 var errInternal = errors.New("internal error")
 
+var (
+	ErrNoStorage        = errors.New("service: no storage client or bucket")
+	ErrUnknownResultSet = errors.New("service: unknown result set")
+)
+
+// Names of the default result sets.
+const (
+	Decimal     = "decimal"
+	Hexadecimal = "hexadecimal"
+)
+
+// Options configures a Service.
+type Options struct {
+	// Client is the storage client to read BucketName with.
+	// The Service takes ownership of it and closes it on Close.
+	Client obj.Client
+	// BucketName is the bucket of the result sets in Client.
+	BucketName string
+	// Bucket is read instead of Client if set. It isn't closed on Close.
+	Bucket obj.Bucket
+	// ResultSets are the result sets served by name.
+	// index.Decimal and index.Hexadecimal as Decimal and Hexadecimal if nil.
+	ResultSets map[string]resultset.ResultSet
+	// Cache is the cache of packed pages. cached.Default if nil.
+	Cache *cached.Cache
+	// WindowCache configures the cache of unpacked digit windows.
+	WindowCache WindowCacheOptions
+	// Retry is the retry policy for range requests.
+	// resultset.DefaultRetryPolicy() if zero.
+	Retry resultset.RetryPolicy
+	// Hedge and Coalesce configure hedging and coalescing of range requests.
+	Hedge    hedge.Options
+	Coalesce coalesce.Options
+	// DisableHedge and DisableCoalesce turn off hedging and coalescing.
+	DisableHedge    bool
+	DisableCoalesce bool
+	// Sink receives the measurements of storage requests.
+	// instrumented.OpenCensusSink if nil.
+	Sink instrumented.Sink
+}
+
 type Service struct {
 	storage obj.Client
 	bucket  obj.Bucket
+	sets    map[string]resultset.ResultSet
+	cache   *cached.Cache
+	retry   resultset.RetryPolicy
+	windows *windowCache
 }
 
+// NewService returns a new Service that reads bucketName in Cloud Storage.
+// It exits if the storage client can't be created; use NewServiceWithOptions
+// to handle the error.
 func NewService(ctx context.Context, logger *zap.SugaredLogger, bucketName string) *Service {
 	storageClient, err := gcs.NewClient(ctx)
 	if err != nil {
 		logger.Fatalw("Failed to create a new Storage client",
 			"error", err)
 	}
+	s, err := NewServiceWithClient(storageClient, bucketName)
+	if err != nil {
+		logger.Fatalw("Failed to create a new Service",
+			"error", err)
+	}
+	return s
+}
+
+// NewServiceWithClient returns a new Service that reads bucketName with client
+// with the default options of NewServiceWithOptions.
+// The Service takes ownership of client and closes it on Close.
+// It returns ErrNoStorage if client is nil.
+func NewServiceWithClient(client obj.Client, bucketName string) (*Service, error) {
+	return NewServiceWithOptions(Options{Client: client, BucketName: bucketName})
+}
+
+// NewServiceWithOptions returns a new Service configured with opts.
+// It returns ErrNoStorage if neither opts.Client nor opts.Bucket is set.
+// Requests made to storage are recorded to opts.Sink, OpenCensus by default;
+// register instrumented.Views to export them. Unless disabled in opts,
+// slow requests are hedged, and concurrent reads of the same range are
+// coalesced into one request, whose cost is attributed to the Get that started it.
+// Unpacked digits are cached in windows of opts.WindowCache.WindowSize digits.
+func NewServiceWithOptions(opts Options) (*Service, error) {
+	client := opts.Client
+	if opts.Bucket != nil {
+		client = bucketClient{opts.Bucket}
+	} else if client == nil {
+		return nil, ErrNoStorage
+	}
+	if opts.ResultSets == nil {
+		opts.ResultSets = map[string]resultset.ResultSet{
+			Decimal:     index.Decimal,
+			Hexadecimal: index.Hexadecimal,
+		}
+	}
+	if opts.Cache == nil {
+		opts.Cache = cached.Default
+	}
+	if opts.Sink == nil {
+		opts.Sink = instrumented.OpenCensusSink{}
+	}
+
+	client = instrumented.NewClient(client, instrumented.Options{Sink: opts.Sink})
+	if !opts.DisableHedge {
+		client = hedge.NewClient(client, opts.Hedge)
+	}
+	if !opts.DisableCoalesce {
+		client = coalesce.NewClient(client, opts.Coalesce)
+	}
 	return &Service{
-		storage: storageClient,
-		bucket:  storageClient.Bucket(bucketName),
+		storage: client,
+		bucket:  client.Bucket(opts.BucketName),
+		sets:    opts.ResultSets,
+		cache:   opts.Cache,
+		retry:   opts.Retry,
+		windows: newWindowCache(opts.WindowCache),
+	}, nil
+}
+
+// bucketClient is a client of a single bucket that isn't closed.
+type bucketClient struct {
+	bucket obj.Bucket
+}
+
+func (c bucketClient) Bucket(string) obj.Bucket {
+	return c.bucket
+}
+
+func (c bucketClient) Close() error {
+	return nil
+}
+
+// ResultSet returns the result set named name.
+func (s *Service) ResultSet(name string) (resultset.ResultSet, error) {
+	set, ok := s.sets[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownResultSet, name)
 	}
+	return set, nil
 }
 
 // Get returns n bytes of pi starting at start.
 // The first digit (position 0) is 3 before the decimal point.
 func (s *Service) Get(ctx context.Context, logger *zap.SugaredLogger, set resultset.ResultSet, start, n int64) ([]byte, error) {
-	logger = logger.With("start", start, "n", n)
+	return s.GetBase(ctx, logger, set, set.Radix(), start, n)
+}
+
+// GetBase returns n digits of pi in base starting at start.
+// If ctx is done before the read completes, GetBase returns ctx.Err().
+// base must be the radix of set, or one of 2, 4, 8 and 32 for a hexadecimal set.
+// The first digits are the integer part (3, or 11 in base 2) before the decimal point.
+// GetBase logs the storage requests it made with their cost.
+func (s *Service) GetBase(ctx context.Context, logger *zap.SugaredLogger, set resultset.ResultSet, base int, start, n int64) ([]byte, error) {
+	logger = logger.With("start", start, "n", n, "base", base)
 
 	if n == 0 {
 		return nil, nil
 	}
 
+	ctx, cost := instrumented.NewCostContext(ctx)
+	// Runs after rr.Close so that the readers closed there are counted.
+	defer func() {
+		logger.Infow("GetBase done",
+			"cost", cost.Snapshot(),
+		)
+	}()
+
+	// The reader is created only if some digits aren't cached.
+	var rr *resultset.Reader
+	defer func() {
+		if rr != nil {
+			rr.Close()
+		}
+	}()
+	open := func() (io.ReaderAt, error) {
+		rr = set.NewReaderWithOptions(ctx, s.bucket, resultset.ReaderOptions{Retry: s.retry})
+		cr := cached.NewCachedReaderWithCache(ctx, rr, s.cache)
+		if base == set.Radix() {
+			return unpack.NewReader(ctx, cr), nil
+		}
+		br, err := unpack.NewBaseReader(ctx, cr, base)
+		if err != nil {
+			logger.Errorw("NewBaseReader failed",
+				"error", err,
+			)
+			return nil, errInternal
+		}
+		return br, nil
+	}
+
 	// pb.Range.Start counts at the first digit before the decimal point (3)
 	// while the rest of the program treats the first digit after the decimal point (1)
 	// as the zeroth digit. We need a special handling here.
-	zero := start == 0
+	integer := []byte(strconv.FormatInt(int64(set.FirstDigit()-'0'), base))
 	unpacked := make([]byte, n)
 
-	off := 0
-	if zero {
-		n--
-		unpacked[0] = set.FirstDigit()
-		off = 1
+	off := int64(0)
+	if start < int64(len(integer)) {
+		off = int64(copy(unpacked, integer[start:]))
+		start = 0
 	} else {
-		start--
+		start -= int64(len(integer))
 	}
 
-	rr := set.NewReader(ctx, s.bucket)
-	defer rr.Close()
-	reader := unpack.NewReader(ctx, cached.NewCachedReader(ctx, rr))
-	read, err := reader.ReadAt(unpacked[off:], start)
-
+	read, err := s.windows.readAt(set, base, unpacked[off:], start, open)
+	if errors.Is(err, errInternal) {
+		return nil, err
+	}
 	if err != nil && !errors.Is(err, io.EOF) {
-		logger.Errorw("ReadAt returned error",
+		return nil, s.readError(ctx, logger, err)
+	}
+
+	return unpacked[:off+int64(read)], nil
+}
+
+// readError logs err from reading digits and returns the error to return.
+// Cancellation and deadlines are surfaced as they are, not as internal errors.
+func (s *Service) readError(ctx context.Context, logger *zap.SugaredLogger, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil && errors.Is(err, ctxErr) {
+		logger.Warnw("Read aborted",
 			"error", err,
 		)
-		return nil, errInternal
-	}
-	if zero {
-		read++
+		return ctxErr
 	}
+	logger.Errorw("Read returned error",
+		"error", err,
+	)
+	return errInternal
+}
 
-	return unpacked[:read], nil
+// WindowCacheStats returns the statistics of the cache of unpacked digits.
+func (s *Service) WindowCacheStats() WindowCacheStats {
+	return s.windows.Stats()
 }
 
 // Close closes connections used by the service.
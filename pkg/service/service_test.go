@@ -16,11 +16,22 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math/rand"
+	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/googlecloudplatform/pi-delivery/gen/index"
+	"github.com/googlecloudplatform/pi-delivery/pkg/cached"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/fault"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/instrumented"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/mem"
+	"github.com/googlecloudplatform/pi-delivery/pkg/resultset"
+	"github.com/googlecloudplatform/pi-delivery/pkg/tests"
+	"github.com/googlecloudplatform/pi-delivery/pkg/unpack"
 	"go.uber.org/zap"
 )
 
@@ -68,3 +79,421 @@ func TestService_SimpleGet(t *testing.T) {
 		})
 	}
 }
+
+func TestService_GetBase(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	testCases := []struct {
+		base     int
+		start, n int64
+		want     string
+	}{
+		{2, 0, 1, "1"},
+		{2, 0, 10, "1100100100"},
+		{2, 1, 5, "10010"},
+		{2, 2, 8, "00100100"},
+		{4, 0, 10, "3021003331"},
+		{8, 0, 10, "3110375524"},
+		{8, 5, 5, "75524"},
+		{32, 0, 10, "34gvml245k"},
+	}
+
+	client := mem.NewClient()
+	hexadecimal, err := tests.NewResultSet(client.MemBucket("pi"), tests.PiHexadecimal, 16, 64)
+	if err != nil {
+		t.Fatalf("NewResultSet() failed: %v", err)
+	}
+	serv, err := NewServiceWithClient(client, "pi")
+	if err != nil {
+		t.Fatalf("NewServiceWithClient() failed: %v", err)
+	}
+	// The subtests run after this function returns.
+	t.Cleanup(func() { serv.Close() })
+	s := zap.NewNop().Sugar()
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(fmt.Sprintf("Base %d Start %d N %d", tc.base, tc.start, tc.n), func(t *testing.T) {
+			t.Parallel()
+			got, err := serv.GetBase(ctx, s, hexadecimal, tc.base, tc.start, tc.n)
+			if err != nil {
+				t.Errorf("GetBase() failed: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, string(got)); diff != "" {
+				t.Errorf("GetBase() = (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestService_Mem(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	client := mem.NewClient()
+	bucket := client.MemBucket("pi")
+	// Small blocks so that reads span objects.
+	decimal, err := tests.NewResultSet(bucket, tests.PiDecimal, 10, 100)
+	if err != nil {
+		t.Fatalf("NewResultSet() failed: %v", err)
+	}
+	hexadecimal, err := tests.NewResultSet(bucket, tests.PiHexadecimal, 16, 64)
+	if err != nil {
+		t.Fatalf("NewResultSet() failed: %v", err)
+	}
+	serv, err := NewServiceWithClient(client, "pi")
+	if err != nil {
+		t.Fatalf("NewServiceWithClient() failed: %v", err)
+	}
+	defer serv.Close()
+
+	testCases := []struct {
+		base     int
+		start, n int64
+		want     string
+	}{
+		{10, 0, 1, "3"},
+		{10, 0, 50, "31415926535897932384626433832795028841971693993751"},
+		{10, 95, 10, "1706798214"},
+		{10, 995, 10, "201989"},
+		{10, 1001, 10, ""},
+		{16, 0, 50, "3243f6a8885a308d313198a2e03707344a4093822299f31d00"},
+		{16, 60, 10, "e6c8945282"},
+		{2, 0, 10, "1100100100"},
+		{8, 5, 5, "75524"},
+		{32, 0, 10, "34gvml245k"},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(fmt.Sprintf("Base %d Start %d N %d", tc.base, tc.start, tc.n), func(t *testing.T) {
+			t.Parallel()
+			set := decimal
+			if tc.base != 10 {
+				set = hexadecimal
+			}
+			got, err := serv.GetBase(ctx, zap.NewNop().Sugar(), set, tc.base, tc.start, tc.n)
+			if err != nil {
+				t.Errorf("GetBase() failed: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, string(got)); diff != "" {
+				t.Errorf("GetBase() = (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestService_Drop(t *testing.T) {
+	t.Parallel()
+
+	client := mem.NewClient()
+	decimal, err := tests.NewResultSet(client.MemBucket("pi"), tests.PiDecimal, 10, 1000)
+	if err != nil {
+		t.Fatalf("NewResultSet() failed: %v", err)
+	}
+	// The first response breaks, like a dropped connection.
+	fc := fault.NewClient(client, fault.Options{Rules: []fault.Rule{{Kind: fault.Drop, Bytes: 40, Count: 1}}})
+	serv, err := NewServiceWithClient(fc, "pi")
+	if err != nil {
+		t.Fatalf("NewServiceWithClient() failed: %v", err)
+	}
+	defer serv.Close()
+
+	got, err := serv.Get(context.Background(), zap.NewNop().Sugar(), decimal, 0, 500)
+	if err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	if diff := cmp.Diff(tests.PiDecimal[:1]+tests.PiDecimal[2:501], string(got)); diff != "" {
+		t.Errorf("Get() = (-want, +got):\n%s", diff)
+	}
+}
+
+func TestService_WindowCache(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	client := mem.NewClient()
+	bucket := client.MemBucket("pi")
+	decimal, err := tests.NewResultSet(bucket, tests.PiDecimal, 10, 100)
+	if err != nil {
+		t.Fatalf("NewResultSet() failed: %v", err)
+	}
+	serv, err := NewServiceWithClient(client, "pi")
+	if err != nil {
+		t.Fatalf("NewServiceWithClient() failed: %v", err)
+	}
+	defer serv.Close()
+	serv.windows = newWindowCache(WindowCacheOptions{WindowSize: 16, Budget: 16 * 8})
+
+	get := func(start, n int64) string {
+		t.Helper()
+		got, err := serv.GetBase(ctx, zap.NewNop().Sugar(), decimal, 10, start, n)
+		if err != nil {
+			t.Fatalf("GetBase(%d, %d) failed: %v", start, n, err)
+		}
+		return string(got)
+	}
+
+	for _, tc := range []struct {
+		start, n int64
+	}{
+		{0, 50},
+		{10, 20},
+		{30, 40},
+		{990, 20},
+		{995, 10},
+	} {
+		want := tests.PiDecimal[:1] + tests.PiDecimal[2:]
+		end := tc.start + tc.n
+		if end > int64(len(want)) {
+			end = int64(len(want))
+		}
+		if diff := cmp.Diff(want[tc.start:end], get(tc.start, tc.n)); diff != "" {
+			t.Errorf("GetBase(%d, %d) = (-want, +got):\n%s", tc.start, tc.n, diff)
+		}
+	}
+	// Windows 0-3 and 4 are unpacked, then 61 and the short window 62.
+	// Window 63 past the end is looked up too.
+	want := WindowCacheStats{Hits: 6, Misses: 8, Windows: 7, Bytes: 16*6 + 8}
+	if diff := cmp.Diff(want, serv.WindowCacheStats()); diff != "" {
+		t.Errorf("WindowCacheStats() = (-want, +got):\n%s", diff)
+	}
+
+	// Windows are evicted over the budget.
+	if diff := cmp.Diff(tests.PiDecimal[202:266], get(201, 64)); diff != "" {
+		t.Errorf("GetBase(201, 64) = (-want, +got):\n%s", diff)
+	}
+	want = WindowCacheStats{Hits: 6, Misses: 13, Evictions: 4, Windows: 8, Bytes: 16*7 + 8}
+	if diff := cmp.Diff(want, serv.WindowCacheStats()); diff != "" {
+		t.Errorf("WindowCacheStats() = (-want, +got):\n%s", diff)
+	}
+}
+
+func TestService_WindowCachePut(t *testing.T) {
+	t.Parallel()
+
+	c := newWindowCache(WindowCacheOptions{WindowSize: 16})
+	key := windowKey{base: 10}
+	if _, ok := c.get(key); ok {
+		t.Fatalf("get() = found, want missing")
+	}
+	first := []byte("0123456789012345")
+	c.put(key, first)
+	// Unpacked concurrently by another request.
+	c.put(key, []byte("5432109876543210"))
+	got, ok := c.get(key)
+	if !ok {
+		t.Fatalf("get() = missing, want found")
+	}
+	if diff := cmp.Diff(first, got); diff != "" {
+		t.Errorf("get() = (-want, +got):\n%s", diff)
+	}
+	want := WindowCacheStats{Hits: 1, Misses: 1, Windows: 1, Bytes: 16}
+	if diff := cmp.Diff(want, c.Stats()); diff != "" {
+		t.Errorf("Stats() = (-want, +got):\n%s", diff)
+	}
+}
+
+func TestService_Options(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	if _, err := NewServiceWithOptions(Options{}); !errors.Is(err, ErrNoStorage) {
+		t.Errorf("NewServiceWithOptions() error got %v, want %v", err, ErrNoStorage)
+	}
+	if _, err := NewServiceWithClient(nil, "pi"); !errors.Is(err, ErrNoStorage) {
+		t.Errorf("NewServiceWithClient(nil) error got %v, want %v", err, ErrNoStorage)
+	}
+
+	bucket := mem.NewClient().MemBucket("pi")
+	decimal, err := tests.NewResultSet(bucket, tests.PiDecimal, 10, 100)
+	if err != nil {
+		t.Fatalf("NewResultSet() failed: %v", err)
+	}
+	cache := cached.NewCache(cached.Options{PageSize: 64})
+	serv, err := NewServiceWithOptions(Options{
+		Bucket:      bucket,
+		ResultSets:  map[string]resultset.ResultSet{"pi": decimal},
+		Cache:       cache,
+		WindowCache: WindowCacheOptions{Budget: -1},
+		Retry:       resultset.RetryPolicy{MaxAttempts: 1},
+		Sink:        nopSink{},
+		// Requests go to the bucket through the instrumented client only.
+		DisableHedge:    true,
+		DisableCoalesce: true,
+	})
+	if err != nil {
+		t.Fatalf("NewServiceWithOptions() failed: %v", err)
+	}
+	defer serv.Close()
+	if _, ok := serv.storage.(*instrumented.Client); !ok {
+		t.Errorf("storage = got %T, want %T", serv.storage, &instrumented.Client{})
+	}
+
+	if _, err := serv.ResultSet(Decimal); !errors.Is(err, ErrUnknownResultSet) {
+		t.Errorf("ResultSet(%q) error got %v, want %v", Decimal, err, ErrUnknownResultSet)
+	}
+	set, err := serv.ResultSet("pi")
+	if err != nil {
+		t.Fatalf("ResultSet() failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		got, err := serv.Get(ctx, zap.NewNop().Sugar(), set, 0, 50)
+		if err != nil {
+			t.Fatalf("Get() failed: %v", err)
+		}
+		if diff := cmp.Diff("31415926535897932384626433832795028841971693993751", string(got)); diff != "" {
+			t.Errorf("Get() = (-want, +got):\n%s", diff)
+		}
+	}
+	if got := cache.Stats(); got.Misses != 1 || got.Hits != 1 {
+		t.Errorf("cache.Stats() = got %+v, want 1 miss and 1 hit", got)
+	}
+	if diff := cmp.Diff(WindowCacheStats{}, serv.WindowCacheStats()); diff != "" {
+		t.Errorf("WindowCacheStats() = (-want, +got):\n%s", diff)
+	}
+}
+
+type nopSink struct{}
+
+func (nopSink) Record(context.Context, *instrumented.Measurement) {}
+
+func TestService_WriteBase(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	client := mem.NewClient()
+	bucket := client.MemBucket("pi")
+	decimal, err := tests.NewResultSet(bucket, tests.PiDecimal, 10, 100)
+	if err != nil {
+		t.Fatalf("NewResultSet() failed: %v", err)
+	}
+	hexadecimal, err := tests.NewResultSet(bucket, tests.PiHexadecimal, 16, 64)
+	if err != nil {
+		t.Fatalf("NewResultSet() failed: %v", err)
+	}
+	serv, err := NewServiceWithClient(client, "pi")
+	if err != nil {
+		t.Fatalf("NewServiceWithClient() failed: %v", err)
+	}
+	defer serv.Close()
+
+	testCases := []struct {
+		base     int
+		start, n int64
+	}{
+		{10, 0, 1},
+		{10, 0, 2000},
+		{10, 1, 999},
+		{10, 95, 10},
+		{10, 1001, 10},
+		{16, 0, 2000},
+		{16, 60, 10},
+		{2, 0, 10000},
+		{2, 1, 100},
+		{8, 5, 500},
+		{32, 0, 1000},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(fmt.Sprintf("Base %d Start %d N %d", tc.base, tc.start, tc.n), func(t *testing.T) {
+			t.Parallel()
+			set := decimal
+			if tc.base != 10 {
+				set = hexadecimal
+			}
+			want, err := serv.GetBase(ctx, zap.NewNop().Sugar(), set, tc.base, tc.start, tc.n)
+			if err != nil {
+				t.Fatalf("GetBase() failed: %v", err)
+			}
+			var sb strings.Builder
+			n, err := serv.WriteBase(ctx, zap.NewNop().Sugar(), &sb, set, tc.base, tc.start, tc.n)
+			if err != nil {
+				t.Errorf("WriteBase() failed: %v", err)
+			}
+			if n != int64(len(want)) {
+				t.Errorf("WriteBase() = got %d, want %d", n, len(want))
+			}
+			if diff := cmp.Diff(string(want), sb.String()); diff != "" {
+				t.Errorf("WriteBase() = (-want, +got):\n%s", diff)
+			}
+		})
+	}
+
+	// The client goes away after the first write.
+	cctx, cancel := context.WithCancel(ctx)
+	w := writerFunc(func(p []byte) (int, error) {
+		cancel()
+		return 0, errors.New("broken pipe")
+	})
+	if _, err := serv.WriteBase(cctx, zap.NewNop().Sugar(), w, decimal, 10, 10, 100); !errors.Is(err, context.Canceled) {
+		t.Errorf("WriteBase() error got %v, want %v", err, context.Canceled)
+	}
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// rangeReadSink counts range reads from storage.
+type rangeReadSink struct {
+	reads int64
+}
+
+func (s *rangeReadSink) Record(ctx context.Context, m *instrumented.Measurement) {
+	if m.Op == instrumented.OpRangeRead {
+		atomic.AddInt64(&s.reads, 1)
+	}
+}
+
+func TestService_WriteBaseRequests(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	// 100,000 hexadecimal digits in a file of about 50 KB, which
+	// WriteBase reads ahead in a single chunk.
+	rnd := rand.New(rand.NewSource(1))
+	var text strings.Builder
+	text.WriteString("3.")
+	for i := 0; i < 100000; i++ {
+		text.WriteByte("0123456789abcdef"[rnd.Intn(16)])
+	}
+	client := mem.NewClient()
+	hexadecimal, err := tests.NewResultSet(client.MemBucket("pi"), text.String(), 16, 100000)
+	if err != nil {
+		t.Fatalf("NewResultSet() failed: %v", err)
+	}
+	sink := &rangeReadSink{}
+	serv, err := NewServiceWithOptions(Options{
+		Client:      client,
+		BucketName:  "pi",
+		ResultSets:  map[string]resultset.ResultSet{"pi": hexadecimal},
+		WindowCache: WindowCacheOptions{Budget: -1},
+		Sink:        sink,
+	})
+	if err != nil {
+		t.Fatalf("NewServiceWithOptions() failed: %v", err)
+	}
+	defer serv.Close()
+
+	for _, base := range []int{2, 4, 8, 32} {
+		n := unpack.BaseDigits(100000, base)
+		want, err := serv.GetBase(ctx, zap.NewNop().Sugar(), hexadecimal, base, 0, n)
+		if err != nil {
+			t.Fatalf("GetBase() failed: %v", err)
+		}
+		before := atomic.LoadInt64(&sink.reads)
+		var sb strings.Builder
+		if _, err := serv.WriteBase(ctx, zap.NewNop().Sugar(), &sb, hexadecimal, base, 0, n); err != nil {
+			t.Fatalf("WriteBase(base %d) failed: %v", base, err)
+		}
+		if diff := cmp.Diff(string(want), sb.String()); diff != "" {
+			t.Errorf("WriteBase(base %d) = (-want, +got):\n%s", base, diff)
+		}
+		if got := atomic.LoadInt64(&sink.reads) - before; got != 1 {
+			t.Errorf("WriteBase(base %d): range reads = got %d, want 1", base, got)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/instrumented"
+	"github.com/googlecloudplatform/pi-delivery/pkg/resultset"
+	"github.com/googlecloudplatform/pi-delivery/pkg/unpack"
+	"go.uber.org/zap"
+)
+
+const (
+	// Read-ahead of WriteBase. Smaller than the resultset defaults
+	// as many streams can be served at once.
+	streamPrefetchDepth = 4
+	streamChunkSize     = 1024 * 1024
+	// streamBufferSize is the number of digits WriteBase writes at once.
+	streamBufferSize = 64 * 1024
+)
+
+// WriteBase writes n digits of pi in base starting at start to w, the same
+// digits GetBase returns, and returns the number of digits written.
+// Unlike GetBase, it reads packed digits sequentially ahead and writes
+// them as they're unpacked in writes of up to 64 KiB, so n can be large.
+// Reads are paced by the writes to w. If ctx is done, WriteBase stops
+// and returns ctx.Err().
+func (s *Service) WriteBase(ctx context.Context, logger *zap.SugaredLogger, w io.Writer, set resultset.ResultSet, base int, start, n int64) (int64, error) {
+	logger = logger.With("start", start, "n", n, "base", base)
+
+	if n == 0 {
+		return 0, nil
+	}
+
+	ctx, cost := instrumented.NewCostContext(ctx)
+	written := int64(0)
+	// Runs after pr.Close so that the readers closed there are counted.
+	defer func() {
+		logger.Infow("WriteBase done",
+			"written", written,
+			"cost", cost.Snapshot(),
+		)
+	}()
+
+	integer := []byte(strconv.FormatInt(int64(set.FirstDigit()-'0'), base))
+	if start < int64(len(integer)) {
+		digits := integer[start:]
+		if int64(len(digits)) > n {
+			digits = digits[:n]
+		}
+		m, err := w.Write(digits)
+		written += int64(m)
+		if err != nil {
+			return written, err
+		}
+		start = 0
+	} else {
+		start -= int64(len(integer))
+	}
+	if written == n {
+		return written, nil
+	}
+
+	pr := set.NewPrefetchReader(ctx, s.bucket, resultset.PrefetchOptions{
+		Depth:     streamPrefetchDepth,
+		ChunkSize: streamChunkSize,
+		Retry:     s.retry,
+	})
+	defer pr.Close()
+	var rd io.ReadSeeker
+	if base == set.Radix() {
+		rd = unpack.NewReader(ctx, pr)
+	} else {
+		br, err := unpack.NewBaseReader(ctx, pr, base)
+		if err != nil {
+			logger.Errorw("NewBaseReader failed",
+				"error", err,
+			)
+			return written, errInternal
+		}
+		rd = br
+	}
+	if _, err := rd.Seek(start, io.SeekStart); err != nil {
+		logger.Errorw("Seek failed",
+			"error", err,
+		)
+		return written, errInternal
+	}
+
+	buf := make([]byte, streamBufferSize)
+	for written < n {
+		p := buf
+		if remaining := n - written; remaining < int64(len(p)) {
+			p = p[:remaining]
+		}
+		m, err := rd.Read(p)
+		if m > 0 {
+			wn, werr := w.Write(p[:m])
+			written += int64(wn)
+			if werr != nil {
+				// Usually the client went away.
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return written, ctxErr
+				}
+				return written, werr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return written, s.readError(ctx, logger, err)
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,228 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"container/list"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/googlecloudplatform/pi-delivery/pkg/resultset"
+	"github.com/googlecloudplatform/pi-delivery/pkg/ycd"
+)
+
+const (
+	// DefaultWindowSize is the default number of digits in a cached window.
+	DefaultWindowSize = 4096
+	// DefaultWindowBudget is the default memory budget of cached windows in bytes.
+	DefaultWindowBudget = 32 * 1024 * 1024
+)
+
+// WindowCacheOptions configures the cache of unpacked digit windows.
+type WindowCacheOptions struct {
+	// WindowSize is the number of digits in an aligned window.
+	// DefaultWindowSize if 0.
+	WindowSize int64
+	// Budget is the maximum number of bytes of cached windows.
+	// DefaultWindowBudget if 0, and no windows are cached if negative.
+	// It's at least WindowSize.
+	Budget int64
+}
+
+// WindowCacheStats are statistics of the cache of unpacked digit windows.
+type WindowCacheStats struct {
+	// Hits is the number of windows copied from the cache.
+	Hits int64
+	// Misses is the number of windows looked up and not found.
+	Misses int64
+	// Evictions is the number of windows evicted.
+	Evictions int64
+	// Windows is the number of windows in the cache.
+	Windows int
+	// Bytes is the number of bytes in the cache.
+	Bytes int64
+}
+
+// windowCache is an LRU cache of unpacked digits in aligned windows
+// of result sets in a base.
+type windowCache struct {
+	opts WindowCacheOptions
+
+	mu      sync.Mutex
+	windows map[windowKey]*list.Element
+	lru     *list.List
+	bytes   int64
+	stats   WindowCacheStats
+}
+
+// windowKey identifies a window. Result sets are identified by their first file
+// as in the cached package.
+type windowKey struct {
+	set   *ycd.YCDFile
+	base  int
+	index int64
+}
+
+type window struct {
+	key  windowKey
+	data []byte
+}
+
+// newWindowCache returns a new cache, or nil if opts.Budget is negative.
+func newWindowCache(opts WindowCacheOptions) *windowCache {
+	if opts.Budget < 0 {
+		return nil
+	}
+	if opts.WindowSize <= 0 {
+		opts.WindowSize = DefaultWindowSize
+	}
+	if opts.Budget == 0 {
+		opts.Budget = DefaultWindowBudget
+	}
+	if opts.Budget < opts.WindowSize {
+		opts.Budget = opts.WindowSize
+	}
+	return &windowCache{
+		opts:    opts,
+		windows: make(map[windowKey]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (c *windowCache) Stats() WindowCacheStats {
+	if c == nil {
+		return WindowCacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Windows = c.lru.Len()
+	s.Bytes = c.bytes
+	return s
+}
+
+func (c *windowCache) get(key windowKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.windows[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*window).data, true
+}
+
+// missing reports whether the window of key isn't cached, and counts a miss if so.
+func (c *windowCache) missing(key windowKey) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.windows[key]; ok {
+		return false
+	}
+	c.stats.Misses++
+	return true
+}
+
+func (c *windowCache) put(key windowKey, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.windows[key]; ok {
+		// Unpacked concurrently by another request.
+		return
+	}
+	c.windows[key] = c.lru.PushFront(&window{key: key, data: data})
+	c.bytes += int64(len(data))
+	for c.bytes > c.opts.Budget {
+		victim := c.lru.Remove(c.lru.Back()).(*window)
+		delete(c.windows, victim.key)
+		c.bytes -= int64(len(victim.data))
+		c.stats.Evictions++
+	}
+}
+
+// readAt reads len(p) digits of set in base starting at off like io.ReaderAt.
+// Cached windows are copied, and consecutive missing windows are unpacked
+// at once with the reader returned by open, which is called at most once.
+func (c *windowCache) readAt(set resultset.ResultSet, base int, p []byte, off int64, open func() (io.ReaderAt, error)) (int, error) {
+	if c == nil || len(set) == 0 {
+		rd, err := open()
+		if err != nil {
+			return 0, err
+		}
+		return rd.ReadAt(p, off)
+	}
+
+	var rd io.ReaderAt
+	ws := c.opts.WindowSize
+	end := off + int64(len(p))
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		key := windowKey{set: set[0], base: base, index: pos / ws}
+		if data, ok := c.get(key); ok {
+			lo := pos - key.index*ws
+			if lo >= int64(len(data)) {
+				return n, io.EOF
+			}
+			n += copy(p[n:], data[lo:])
+			if int64(len(data)) < ws && n < len(p) {
+				return n, io.EOF
+			}
+			continue
+		}
+
+		last := key.index + 1
+		for last*ws < end && c.missing(windowKey{set: set[0], base: base, index: last}) {
+			last++
+		}
+		if rd == nil {
+			var err error
+			if rd, err = open(); err != nil {
+				return n, err
+			}
+		}
+		buf := make([]byte, (last-key.index)*ws)
+		read, err := rd.ReadAt(buf, key.index*ws)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return n, err
+		}
+		atEnd := read < len(buf)
+		for i := key.index; i < last; i++ {
+			lo := (i - key.index) * ws
+			hi := lo + ws
+			if hi > int64(read) {
+				if !errors.Is(err, io.EOF) || lo > int64(read) {
+					break
+				}
+				// The short window at the end of the digits.
+				hi = int64(read)
+			}
+			c.put(windowKey{set: set[0], base: base, index: i}, buf[lo:hi:hi])
+		}
+
+		lo := pos - key.index*ws
+		if lo >= int64(read) {
+			return n, io.EOF
+		}
+		n += copy(p[n:], buf[lo:read])
+		if atEnd && n < len(p) {
+			return n, io.EOF
+		}
+	}
+	return n, nil
+}
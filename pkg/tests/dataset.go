@@ -0,0 +1,61 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/mem"
+	"github.com/googlecloudplatform/pi-delivery/pkg/resultset"
+	"github.com/googlecloudplatform/pi-delivery/pkg/ycd"
+)
+
+// NewResultSet encodes digit text in the y-cruncher text format ("3.14159...")
+// to ycd files of blockSize digits in radix, stores them in bucket, and returns
+// the result set of the files with real headers and packed digits.
+// The objects are named like y-cruncher output,
+// e.g. "Pi - Dec - Chudnovsky/Pi - Dec - Chudnovsky - 0.ycd".
+func NewResultSet(bucket *mem.Bucket, text string, radix int, blockSize int64) (resultset.ResultSet, error) {
+	prefix := "Pi - Dec - Chudnovsky"
+	if radix == 16 {
+		prefix = "Pi - Hex - Chudnovsky"
+	}
+	enc, err := ycd.NewEncoder(radix, blockSize, func(blockID int64) (string, io.WriteCloser, error) {
+		name := prefix + "/" + ycd.FileName(prefix, blockID)
+		return name, bucket.Create(name), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	files, err := enc.Encode(strings.NewReader(text))
+	if err != nil {
+		return nil, err
+	}
+	return resultset.ResultSet(files), nil
+}
+
+// ReadRange reads the section [off, off+length) of o, or to the end of o
+// if length is negative, with a single range reader.
+func ReadRange(ctx context.Context, o obj.Object, off, length int64) ([]byte, error) {
+	rd, err := o.NewRangeReader(ctx, off, length)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+	return io.ReadAll(rd)
+}
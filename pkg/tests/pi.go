@@ -0,0 +1,43 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+// PiDecimal is the first 1000 decimal digits of pi after the radix point
+// in the y-cruncher text format.
+const PiDecimal = "3." +
+	"1415926535897932384626433832795028841971693993751058209749445923078164062862089986280348253421170679" +
+	"8214808651328230664709384460955058223172535940812848111745028410270193852110555964462294895493038196" +
+	"4428810975665933446128475648233786783165271201909145648566923460348610454326648213393607260249141273" +
+	"7245870066063155881748815209209628292540917153643678925903600113305305488204665213841469519415116094" +
+	"3305727036575959195309218611738193261179310511854807446237996274956735188575272489122793818301194912" +
+	"9833673362440656643086021394946395224737190702179860943702770539217176293176752384674818467669405132" +
+	"0005681271452635608277857713427577896091736371787214684409012249534301465495853710507922796892589235" +
+	"4201995611212902196086403441815981362977477130996051870721134999999837297804995105973173281609631859" +
+	"5024459455346908302642522308253344685035261931188171010003137838752886587533208381420617177669147303" +
+	"5982534904287554687311595628638823537875937519577818577805321712268066130019278766111959092164201989"
+
+// PiHexadecimal is the first 1000 hexadecimal digits of pi after the radix point
+// in the y-cruncher text format.
+const PiHexadecimal = "3." +
+	"243f6a8885a308d313198a2e03707344a4093822299f31d0082efa98ec4e6c89452821e638d01377be5466cf34e90c6cc0ac" +
+	"29b7c97c50dd3f84d5b5b54709179216d5d98979fb1bd1310ba698dfb5ac2ffd72dbd01adfb7b8e1afed6a267e96ba7c9045" +
+	"f12c7f9924a19947b3916cf70801f2e2858efc16636920d871574e69a458fea3f4933d7e0d95748f728eb658718bcd588215" +
+	"4aee7b54a41dc25a59b59c30d5392af26013c5d1b023286085f0ca417918b8db38ef8e79dcb0603a180e6c9e0e8bb01e8a3e" +
+	"d71577c1bd314b2778af2fda55605c60e65525f3aa55ab945748986263e8144055ca396a2aab10b6b4cc5c341141e8cea154" +
+	"86af7c72e993b3ee1411636fbc2a2ba9c55d741831f6ce5c3e169b87931eafd6ba336c24cf5c7a325381289586773b8f4898" +
+	"6b4bb9afc4bfe81b6628219361d809ccfb21a991487cac605dec8032ef845d5de98575b1dc262302eb651b8823893e81d396" +
+	"acc50f6d6ff383f442392e0b4482a484200469c8f04a9e1f9b5e21c66842f6e96c9a670c9c61abd388f06a51a0d2d8542f68" +
+	"960fa728ab5133a36eef0b6c137a3be4ba3bf0507efb2a98a1f1651d39af017666ca593e82430e888cee8619456f9fb47d84" +
+	"a5c33b8b5ebee06f75d885c12073401a449f56c16aa64ed3aa62363f77061bfedf72429b023d37d0d724d00a1248db0fead3"
@@ -18,9 +18,11 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"reflect"
 
 	"github.com/golang/mock/gomock"
 	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/mem"
 	mock_obj "github.com/googlecloudplatform/pi-delivery/pkg/obj/mocks"
 	"github.com/googlecloudplatform/pi-delivery/pkg/resultset"
 )
@@ -62,6 +64,26 @@ func NewTestReader(set resultset.ResultSet, idx int, buf []byte, off, length int
 	), nil
 }
 
+// PutResultSet stores buf in bucket as the objects of set, laid out as
+// NewTestReader reads them: the bytes of each block follow zeros in place
+// of the header.
+func PutResultSet(bucket *mem.Bucket, set resultset.ResultSet, buf []byte) {
+	blockLen := set.BlockByteLength()
+	for i, f := range set {
+		start := int64(i) * blockLen
+		end := start + blockLen
+		if start > int64(len(buf)) {
+			start = int64(len(buf))
+		}
+		if end > int64(len(buf)) {
+			end = int64(len(buf))
+		}
+		data := make([]byte, int64(f.FirstDigitOffset)+end-start)
+		copy(data[f.FirstDigitOffset:], buf[start:end])
+		bucket.Put(f.Name, data)
+	}
+}
+
 // GenTestByteSeq returns a byte slice for tests with length n.
 func GenTestByteSeq(n int) []byte {
 	buf := make([]byte, n)
@@ -71,6 +93,9 @@ func GenTestByteSeq(n int) []byte {
 	return buf
 }
 
+// contextType matches any context.Context including derived contexts.
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
 // NewMockBucket returns a mock bucket for set that returns testBuf data.
 func NewMockBucket(ctx context.Context, ctrl *gomock.Controller, set resultset.ResultSet, testBuf []byte) obj.Bucket {
 	bucket := mock_obj.NewMockBucket(ctrl)
@@ -78,7 +103,7 @@ func NewMockBucket(ctx context.Context, ctrl *gomock.Controller, set resultset.R
 		i := i
 		obj := mock_obj.NewMockObject(ctrl)
 		obj.EXPECT().NewRangeReader(
-			gomock.AssignableToTypeOf(ctx),
+			gomock.AssignableToTypeOf(contextType),
 			gomock.Any(),
 			gomock.Any(),
 		).DoAndReturn(
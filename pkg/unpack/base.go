@@ -0,0 +1,238 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unpack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrUnknownBase error = errors.New("Unpack: unknown base")
+
+// baseDigits has digit characters up to base 32.
+const baseDigits = "0123456789abcdefghijklmnopqrstuv"
+
+// BitsPerDigit returns the number of bits in a digit of base,
+// or 0 if base isn't one of 2, 4, 8, 16 and 32.
+func BitsPerDigit(base int) int {
+	switch base {
+	case 2:
+		return 1
+	case 4:
+		return 2
+	case 8:
+		return 3
+	case 16:
+		return 4
+	case 32:
+		return 5
+	}
+	return 0
+}
+
+// BaseDigits returns the number of complete digits in base
+// that hexDigits hexadecimal digits have.
+func BaseDigits(hexDigits int64, base int) int64 {
+	bits := BitsPerDigit(base)
+	if bits == 0 {
+		return 0
+	}
+	return hexDigits * 4 / int64(bits)
+}
+
+// BaseReader reads digits in base 2, 4, 8, 16 or 32 by regrouping bits
+// of hexadecimal digits.
+// The k-th digit of base 2^b consists of bits [k*b, k*b+b) of the hexadecimal
+// digits after the decimal point, so digits in base 8 and 32 may span
+// two hexadecimal digits.
+// As in UnpackReader, the first offset is the first digit after the decimal point.
+type BaseReader struct {
+	rd          *UnpackReader
+	base        int
+	bits        int
+	off         int64
+	totalDigits int64
+	// hexNext is the offset of the next hexadecimal digit Read reads from rd.
+	hexNext int64
+	// last is the last hexadecimal digit Read read, at lastOff. Digits in
+	// base 8 and 32 may share it with the digits of the next Read.
+	last    byte
+	lastOff int64
+	// hex is reused by Read.
+	hex []byte
+}
+
+var _ io.ReadSeeker = new(BaseReader)
+var _ io.ReaderAt = new(BaseReader)
+
+// NewBaseReader returns a new BaseReader for UpstreamReader rd.
+// rd must read a hexadecimal result set.
+func NewBaseReader(ctx context.Context, rd UpstreamReader, base int) (*BaseReader, error) {
+	if radix := rd.ResultSet().Radix(); radix != 16 {
+		return nil, fmt.Errorf("%w: upstream radix = %d, want 16", ErrUnknownRadix, radix)
+	}
+	bits := BitsPerDigit(base)
+	if bits == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownBase, base)
+	}
+	urd := NewReaderWithFormat(ctx, rd, FormatRaw)
+	return &BaseReader{
+		rd:          urd,
+		base:        base,
+		bits:        bits,
+		totalDigits: BaseDigits(urd.totalDigits, base),
+		lastOff:     -1,
+	}, nil
+}
+
+// TotalDigits returns the number of digits available in base.
+func (r *BaseReader) TotalDigits() int64 {
+	return r.totalDigits
+}
+
+// ReadAt reads len(p) digits starting at the off-th digit in base.
+func (r *BaseReader) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if off >= r.totalDigits {
+		return 0, io.EOF
+	}
+
+	var err error
+	digits := int64(len(p))
+	if digits > r.totalDigits-off {
+		digits = r.totalDigits - off
+		err = io.EOF
+	}
+
+	// Hexadecimal digits [hexStart, hexEnd) cover bits [startBit, endBit).
+	startBit := off * int64(r.bits)
+	endBit := (off + digits) * int64(r.bits)
+	hexStart := startBit / 4
+	hexEnd := (endBit + 3) / 4
+	hex := make([]byte, hexEnd-hexStart)
+	n, rerr := r.rd.ReadAt(hex, hexStart)
+	if rerr != nil && !errors.Is(rerr, io.EOF) {
+		return 0, rerr
+	}
+	if n < len(hex) {
+		// The upstream ended earlier than expected.
+		digits = (int64(n)*4 - startBit%4) / int64(r.bits)
+		err = io.EOF
+	}
+
+	written := regroupBits(p[:digits], hex[:n], int(startBit%4), r.bits)
+	return written, err
+}
+
+// Read reads len(p) digits starting at the current reader offset.
+// Unlike ReadAt, it reads hexadecimal digits with Read of the upstream
+// reader, so a sequential one such as resultset.PrefetchReader reads ahead.
+func (r *BaseReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if r.off >= r.totalDigits {
+		return 0, io.EOF
+	}
+
+	var err error
+	digits := int64(len(p))
+	if digits > r.totalDigits-r.off {
+		digits = r.totalDigits - r.off
+		err = io.EOF
+	}
+
+	startBit := r.off * int64(r.bits)
+	endBit := (r.off + digits) * int64(r.bits)
+	hexStart := startBit / 4
+	hexEnd := (endBit + 3) / 4
+	if int64(cap(r.hex)) < hexEnd-hexStart {
+		r.hex = make([]byte, hexEnd-hexStart)
+	}
+	hex := r.hex[:hexEnd-hexStart]
+	n := 0
+	if r.lastOff == hexStart {
+		hex[0] = r.last
+		n = 1
+	}
+	if pos := hexStart + int64(n); r.hexNext != pos {
+		if _, err := r.rd.Seek(pos, io.SeekStart); err != nil {
+			return 0, err
+		}
+		r.hexNext = pos
+	}
+	read, rerr := io.ReadFull(r.rd, hex[n:])
+	r.hexNext += int64(read)
+	n += read
+	if rerr != nil && rerr != io.EOF && rerr != io.ErrUnexpectedEOF {
+		return 0, rerr
+	}
+	if n < len(hex) {
+		// The upstream ended earlier than expected.
+		digits = (int64(n)*4 - startBit%4) / int64(r.bits)
+		if digits < 0 {
+			digits = 0
+		}
+		err = io.EOF
+	}
+	if n > 0 {
+		r.last, r.lastOff = hex[n-1], hexStart+int64(n-1)
+	}
+
+	written := regroupBits(p[:digits], hex[:n], int(startBit%4), r.bits)
+	r.off += int64(written)
+	return written, err
+}
+
+// Seek updates the offset for the next Read.
+func (r *BaseReader) Seek(offset int64, whence int) (int64, error) {
+	off := r.off
+	switch whence {
+	case io.SeekStart:
+		off = offset
+	case io.SeekCurrent:
+		off += offset
+	case io.SeekEnd:
+		off = r.totalDigits + offset
+	}
+	if off < 0 {
+		return r.off, errors.New("Seek: negative offset")
+	}
+	r.off = off
+	return off, nil
+}
+
+// regroupBits writes digits of bits bits each to dst from hex digit values in hex,
+// skipping the first skip bits. Returns the number of digits written.
+func regroupBits(dst, hex []byte, skip, bits int) int {
+	mask := uint64(1)<<bits - 1
+	var acc uint64
+	nbits := -skip
+	written := 0
+	for _, h := range hex {
+		acc = acc<<4 | uint64(h&0xf)
+		nbits += 4
+		for nbits >= bits && written < len(dst) {
+			nbits -= bits
+			dst[written] = baseDigits[acc>>nbits&mask]
+			written++
+		}
+	}
+	return written
+}
@@ -0,0 +1,190 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unpack
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/googlecloudplatform/pi-delivery/pkg/tests"
+)
+
+// toBase converts hexadecimal digit characters to base through a bit string.
+func toBase(hex []byte, base int) []byte {
+	var bits strings.Builder
+	for _, c := range hex {
+		v, _ := strconv.ParseUint(string(c), 16, 8)
+		fmt.Fprintf(&bits, "%04b", v)
+	}
+	b := BitsPerDigit(base)
+	s := bits.String()
+	out := make([]byte, 0, len(s)/b)
+	for i := 0; i+b <= len(s); i += b {
+		v, _ := strconv.ParseUint(s[i:i+b], 2, 8)
+		out = append(out, strconv.FormatUint(v, base)...)
+	}
+	return out
+}
+
+func TestBaseReader_Pi(t *testing.T) {
+	t.Parallel()
+
+	// First hexadecimal digits of pi after the decimal point.
+	hex := []byte("243f6a8885a308d313198a2e03707344a4093822299f31d008")
+	testCases := []struct {
+		base int
+		want string
+	}{
+		{2, "00100100001111110110101010001000100001011010001100001000110100110001001100011001100010100010111000000011011100000111001101000100101001000000100100111000001000100010100110011111001100011101000000001000"},
+		{4, "0210033312222020201122030020310301030121202202320003130013031010221000210320020202212133030131000020"},
+		{8, "110375524210264302151423063050560067016321122011160210514763072002"},
+		{32, "4gvml245kc4d64oph8n06s3j8ii0ie1256fj3k08"},
+	}
+
+	set := newPackTestSet(16, 30, int64(len(hex)))
+	packed, err := Pack(hex, 16, 30)
+	if err != nil {
+		t.Fatalf("Pack() failed: %v", err)
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(fmt.Sprintf("Base %d", tc.base), func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			rr := set.NewReader(ctx, tests.NewMockBucket(ctx, gomock.NewController(t), set, packed))
+			defer rr.Close()
+			rd, err := NewBaseReader(ctx, rr, tc.base)
+			if err != nil {
+				t.Fatalf("NewBaseReader() failed: %v", err)
+			}
+			got := make([]byte, len(tc.want))
+			if _, err := rd.ReadAt(got, 0); err != nil && err != io.EOF {
+				t.Fatalf("ReadAt() failed: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, string(got)); diff != "" {
+				t.Errorf("ReadAt() = (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBaseReader_Random(t *testing.T) {
+	t.Parallel()
+
+	for _, base := range []int{2, 4, 8, 16, 32} {
+		base := base
+		t.Run(fmt.Sprintf("Base %d", base), func(t *testing.T) {
+			t.Parallel()
+			rnd := rand.New(rand.NewSource(int64(base)))
+			for i := 0; i < 30; i++ {
+				blockSize := int64(rnd.Intn(100) + 1)
+				// At least 2 hexadecimal digits so there is a digit in base 32.
+				total := rnd.Intn(300) + 2
+				hex := genDigits(rnd, 16, total)
+				want := toBase(hex, base)
+				packed, err := Pack(hex, 16, blockSize)
+				if err != nil {
+					t.Fatalf("Pack() failed: %v", err)
+				}
+
+				set := newPackTestSet(16, blockSize, int64(total))
+				ctx := context.Background()
+				rr := set.NewReader(ctx, tests.NewMockBucket(ctx, gomock.NewController(t), set, packed))
+				rd, err := NewBaseReader(ctx, rr, base)
+				if err != nil {
+					t.Fatalf("NewBaseReader() failed: %v", err)
+				}
+				if got := rd.TotalDigits(); got != int64(len(want)) {
+					t.Fatalf("TotalDigits() = got %d, want %d", got, len(want))
+				}
+
+				got, err := io.ReadAll(rd)
+				if err != nil {
+					t.Fatalf("ReadAll() failed: %v", err)
+				}
+				if diff := cmp.Diff(want, got); diff != "" {
+					t.Fatalf("ReadAll(total = %d) = (-want, +got):\n%s", total, diff)
+				}
+
+				// Reads of random lengths after a seek, which may share
+				// hexadecimal digits in base 8 and 32.
+				off := rnd.Intn(len(want))
+				if _, err := rd.Seek(int64(off), io.SeekStart); err != nil {
+					t.Fatalf("Seek() failed: %v", err)
+				}
+				var seq []byte
+				for {
+					buf := make([]byte, rnd.Intn(20)+1)
+					n, err := rd.Read(buf)
+					seq = append(seq, buf[:n]...)
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						t.Fatalf("Read() failed: %v", err)
+					}
+				}
+				if diff := cmp.Diff(want[off:], seq); diff != "" {
+					t.Fatalf("Read(off = %d) = (-want, +got):\n%s", off, diff)
+				}
+
+				for j := 0; j < 10; j++ {
+					off := rnd.Intn(len(want))
+					n := rnd.Intn(len(want)-off+10) + 1
+					got := make([]byte, n)
+					read, err := rd.ReadAt(got, int64(off))
+					wantN := n
+					if off+n > len(want) {
+						wantN = len(want) - off
+						if err != io.EOF {
+							t.Errorf("ReadAt(off = %d, n = %d) = got error %v, want EOF", off, n, err)
+						}
+					} else if err != nil && err != io.EOF {
+						t.Fatalf("ReadAt() failed: %v", err)
+					}
+					if read != wantN {
+						t.Fatalf("ReadAt(off = %d, n = %d): n = got %d, want %d", off, n, read, wantN)
+					}
+					if diff := cmp.Diff(want[off:off+wantN], got[:read]); diff != "" {
+						t.Fatalf("ReadAt(off = %d, n = %d) = (-want, +got):\n%s", off, n, diff)
+					}
+				}
+				rr.Close()
+			}
+		})
+	}
+}
+
+func TestBaseReader_Errors(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dec := newPackTestSet(10, 30, 100)
+	if _, err := NewBaseReader(ctx, dec.NewReader(ctx, nil), 2); !cmp.Equal(err, ErrUnknownRadix, cmpopts.EquateErrors()) {
+		t.Errorf("NewBaseReader(radix 10) = got error %v, want %v", err, ErrUnknownRadix)
+	}
+	hex := newPackTestSet(16, 30, 100)
+	if _, err := NewBaseReader(ctx, hex.NewReader(ctx, nil), 10); !cmp.Equal(err, ErrUnknownBase, cmpopts.EquateErrors()) {
+		t.Errorf("NewBaseReader(base 10) = got error %v, want %v", err, ErrUnknownBase)
+	}
+}
@@ -0,0 +1,346 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unpack
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+	"github.com/googlecloudplatform/pi-delivery/pkg/resultset"
+	"github.com/googlecloudplatform/pi-delivery/pkg/ycd"
+)
+
+// ProblemKind is the type of a problem found by Check.
+type ProblemKind string
+
+const (
+	// ProblemInvalidWord is a word that can't be unpacked (ErrInvalidWord).
+	ProblemInvalidWord ProblemKind = "invalid_word"
+	// ProblemTruncated is an object shorter than the digits it should have.
+	ProblemTruncated ProblemKind = "truncated"
+	// ProblemBlockLength is an object longer than YCDFile.BlockByteLength.
+	ProblemBlockLength ProblemKind = "block_length"
+	// ProblemHeader is a header inconsistent with the object or its neighbours.
+	ProblemHeader ProblemKind = "header"
+	// ProblemMissingBlock is a gap or a duplicate in BlockID.
+	ProblemMissingBlock ProblemKind = "missing_block"
+)
+
+// Problem is an integrity problem in a result set.
+type Problem struct {
+	Kind ProblemKind `json:"kind"`
+	// Object is the name of the object with the problem.
+	Object string `json:"object"`
+	// Offset is the byte offset in the object.
+	Offset int64 `json:"offset"`
+	// Digit is the digit position in the result set (0 is the first digit
+	// after the radix point) or -1 if the problem isn't about digits.
+	Digit int64 `json:"digit"`
+	// Message describes the problem.
+	Message string `json:"message"`
+}
+
+// BlockReport is the result of checking a block.
+type BlockReport struct {
+	BlockID  int64     `json:"blockId"`
+	Object   string    `json:"object"`
+	Bytes    int64     `json:"bytes"`
+	Problems []Problem `json:"problems"`
+}
+
+// CheckOptions are options for Check.
+type CheckOptions struct {
+	// Parallelism is the number of blocks checked concurrently.
+	// Defaults to 1.
+	Parallelism int
+
+	// Skip reports whether the block should be skipped,
+	// e.g. because it was checked by a previous run.
+	Skip func(blockID int64) bool
+
+	// OnBlock is called after each block is checked.
+	// Calls are serialized but not in the order of BlockID.
+	// Check stops if OnBlock returns an error.
+	OnBlock func(*BlockReport) error
+}
+
+// checkBufferSize is the size of the read buffer for each block.
+const checkBufferSize = 1 * 1024 * 1024
+
+// maxHeaderLength is the maximum header length Check reads from each object.
+const maxHeaderLength = 4096
+
+// CheckHeaders checks the headers in set against their neighbours
+// and reports gaps in BlockID. It doesn't read the objects.
+func CheckHeaders(set resultset.ResultSet) []Problem {
+	problems := []Problem{}
+	if len(set) == 0 {
+		return problems
+	}
+	headerProblem := func(f *ycd.YCDFile, format string, a ...interface{}) {
+		problems = append(problems, Problem{
+			Kind:    ProblemHeader,
+			Object:  f.Name,
+			Digit:   -1,
+			Message: fmt.Sprintf(format, a...),
+		})
+	}
+
+	expected := int64(0)
+	for i, f := range set {
+		if f.Header.BlockID > expected {
+			problems = append(problems, Problem{
+				Kind:    ProblemMissingBlock,
+				Object:  f.Name,
+				Digit:   expected * f.Header.BlockSize,
+				Message: fmt.Sprintf("blocks %d to %d are missing", expected, f.Header.BlockID-1),
+			})
+		} else if f.Header.BlockID < expected {
+			problems = append(problems, Problem{
+				Kind:    ProblemMissingBlock,
+				Object:  f.Name,
+				Digit:   -1,
+				Message: fmt.Sprintf("duplicate or unsorted block %d", f.Header.BlockID),
+			})
+		}
+		expected = f.Header.BlockID + 1
+
+		if f.Header.TotalDigits != 0 && i != len(set)-1 {
+			headerProblem(f, "TotalDigits %d is set in a block that isn't the last", f.Header.TotalDigits)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := set[i-1]
+		if f.Header.Radix != prev.Header.Radix {
+			headerProblem(f, "radix %d differs from %d in %s", f.Header.Radix, prev.Header.Radix, prev.Name)
+		}
+		if f.Header.BlockSize != prev.Header.BlockSize {
+			headerProblem(f, "block size %d differs from %d in %s", f.Header.BlockSize, prev.Header.BlockSize, prev.Name)
+		}
+		if f.Header.FirstDigits != prev.Header.FirstDigits {
+			headerProblem(f, "first digits %q differ from %q in %s", f.Header.FirstDigits, prev.Header.FirstDigits, prev.Name)
+		}
+	}
+	return problems
+}
+
+// Check walks every word of every block in set and reports integrity problems.
+// Blocks are checked in parallel according to opts.
+// It returns problems found by CheckHeaders and in all the blocks checked.
+// The returned error is an I/O error or an error returned by opts.OnBlock.
+func Check(ctx context.Context, set resultset.ResultSet, bucket obj.Bucket, opts CheckOptions) ([]Problem, error) {
+	problems := CheckHeaders(set)
+	parallelism := opts.Parallelism
+	if parallelism <= 0 {
+		parallelism = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	var firstErr error
+	setErr := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	blocks := make(chan *ycd.YCDFile)
+	var wg sync.WaitGroup
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range blocks {
+				report, err := checkBlock(ctx, set, bucket, f)
+				mu.Lock()
+				if err != nil {
+					setErr(fmt.Errorf("checking %s: %w", f.Name, err))
+				} else {
+					problems = append(problems, report.Problems...)
+					if opts.OnBlock != nil {
+						if err := opts.OnBlock(report); err != nil {
+							setErr(err)
+						}
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+loop:
+	for _, f := range set {
+		if opts.Skip != nil && opts.Skip(f.Header.BlockID) {
+			continue
+		}
+		select {
+		case blocks <- f:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(blocks)
+	wg.Wait()
+
+	if firstErr != nil {
+		return problems, firstErr
+	}
+	return problems, ctx.Err()
+}
+
+// expectedByteLength returns the number of packed bytes f should have.
+func expectedByteLength(set resultset.ResultSet, f *ycd.YCDFile) int64 {
+	digits := f.Header.BlockSize
+	if total := set.TotalDigits(); total < (f.Header.BlockID+1)*f.Header.BlockSize {
+		digits = total - f.Header.BlockID*f.Header.BlockSize
+	}
+	if digits < 0 {
+		digits = 0
+	}
+	dpw := int64(ycd.DigitsPerWord(f.Header.Radix))
+	return (digits + dpw - 1) / dpw * WordSize
+}
+
+// maxWord returns the maximum valid word value for radix.
+func maxWord(radix int) uint64 {
+	dpw := ycd.DigitsPerWord(radix)
+	if radix == 16 {
+		return math.MaxUint64
+	}
+	max := uint64(1)
+	for i := 0; i < dpw; i++ {
+		max *= uint64(radix)
+	}
+	return max - 1
+}
+
+func checkBlock(ctx context.Context, set resultset.ResultSet, bucket obj.Bucket, f *ycd.YCDFile) (*BlockReport, error) {
+	report := &BlockReport{
+		BlockID:  f.Header.BlockID,
+		Object:   f.Name,
+		Problems: []Problem{},
+	}
+	object := bucket.Object(f.Name)
+
+	hr, err := object.NewRangeReader(ctx, 0, maxHeaderLength)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := ycd.ParseWithOptions(hr, ycd.ParseOptions{
+		MaxHeaderLength:  maxHeaderLength,
+		VersionCheck:     ycd.VersionLenient,
+		AllowUnknownKeys: true,
+	})
+	hr.Close()
+	if err != nil {
+		report.Problems = append(report.Problems, Problem{
+			Kind:    ProblemHeader,
+			Object:  f.Name,
+			Digit:   -1,
+			Message: fmt.Sprintf("failed to parse the header: %v", err),
+		})
+		return report, nil
+	}
+	if parsed.FirstDigitOffset != f.FirstDigitOffset ||
+		parsed.Header.Radix != f.Header.Radix ||
+		parsed.Header.BlockSize != f.Header.BlockSize ||
+		parsed.Header.BlockID != f.Header.BlockID ||
+		parsed.Header.TotalDigits != f.Header.TotalDigits {
+		report.Problems = append(report.Problems, Problem{
+			Kind:   ProblemHeader,
+			Object: f.Name,
+			Digit:  -1,
+			Message: fmt.Sprintf("header in the object (radix %d, block size %d, block id %d, total digits %d, first digit offset %d) doesn't match the index",
+				parsed.Header.Radix, parsed.Header.BlockSize, parsed.Header.BlockID, parsed.Header.TotalDigits, parsed.FirstDigitOffset),
+		})
+		return report, nil
+	}
+
+	expected := expectedByteLength(set, f)
+	limit := f.BlockByteLength()
+	if expected > limit {
+		limit = expected
+	}
+	// Read one more byte to detect objects longer than the block.
+	rd, err := object.NewRangeReader(ctx, int64(f.FirstDigitOffset), limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	max := maxWord(f.Header.Radix)
+	dpw := int64(ycd.DigitsPerWord(f.Header.Radix))
+	br := bufio.NewReaderSize(rd, checkBufferSize)
+	word := make([]byte, WordSize)
+	off := int64(0)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		n, err := io.ReadFull(br, word)
+		if off+int64(n) > limit {
+			report.Problems = append(report.Problems, Problem{
+				Kind:    ProblemBlockLength,
+				Object:  f.Name,
+				Offset:  int64(f.FirstDigitOffset) + limit,
+				Digit:   -1,
+				Message: fmt.Sprintf("object has more than %d bytes of packed digits", limit),
+			})
+			off = limit
+			break
+		}
+		off += int64(n)
+		if n == WordSize {
+			if v := binary.LittleEndian.Uint64(word); v > max {
+				report.Problems = append(report.Problems, Problem{
+					Kind:    ProblemInvalidWord,
+					Object:  f.Name,
+					Offset:  int64(f.FirstDigitOffset) + off - WordSize,
+					Digit:   f.Header.BlockID*f.Header.BlockSize + (off/WordSize-1)*dpw,
+					Message: fmt.Sprintf("word %d is larger than %d", v, max),
+				})
+			}
+			continue
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	report.Bytes = off
+
+	if off < expected || off%WordSize != 0 {
+		report.Problems = append(report.Problems, Problem{
+			Kind:    ProblemTruncated,
+			Object:  f.Name,
+			Offset:  int64(f.FirstDigitOffset) + off,
+			Digit:   f.Header.BlockID*f.Header.BlockSize + off/WordSize*dpw,
+			Message: fmt.Sprintf("object has %d bytes of packed digits, want %d", off, expected),
+		})
+	}
+	return report, nil
+}
@@ -0,0 +1,190 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unpack
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/mem"
+	"github.com/googlecloudplatform/pi-delivery/pkg/resultset"
+	"github.com/googlecloudplatform/pi-delivery/pkg/ycd"
+)
+
+// checkFiles are the contents of the files of a result set by name.
+type checkFiles map[string][]byte
+
+// bucket returns a bucket storing the files.
+func (files checkFiles) bucket() obj.Bucket {
+	b := mem.NewClient().MemBucket("pi")
+	for name, data := range files {
+		b.Put(name, data)
+	}
+	return b
+}
+
+type bufferCloser struct {
+	*bytes.Buffer
+}
+
+func (bufferCloser) Close() error { return nil }
+
+// checkFirstDigitOffset is the header length of the blocks except the last one.
+const checkFirstDigitOffset = 190
+
+// newCheckSet encodes 100 decimal digits in blocks of 30 digits.
+func newCheckSet(t *testing.T) (resultset.ResultSet, checkFiles) {
+	t.Helper()
+	bufs := map[string]*bytes.Buffer{}
+	enc, err := ycd.NewEncoder(10, 30, func(blockID int64) (string, io.WriteCloser, error) {
+		name := ycd.FileName("Pi - Dec - Chudnovsky", blockID)
+		bufs[name] = new(bytes.Buffer)
+		return name, bufferCloser{bufs[name]}, nil
+	})
+	if err != nil {
+		t.Fatalf("NewEncoder() failed: %v", err)
+	}
+	files, err := enc.Encode(strings.NewReader("3." + string(wantUnpackedDec[:100])))
+	if err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	data := checkFiles{}
+	for name, buf := range bufs {
+		data[name] = buf.Bytes()
+	}
+	return resultset.ResultSet(files), data
+}
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		modify func(resultset.ResultSet, checkFiles) resultset.ResultSet
+		want   []Problem
+	}{
+		{
+			name: "no problems",
+			modify: func(set resultset.ResultSet, _ checkFiles) resultset.ResultSet {
+				return set
+			},
+			want: []Problem{},
+		},
+		{
+			name: "invalid word",
+			modify: func(set resultset.ResultSet, b checkFiles) resultset.ResultSet {
+				f := set[1]
+				copy(b[f.Name][f.FirstDigitOffset+WordSize:], bytes.Repeat([]byte{0xff}, WordSize))
+				return set
+			},
+			want: []Problem{
+				{Kind: ProblemInvalidWord, Object: "Pi - Dec - Chudnovsky - 1.ycd", Offset: checkFirstDigitOffset + 8, Digit: 30 + 19},
+			},
+		},
+		{
+			name: "truncated",
+			modify: func(set resultset.ResultSet, b checkFiles) resultset.ResultSet {
+				f := set[2]
+				b[f.Name] = b[f.Name][:f.FirstDigitOffset+WordSize+3]
+				return set
+			},
+			want: []Problem{
+				{Kind: ProblemTruncated, Object: "Pi - Dec - Chudnovsky - 2.ycd", Offset: checkFirstDigitOffset + 11, Digit: 60 + 19},
+			},
+		},
+		{
+			name: "too long",
+			modify: func(set resultset.ResultSet, b checkFiles) resultset.ResultSet {
+				f := set[0]
+				b[f.Name] = append(b[f.Name], 0)
+				return set
+			},
+			want: []Problem{
+				{Kind: ProblemBlockLength, Object: "Pi - Dec - Chudnovsky - 0.ycd", Offset: checkFirstDigitOffset + 16, Digit: -1},
+			},
+		},
+		{
+			name: "missing block",
+			modify: func(set resultset.ResultSet, _ checkFiles) resultset.ResultSet {
+				return append(set[:1:1], set[2:]...)
+			},
+			want: []Problem{
+				{Kind: ProblemMissingBlock, Object: "Pi - Dec - Chudnovsky - 2.ycd", Digit: 30},
+			},
+		},
+		{
+			name: "inconsistent header",
+			modify: func(set resultset.ResultSet, _ checkFiles) resultset.ResultSet {
+				h := *set[1].Header
+				h.FirstDigits = "3.1"
+				set[1] = &ycd.YCDFile{Header: &h, Name: set[1].Name, FirstDigitOffset: set[1].FirstDigitOffset}
+				return set
+			},
+			want: []Problem{
+				{Kind: ProblemHeader, Object: "Pi - Dec - Chudnovsky - 1.ycd", Digit: -1},
+				{Kind: ProblemHeader, Object: "Pi - Dec - Chudnovsky - 2.ycd", Digit: -1},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			set, files := newCheckSet(t)
+			set = tc.modify(set, files)
+
+			got, err := Check(context.Background(), set, files.bucket(), CheckOptions{Parallelism: 2})
+			if err != nil {
+				t.Fatalf("Check() failed: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got,
+				cmpopts.IgnoreFields(Problem{}, "Message"),
+				cmpopts.SortSlices(func(a, b Problem) bool { return a.Object < b.Object }),
+			); diff != "" {
+				t.Errorf("Check() = (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCheck_Resume(t *testing.T) {
+	t.Parallel()
+
+	set, files := newCheckSet(t)
+	checked := map[int64]int64{}
+	_, err := Check(context.Background(), set, files.bucket(), CheckOptions{
+		Parallelism: 3,
+		Skip: func(blockID int64) bool {
+			return blockID == 1
+		},
+		OnBlock: func(r *BlockReport) error {
+			checked[r.BlockID] = r.Bytes
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Check() failed: %v", err)
+	}
+	want := map[int64]int64{0: 16, 2: 16, 3: 8}
+	if diff := cmp.Diff(want, checked); diff != "" {
+		t.Errorf("checked blocks = (-want, +got):\n%s", diff)
+	}
+}
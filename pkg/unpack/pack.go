@@ -0,0 +1,198 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/googlecloudplatform/pi-delivery/pkg/ycd"
+)
+
+var ErrInvalidDigit error = errors.New("Pack: invalid digit")
+var ErrInvalidBlockSize error = errors.New("Pack: invalid block size")
+
+// PackedLen returns a number of bytes to store n digits in packed words.
+// The last word is padded if n isn't aligned with words.
+func PackedLen(n int64, radix int) int64 {
+	dpw := int64(ycd.DigitsPerWord(radix))
+	return (n + dpw - 1) / dpw * WordSize
+}
+
+// packWord converts up to dpw digit characters in s to a word.
+// If s is shorter than dpw, the word is padded with zeros at the end
+// as y-cruncher does at the end of a block.
+func packWord(s []byte, radix, dpw int) (uint64, error) {
+	v := uint64(0)
+	for i, c := range s {
+		d, ok := ycd.DigitValue(c, radix)
+		if !ok {
+			return 0, fmt.Errorf("%w: %q at %d", ErrInvalidDigit, c, i)
+		}
+		v = v*uint64(radix) + uint64(d)
+	}
+	for i := len(s); i < dpw; i++ {
+		v *= uint64(radix)
+	}
+	return v, nil
+}
+
+// PackBlock converts digit characters in unpacked to little-endian words and
+// writes them to packed. It is the inverse of UnpackBlock with pre = 0.
+// The last word is padded with zeros if unpacked isn't aligned with words.
+// Returns the number of bytes written to packed.
+func PackBlock(packed, unpacked []byte, radix int) (int, error) {
+	if radix != 10 && radix != 16 {
+		return 0, ErrUnknownRadix
+	}
+	dpw := ycd.DigitsPerWord(radix)
+	packedLen := PackedLen(int64(len(unpacked)), radix)
+	if int64(len(packed)) < packedLen {
+		return 0, fmt.Errorf("%w: required = %v bytes, actual buffer = %v bytes",
+			ErrBufferTooSmall, packedLen, len(packed))
+	}
+
+	n := 0
+	for i := 0; i < len(unpacked); i += dpw {
+		end := i + dpw
+		if end > len(unpacked) {
+			end = len(unpacked)
+		}
+		v, err := packWord(unpacked[i:end], radix, dpw)
+		if err != nil {
+			return n, fmt.Errorf("digit offset %d: %w", i, err)
+		}
+		binary.LittleEndian.PutUint64(packed[n:], v)
+		n += WordSize
+	}
+	return n, nil
+}
+
+// Pack converts digits starting at the first digit of a result set
+// to packed words split in blocks of blockSize digits.
+// The last word of each block is padded as ToPackedOffsets expects,
+// so block i starts at byte offset i * (the block byte length).
+func Pack(unpacked []byte, radix int, blockSize int64) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w, err := NewPackWriter(buf, radix, blockSize)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(unpacked); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// PackWriter is a streaming version of Pack.
+// It packs digits written to it and writes packed words to the underlying writer.
+// Callers must Close the PackWriter to flush the last word.
+type PackWriter struct {
+	w         io.Writer
+	radix     int
+	dpw       int
+	blockSize int64
+	// blockOff is the number of digits written in the current block.
+	blockOff int64
+	// word holds digits not packed yet.
+	word []byte
+	buf  [WordSize]byte
+	err  error
+}
+
+var _ io.WriteCloser = new(PackWriter)
+
+// NewPackWriter returns a new PackWriter that writes packed words to w.
+// radix must be 10 or 16 and blockSize must be positive.
+func NewPackWriter(w io.Writer, radix int, blockSize int64) (*PackWriter, error) {
+	if radix != 10 && radix != 16 {
+		return nil, ErrUnknownRadix
+	}
+	if blockSize <= 0 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidBlockSize, blockSize)
+	}
+	dpw := ycd.DigitsPerWord(radix)
+	return &PackWriter{
+		w:         w,
+		radix:     radix,
+		dpw:       dpw,
+		blockSize: blockSize,
+		word:      make([]byte, 0, dpw),
+	}, nil
+}
+
+// Write packs digit characters in p.
+// A partial word at the end of p is kept until more digits are written.
+func (w *PackWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := 0
+	for n < len(p) {
+		// Number of digits to complete the current word within the block.
+		need := w.dpw - len(w.word)
+		if rem := w.blockSize - w.blockOff; int64(need) > rem {
+			need = int(rem)
+		}
+		if need > len(p)-n {
+			need = len(p) - n
+		}
+		w.word = append(w.word, p[n:n+need]...)
+		n += need
+		w.blockOff += int64(need)
+		if len(w.word) == w.dpw || w.blockOff == w.blockSize {
+			if err := w.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *PackWriter) flush() error {
+	if len(w.word) == 0 {
+		return nil
+	}
+	v, err := packWord(w.word, w.radix, w.dpw)
+	if err != nil {
+		w.err = err
+		return err
+	}
+	binary.LittleEndian.PutUint64(w.buf[:], v)
+	if _, err := w.w.Write(w.buf[:]); err != nil {
+		w.err = err
+		return err
+	}
+	w.word = w.word[:0]
+	if w.blockOff == w.blockSize {
+		w.blockOff = 0
+	}
+	return nil
+}
+
+// Close writes the last partial word, if any, padded with zeros.
+// It doesn't close the underlying writer.
+func (w *PackWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.flush()
+}
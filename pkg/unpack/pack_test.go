@@ -0,0 +1,215 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unpack
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/googlecloudplatform/pi-delivery/pkg/resultset"
+	"github.com/googlecloudplatform/pi-delivery/pkg/tests"
+	"github.com/googlecloudplatform/pi-delivery/pkg/ycd"
+)
+
+func TestPack_Fixtures(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name      string
+		radix     int
+		blockSize int64
+		unpacked  []byte
+		want      []byte
+	}{
+		{"dec single block", 10, int64(len(wantUnpackedDec)), wantUnpackedDec, testDecBytes},
+		{"hex single block", 16, int64(len(wantUnpackedHex)), wantUnpackedHex, testHexBytes},
+		// The last 8 bytes of testDecMultipleBlocks are dummy data.
+		{"dec multiple blocks", 10, 30, wantUnpackedDec[:100], testDecMultipleBlocks[:len(testDecMultipleBlocks)-8]},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := Pack(tc.unpacked, tc.radix, tc.blockSize)
+			if err != nil {
+				t.Fatalf("Pack() failed: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Pack() = (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPack_PackBlock(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		radix    int
+		unpacked string
+		packed   int
+		want     []byte
+		wantErr  error
+	}{
+		{10, "", 0, []byte{}, nil},
+		{10, "1415926535897932384", 8, []byte{0x60, 0xe2, 0x3e, 0xb8, 0xae, 0x61, 0xa6, 0x13}, nil},
+		{10, "5", 8, []byte{0x00, 0x00, 0xf4, 0x44, 0x82, 0x91, 0x63, 0x45}, nil},
+		{16, "FFFFFFFF", 8, []byte{0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff}, nil},
+		{10, "14159265358979323846", 8, nil, ErrBufferTooSmall},
+		{10, "14a", 8, nil, ErrInvalidDigit},
+		{16, "14g", 8, nil, ErrInvalidDigit},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(fmt.Sprintf("Radix %d %s", tc.radix, tc.unpacked), func(t *testing.T) {
+			t.Parallel()
+			packed := make([]byte, tc.packed)
+			n, err := PackBlock(packed, []byte(tc.unpacked), tc.radix)
+			if !cmp.Equal(err, tc.wantErr, cmpopts.EquateErrors()) {
+				t.Fatalf("PackBlock() = got error %v, want %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(tc.want, packed[:n]); diff != "" {
+				t.Errorf("PackBlock() = (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPack_InvalidOptions(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		radix     int
+		blockSize int64
+		wantErr   error
+	}{
+		{10, 0, ErrInvalidBlockSize},
+		{16, -1, ErrInvalidBlockSize},
+		{2, 10, ErrUnknownRadix},
+	}
+	for _, tc := range testCases {
+		if _, err := Pack([]byte("1234"), tc.radix, tc.blockSize); !errors.Is(err, tc.wantErr) {
+			t.Errorf("Pack(%d, %d) = got error %v, want %v", tc.radix, tc.blockSize, err, tc.wantErr)
+		}
+		if _, err := NewPackWriter(io.Discard, tc.radix, tc.blockSize); !errors.Is(err, tc.wantErr) {
+			t.Errorf("NewPackWriter(%d, %d) = got error %v, want %v", tc.radix, tc.blockSize, err, tc.wantErr)
+		}
+	}
+}
+
+// genDigits returns n random digit characters in radix.
+func genDigits(rnd *rand.Rand, radix, n int) []byte {
+	digits := make([]byte, n)
+	for i := range digits {
+		digits[i] = "0123456789abcdef"[rnd.Intn(radix)]
+	}
+	return digits
+}
+
+func TestPack_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	for _, radix := range []int{10, 16} {
+		radix := radix
+		t.Run(fmt.Sprintf("Radix %d", radix), func(t *testing.T) {
+			t.Parallel()
+			rnd := rand.New(rand.NewSource(int64(radix)))
+			for i := 0; i < 50; i++ {
+				blockSize := int64(rnd.Intn(100) + 1)
+				total := rnd.Intn(500) + 1
+				digits := genDigits(rnd, radix, total)
+
+				packed, err := Pack(digits, radix, blockSize)
+				if err != nil {
+					t.Fatalf("Pack() failed: %v", err)
+				}
+
+				// PackWriter should produce the same output with any write sizes.
+				buf := new(bytes.Buffer)
+				w, err := NewPackWriter(buf, radix, blockSize)
+				if err != nil {
+					t.Fatalf("NewPackWriter() failed: %v", err)
+				}
+				for rest := digits; len(rest) > 0; {
+					n := rnd.Intn(len(rest)) + 1
+					if _, err := w.Write(rest[:n]); err != nil {
+						t.Fatalf("Write() failed: %v", err)
+					}
+					rest = rest[n:]
+				}
+				if err := w.Close(); err != nil {
+					t.Fatalf("Close() failed: %v", err)
+				}
+				if diff := cmp.Diff(packed, buf.Bytes()); diff != "" {
+					t.Fatalf("PackWriter = (-want, +got):\n%s", diff)
+				}
+
+				set := newPackTestSet(radix, blockSize, int64(total))
+				ctx := context.Background()
+				bucket := tests.NewMockBucket(ctx, gomock.NewController(t), set, packed)
+				rr := set.NewReader(ctx, bucket)
+				rd := NewReader(ctx, rr)
+				got, err := io.ReadAll(rd)
+				if err != nil {
+					t.Fatalf("ReadAll() failed: %v", err)
+				}
+				if diff := cmp.Diff(digits, got); diff != "" {
+					t.Fatalf("ReadAll(blockSize = %d, total = %d) = (-want, +got):\n%s", blockSize, total, diff)
+				}
+				off := rnd.Intn(total)
+				n := rnd.Intn(total-off) + 1
+				got = make([]byte, n)
+				if _, err := rd.ReadAt(got, int64(off)); err != nil && err != io.EOF {
+					t.Fatalf("ReadAt() failed: %v", err)
+				}
+				if diff := cmp.Diff(digits[off:off+n], got); diff != "" {
+					t.Fatalf("ReadAt(off = %d, n = %d) = (-want, +got):\n%s", off, n, diff)
+				}
+				rr.Close()
+			}
+		})
+	}
+}
+
+// newPackTestSet returns a result set for total digits in blocks of blockSize digits.
+func newPackTestSet(radix int, blockSize, total int64) resultset.ResultSet {
+	set := resultset.ResultSet{}
+	for id := int64(0); id*blockSize < total; id++ {
+		h := &ycd.Header{
+			Radix:     radix,
+			BlockSize: blockSize,
+			BlockID:   id,
+		}
+		if (id+1)*blockSize > total {
+			h.TotalDigits = total
+		}
+		set = append(set, &ycd.YCDFile{
+			Header: h,
+			Name:   fmt.Sprintf("%d.ycd", id),
+		})
+	}
+	return set
+}
@@ -36,31 +36,66 @@ type UpstreamReader interface {
 // to unpacked string representation ("14159...").
 // Note the first offset is still the first digit after the decimal point as in
 // the packed format.
+// Offsets are always in digits regardless of the output format.
 type UnpackReader struct {
+	ctx         context.Context
 	radix       int
+	format      Format
 	off         int64
 	totalDigits int64
 	blockSize   int64
 	rd          UpstreamReader
 	seeked      bool
 	unread      []byte
+	// packed is reused by Read.
+	packed []byte
 }
 
 var _ io.ReadSeeker = new(UnpackReader)
 var _ io.ReaderAt = new(UnpackReader)
+var _ io.WriterTo = new(UnpackReader)
+
+// writeToBufferSize is the size of the buffer WriteTo unpacks digits to.
+const writeToBufferSize = 1024 * 1024
 
 var ErrNotFullWord = errors.New("read bytes are not full words")
 
-// NewReader returns a new UnpackReader for UpstreamReader rd
+// NewReader returns a new UnpackReader for UpstreamReader rd.
+// Reads fail with ctx.Err() once ctx is done.
 func NewReader(ctx context.Context, rd UpstreamReader) *UnpackReader {
+	return NewReaderWithFormat(ctx, rd, FormatASCII)
+}
+
+// NewReaderWithFormat returns a new UnpackReader for UpstreamReader rd
+// that writes digits in format.
+// With FormatNibble, a read of len(p) bytes reads 2*len(p) digits.
+func NewReaderWithFormat(ctx context.Context, rd UpstreamReader, format Format) *UnpackReader {
 	return &UnpackReader{
+		ctx:         ctx,
 		radix:       rd.ResultSet().Radix(),
+		format:      format,
 		totalDigits: rd.ResultSet().TotalDigits(),
 		blockSize:   rd.ResultSet().BlockSize(),
 		rd:          rd,
 	}
 }
 
+// digitsIn returns the number of digits stored in n bytes.
+func (r *UnpackReader) digitsIn(n int) int {
+	if r.format == FormatNibble {
+		return n * 2
+	}
+	return n
+}
+
+// bytesFor returns the number of bytes to store n digits.
+func (r *UnpackReader) bytesFor(n int) int {
+	if r.format == FormatNibble {
+		return int(NibbleLen(int64(n)))
+	}
+	return n
+}
+
 // ReadAt reads len(p) bytes of unpacked digits starting at the off-th digit.
 // ReadAt(p, 0) returns 141592... for decimal results.
 // Note that YCD files starts at the second digit after the decimal point
@@ -72,8 +107,12 @@ func (r *UnpackReader) ReadAt(p []byte, off int64) (int, error) {
 	if off >= r.totalDigits {
 		return 0, io.EOF
 	}
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
 
-	start, n, pre, _ := ToPackedOffsets(off, r.blockSize, int64(len(p)), ycd.DigitsPerWord(r.radix))
+	digits := r.digitsIn(len(p))
+	start, n, pre, _ := ToPackedOffsets(off, r.blockSize, int64(digits), ycd.DigitsPerWord(r.radix))
 	packed := make([]byte, n)
 	read, err := r.rd.ReadAt(packed, start)
 	if read == 0 {
@@ -82,16 +121,16 @@ func (r *UnpackReader) ReadAt(p []byte, off int64) (int, error) {
 	if read%WordSize != 0 {
 		return 0, fmt.Errorf("read %v bytes: %w", read, ErrNotFullWord)
 	}
-	remaining := len(p)
+	remaining := digits
 	if remaining > int(r.totalDigits-off) {
 		remaining = int(r.totalDigits - off)
 		err = io.EOF
 	}
-	written, perr := r.unpack(p[:remaining], packed[:read], off, pre)
+	written, perr := r.unpack(p, remaining, packed[:read], off, pre)
 	if perr != nil {
-		return written, fmt.Errorf("unpack error at off %v: %w", off, perr)
+		return r.bytesFor(written), fmt.Errorf("unpack error at off %v: %w", off, perr)
 	}
-	return written, err
+	return r.bytesFor(written), err
 }
 
 // Read reads len(p) bytes of unpacked digits starting at the current reader offset.
@@ -102,12 +141,16 @@ func (r *UnpackReader) Read(p []byte) (int, error) {
 	if r.off >= r.totalDigits {
 		return 0, io.EOF
 	}
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
 
 	written := 0
 	read := 0
 
+	digits := r.digitsIn(len(p))
 	dpw := ycd.DigitsPerWord(r.radix)
-	start, packedN, pre, post := ToPackedOffsets(r.off, r.blockSize, int64(len(p)), dpw)
+	start, packedN, pre, post := ToPackedOffsets(r.off, r.blockSize, int64(digits), dpw)
 	if r.seeked {
 		if _, err := r.rd.Seek(start, io.SeekStart); err != nil {
 			return written, err
@@ -116,7 +159,10 @@ func (r *UnpackReader) Read(p []byte) (int, error) {
 		r.seeked = false
 	}
 
-	packed := make([]byte, packedN)
+	if int64(cap(r.packed)) < packedN {
+		r.packed = make([]byte, packedN)
+	}
+	packed := r.packed[:packedN]
 	if len(r.unread) > 0 {
 		read += copy(packed, r.unread)
 		if post == 0 || packedN > 2*WordSize {
@@ -127,13 +173,13 @@ func (r *UnpackReader) Read(p []byte) (int, error) {
 	n, err := io.ReadFull(r.rd, packed[read:])
 	read += n
 
-	remaining := len(p)
+	remaining := digits
 	if remaining > int(r.totalDigits-r.off) {
 		remaining = int(r.totalDigits - r.off)
 	}
-	n, perr := r.unpack(p[:remaining], packed[:read], r.off, pre)
+	n, perr := r.unpack(p, remaining, packed[:read], r.off, pre)
 	r.off += int64(n)
-	written += n
+	written += r.bytesFor(n)
 
 	if read%WordSize != 0 {
 		return written, fmt.Errorf("off %v, read bytes %v: %w", r.off, n, ErrNotFullWord)
@@ -157,6 +203,30 @@ func (r *UnpackReader) Read(p []byte) (int, error) {
 	return written, err
 }
 
+// WriteTo writes unpacked digits from the current offset to the end to w.
+// Use it with a sequential upstream such as resultset.PrefetchReader
+// to stream a large number of digits.
+func (r *UnpackReader) WriteTo(w io.Writer) (int64, error) {
+	buf := make([]byte, writeToBufferSize)
+	written := int64(0)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			m, werr := w.Write(buf[:n])
+			written += int64(m)
+			if werr != nil {
+				return written, werr
+			}
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
 // Seek updates the offset for the next Read.
 func (r *UnpackReader) Seek(offset int64, whence int) (int64, error) {
 	off := r.off
@@ -179,20 +249,32 @@ func (r *UnpackReader) Seek(offset int64, whence int) (int64, error) {
 	return off, nil
 }
 
-func (r *UnpackReader) unpack(unpacked, packed []byte, offset int64, pre int) (int, error) {
+// unpack writes up to digits digits in packed to unpacked in r.format.
+// Returns the number of digits written.
+func (r *UnpackReader) unpack(unpacked []byte, digits int, packed []byte, offset int64, pre int) (int, error) {
 	poff := 0
 	written := 0
 	dpw := ycd.DigitsPerWord(r.radix)
 
-	for poff < len(packed) && written < len(unpacked) {
-		remaining := len(unpacked) - written
+	for poff < len(packed) && written < digits {
+		remaining := digits - written
 		reqDigits := remaining
 		if offset%r.blockSize+int64(remaining) > r.blockSize {
 			reqDigits = int(r.blockSize - offset%r.blockSize)
 
 		}
 		reqBytes := (reqDigits + dpw - 1) / dpw * WordSize
-		n, err := UnpackBlock(unpacked[written:written+reqDigits], packed[poff:poff+reqBytes], r.radix, pre)
+		if reqBytes > len(packed)-poff {
+			reqBytes = len(packed) - poff
+		}
+		var n int
+		var err error
+		switch r.format {
+		case FormatNibble:
+			n, err = unpackBlockNibbles(unpacked, written, packed[poff:poff+reqBytes], r.radix, pre, reqDigits)
+		default:
+			n, err = unpackBlock(unpacked[written:written+reqDigits], packed[poff:poff+reqBytes], r.radix, pre, r.format)
+		}
 		poff += reqBytes
 		written += n
 		offset += int64(n)
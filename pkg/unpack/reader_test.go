@@ -15,9 +15,12 @@
 package unpack
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"testing"
 	"testing/iotest"
 
@@ -469,3 +472,130 @@ var testDecMultipleBlocks = []byte{
 	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // dummy data (reader should ignore this).
 	// Block Boundary
 }
+
+func TestUnpack_ReaderFormats(t *testing.T) {
+	t.Parallel()
+
+	for _, radix := range []int{10, 16} {
+		radix := radix
+		t.Run(fmt.Sprintf("Radix %d", radix), func(t *testing.T) {
+			t.Parallel()
+			rnd := rand.New(rand.NewSource(int64(radix)))
+			for i := 0; i < 50; i++ {
+				blockSize := int64(rnd.Intn(100) + 1)
+				total := rnd.Intn(500) + 1
+				digits := genDigits(rnd, radix, total)
+				packed, err := Pack(digits, radix, blockSize)
+				if err != nil {
+					t.Fatalf("Pack() failed: %v", err)
+				}
+				raw := make([]byte, len(digits))
+				for j, c := range digits {
+					raw[j] = digitValues[c]
+				}
+
+				set := newPackTestSet(radix, blockSize, int64(total))
+				ctx := context.Background()
+				bucket := tests.NewMockBucket(ctx, gomock.NewController(t), set, packed)
+				rr := set.NewReader(ctx, bucket)
+
+				rd := NewReaderWithFormat(ctx, rr, FormatRaw)
+				got, err := io.ReadAll(rd)
+				if err != nil {
+					t.Fatalf("ReadAll(FormatRaw) failed: %v", err)
+				}
+				if diff := cmp.Diff(raw, got); diff != "" {
+					t.Fatalf("ReadAll(FormatRaw, blockSize = %d, total = %d) = (-want, +got):\n%s", blockSize, total, diff)
+				}
+
+				rd = NewReaderWithFormat(ctx, rr, FormatNibble)
+				if _, err := rr.Seek(0, io.SeekStart); err != nil {
+					t.Fatalf("Seek() failed: %v", err)
+				}
+				got, err = io.ReadAll(rd)
+				if err != nil {
+					t.Fatalf("ReadAll(FormatNibble) failed: %v", err)
+				}
+				if diff := cmp.Diff(toNibbles(digits), got); diff != "" {
+					t.Fatalf("ReadAll(FormatNibble, blockSize = %d, total = %d) = (-want, +got):\n%s", blockSize, total, diff)
+				}
+
+				off := rnd.Intn(total)
+				n := rnd.Intn(total-off) + 1
+				got = make([]byte, NibbleLen(int64(n)))
+				if _, err := rd.ReadAt(got, int64(off)); err != nil && err != io.EOF {
+					t.Fatalf("ReadAt(FormatNibble) failed: %v", err)
+				}
+				want := toNibbles(digits[off : off+n])
+				// An odd n reads one more digit into the last low nibble if it's available.
+				if n%2 == 1 && off+n < total {
+					want[len(want)-1] |= digitValues[digits[off+n]]
+				}
+				if diff := cmp.Diff(want, got); diff != "" {
+					t.Fatalf("ReadAt(FormatNibble, off = %d, n = %d) = (-want, +got):\n%s", off, n, diff)
+				}
+				rr.Close()
+			}
+		})
+	}
+}
+
+func TestUnpack_PrefetchWriteTo(t *testing.T) {
+	t.Parallel()
+
+	for _, radix := range []int{10, 16} {
+		radix := radix
+		t.Run(fmt.Sprintf("Radix %d", radix), func(t *testing.T) {
+			t.Parallel()
+			rnd := rand.New(rand.NewSource(int64(radix)))
+			for i := 0; i < 50; i++ {
+				blockSize := int64(rnd.Intn(100) + 1)
+				total := rnd.Intn(500) + 1
+				digits := genDigits(rnd, radix, total)
+				packed, err := Pack(digits, radix, blockSize)
+				if err != nil {
+					t.Fatalf("Pack() failed: %v", err)
+				}
+
+				set := newPackTestSet(radix, blockSize, int64(total))
+				ctx := context.Background()
+				bucket := tests.NewMockBucket(ctx, gomock.NewController(t), set, packed)
+				rr := set.NewPrefetchReader(ctx, bucket, resultset.PrefetchOptions{
+					Depth:     rnd.Intn(4) + 1,
+					ChunkSize: (rnd.Intn(8) + 1) * WordSize,
+				})
+				rd := NewReader(ctx, rr)
+
+				off := rnd.Intn(total)
+				if _, err := rd.Seek(int64(off), io.SeekStart); err != nil {
+					t.Fatalf("Seek() failed: %v", err)
+				}
+				buf := new(bytes.Buffer)
+				if _, err := io.Copy(buf, rd); err != nil {
+					t.Fatalf("Copy() failed: %v", err)
+				}
+				if diff := cmp.Diff(digits[off:], buf.Bytes()); diff != "" {
+					t.Fatalf("Copy(blockSize = %d, total = %d, off = %d) = (-want, +got):\n%s", blockSize, total, off, diff)
+				}
+				rr.Close()
+			}
+		})
+	}
+}
+
+func TestUnpack_Cancel(t *testing.T) {
+	t.Parallel()
+
+	set := newPackTestSet(10, 30, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// No requests are expected once ctx is canceled.
+	bucket := tests.NewMockBucket(ctx, gomock.NewController(t), set, nil)
+	rd := NewReader(ctx, set.NewReader(ctx, bucket))
+	if _, err := rd.ReadAt(make([]byte, 10), 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("ReadAt() error got %v, want %v", err, context.Canceled)
+	}
+	if _, err := rd.Read(make([]byte, 10)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Read() error got %v, want %v", err, context.Canceled)
+	}
+}
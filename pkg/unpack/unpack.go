@@ -15,10 +15,8 @@
 package unpack
 
 import (
-	"encoding/binary"
 	"errors"
 	"fmt"
-	"strconv"
 
 	"github.com/googlecloudplatform/pi-delivery/pkg/ycd"
 )
@@ -27,17 +25,47 @@ var ErrUnknownRadix error = errors.New("Unpack: unknown radix")
 var ErrBufferTooSmall error = errors.New("Unpack: destination buffer is too small")
 var ErrInvalidWord error = errors.New("Unpack: invalid word")
 
+const WordSize = ycd.WordSize
+
+// Format is the output format of unpacked digits.
+type Format int
+
 const (
-	zeros    = "0000000000000000000"
-	WordSize = ycd.WordSize
+	// FormatASCII is a character per digit ('0'-'9', 'a'-'f').
+	FormatASCII Format = iota
+	// FormatRaw is a digit value (0-15) per byte.
+	FormatRaw
+	// FormatNibble is two digit values per byte (packed BCD for decimal).
+	// The first digit is in the high nibble. If the number of digits is odd,
+	// the low nibble of the last byte is zero.
+	FormatNibble
 )
 
-func copyWithZero(dst []byte, s string, nz int) int {
-	return copy(dst, zeros[:nz]) + copy(dst[nz:], s)
+// NibbleLen returns a number of bytes to store n digits in FormatNibble.
+func NibbleLen(n int64) int64 {
+	return (n + 1) / 2
 }
 
 // UnpackBlock reads packed digits from packed and writes unpacked strings to unpacked.
+// Words are converted with lookup tables directly into unpacked without allocations.
 func UnpackBlock(unpacked, packed []byte, radix, pre int) (int, error) {
+	return unpackBlock(unpacked, packed, radix, pre, FormatASCII)
+}
+
+// UnpackBlockRaw is the same as UnpackBlock but writes digit values (0-15)
+// instead of characters.
+func UnpackBlockRaw(unpacked, packed []byte, radix, pre int) (int, error) {
+	return unpackBlock(unpacked, packed, radix, pre, FormatRaw)
+}
+
+// UnpackBlockNibbles reads up to n digits from packed, skipping pre digits
+// in the first word, and writes two digits per byte to unpacked.
+// Returns the number of digits written.
+func UnpackBlockNibbles(unpacked, packed []byte, radix, pre, n int) (int, error) {
+	return unpackBlockNibbles(unpacked, 0, packed, radix, pre, n)
+}
+
+func unpackBlock(unpacked, packed []byte, radix, pre int, format Format) (int, error) {
 	if len(packed) == 0 || len(unpacked) == 0 {
 		return 0, nil
 	}
@@ -52,54 +80,69 @@ func UnpackBlock(unpacked, packed []byte, radix, pre int) (int, error) {
 
 	// Unpack the first word with pre.
 	// Copy dpw-pre bytes.
-	s := strconv.FormatUint(binary.LittleEndian.Uint64(packed), radix)
-	nz := dpw - len(s)
-	if nz < 0 {
-		return 0, fmt.Errorf("%w: word = %16x, unpacked = %s",
-			ErrInvalidWord, packed[:WordSize], s)
-	}
-	nzNeeded := nz - pre
-	if nzNeeded < 0 {
-		nzNeeded = 0
-	}
-	n := copy(unpacked, zeros[:nzNeeded])
-	if n < dpw-pre && n < len(unpacked) {
-		if nz < pre {
-			n += copy(unpacked[n:], s[pre-nz:dpw-pre-n+(pre-nz)])
-		} else {
-			n += copy(unpacked[n:], s[:dpw-pre-n])
-		}
+	var word [maxDigitsPerWord]byte
+	if err := unpackWordFormat(word[:], packed, radix, format); err != nil {
+		return 0, err
 	}
+	n := copy(unpacked, word[pre:dpw])
 
 	if len(packed) == WordSize {
 		return n, nil
 	}
 
 	// Process until the second last word.
+	// These words always fit in unpacked so write them directly.
 	for i := WordSize; i < len(packed)-WordSize; i += WordSize {
-		s := strconv.FormatUint(binary.LittleEndian.Uint64(packed[i:]), radix)
-		nz := dpw - len(s)
-		if nz < 0 {
-			return n, fmt.Errorf("%w: word = %16x, unpacked = %s", ErrInvalidWord,
-				packed[i:i+WordSize], s)
+		if err := unpackWordFormat(unpacked[n:n+dpw], packed[i:], radix, format); err != nil {
+			return n, err
 		}
-		n += copyWithZero(unpacked[n:], s, nz)
+		n += dpw
 	}
 
 	// Process the last word with post.
-	s = strconv.FormatUint(binary.LittleEndian.Uint64(packed[len(packed)-WordSize:]), radix)
-	nz = dpw - len(s)
-	if nz < 0 {
-		return n, fmt.Errorf("%w: word = %16x, unpacked = %s", ErrInvalidWord,
-			packed[len(packed)-WordSize:], s)
-	}
-	n += copy(unpacked[n:], zeros[:nz])
-	if n < len(unpacked) {
-		n += copy(unpacked[n:], s)
+	if err := unpackWordFormat(word[:], packed[len(packed)-WordSize:], radix, format); err != nil {
+		return n, err
 	}
+	n += copy(unpacked[n:], word[:dpw])
 	return n, nil
 }
 
+// unpackBlockNibbles writes up to n digits in FormatNibble starting at
+// the start-th nibble of unpacked.
+func unpackBlockNibbles(unpacked []byte, start int, packed []byte, radix, pre, n int) (int, error) {
+	if len(packed) == 0 || n == 0 {
+		return 0, nil
+	}
+	if required := NibbleLen(int64(start + n)); int64(len(unpacked)) < required {
+		return 0, fmt.Errorf("%w: required = %v bytes, actual buffer = %v bytes",
+			ErrBufferTooSmall, required, len(unpacked))
+	}
+
+	dpw := ycd.DigitsPerWord(radix)
+	var word [maxDigitsPerWord]byte
+	written := 0
+	for i := 0; i+WordSize <= len(packed) && written < n; i += WordSize {
+		if err := unpackWordFormat(word[:], packed[i:], radix, FormatRaw); err != nil {
+			return written, err
+		}
+		digits := word[pre:dpw]
+		if len(digits) > n-written {
+			digits = digits[:n-written]
+		}
+		for _, d := range digits {
+			pos := start + written
+			if pos%2 == 0 {
+				unpacked[pos/2] = d << 4
+			} else {
+				unpacked[pos/2] |= d
+			}
+			written++
+		}
+		pre = 0
+	}
+	return written, nil
+}
+
 // UnpackedLen returns a number of bytes to store
 // an unpacked sequence for n bytes of packed bytes.
 func UnpackedLen(n int64, radix int) int64 {
@@ -15,7 +15,10 @@
 package unpack
 
 import (
+	"encoding/binary"
 	"fmt"
+	"math/rand"
+	"strconv"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -175,3 +178,241 @@ func TestUnpack_Unpack(t *testing.T) {
 		})
 	}
 }
+
+// unpackBlockReference is the original strconv based implementation of UnpackBlock.
+func unpackBlockReference(unpacked, packed []byte, radix, pre int) (int, error) {
+	const zeros = "0000000000000000000"
+	if len(packed) == 0 || len(unpacked) == 0 {
+		return 0, nil
+	}
+
+	dpw := ycd.DigitsPerWord(radix)
+	s := strconv.FormatUint(binary.LittleEndian.Uint64(packed), radix)
+	nz := dpw - len(s)
+	if nz < 0 {
+		return 0, ErrInvalidWord
+	}
+	nzNeeded := nz - pre
+	if nzNeeded < 0 {
+		nzNeeded = 0
+	}
+	n := copy(unpacked, zeros[:nzNeeded])
+	if n < dpw-pre && n < len(unpacked) {
+		if nz < pre {
+			n += copy(unpacked[n:], s[pre-nz:dpw-pre-n+(pre-nz)])
+		} else {
+			n += copy(unpacked[n:], s[:dpw-pre-n])
+		}
+	}
+	if len(packed) == WordSize {
+		return n, nil
+	}
+	for i := WordSize; i < len(packed)-WordSize; i += WordSize {
+		s := strconv.FormatUint(binary.LittleEndian.Uint64(packed[i:]), radix)
+		nz := dpw - len(s)
+		if nz < 0 {
+			return n, ErrInvalidWord
+		}
+		n += copy(unpacked[n:], zeros[:nz]) + copy(unpacked[n+nz:], s)
+	}
+	s = strconv.FormatUint(binary.LittleEndian.Uint64(packed[len(packed)-WordSize:]), radix)
+	nz = dpw - len(s)
+	if nz < 0 {
+		return n, ErrInvalidWord
+	}
+	n += copy(unpacked[n:], zeros[:nz])
+	if n < len(unpacked) {
+		n += copy(unpacked[n:], s)
+	}
+	return n, nil
+}
+
+// genPackedWords returns n random valid words in radix.
+// Small values are mixed in to test leading zeros.
+func genPackedWords(rnd *rand.Rand, radix, n int) []byte {
+	packed := make([]byte, n*WordSize)
+	for i := 0; i < n; i++ {
+		v := rnd.Uint64()
+		if radix == 10 {
+			v %= maxDecWord + 1
+		}
+		switch rnd.Intn(4) {
+		case 0:
+			v >>= rnd.Intn(64)
+		case 1:
+			v = 0
+		}
+		binary.LittleEndian.PutUint64(packed[i*WordSize:], v)
+	}
+	return packed
+}
+
+func TestUnpack_Equivalence(t *testing.T) {
+	t.Parallel()
+
+	for _, radix := range []int{10, 16} {
+		radix := radix
+		t.Run(fmt.Sprintf("Radix %d", radix), func(t *testing.T) {
+			t.Parallel()
+			rnd := rand.New(rand.NewSource(int64(radix)))
+			dpw := ycd.DigitsPerWord(radix)
+			for i := 0; i < 1000; i++ {
+				words := rnd.Intn(8) + 1
+				packed := genPackedWords(rnd, radix, words)
+				pre := rnd.Intn(dpw)
+				post := rnd.Intn(dpw)
+				l := words*dpw - pre - post
+				if l <= 0 {
+					continue
+				}
+				want := make([]byte, l)
+				got := make([]byte, l)
+				wantN, wantErr := unpackBlockReference(want, packed, radix, pre)
+				gotN, gotErr := UnpackBlock(got, packed, radix, pre)
+				if gotErr != wantErr {
+					t.Fatalf("UnpackBlock(%x, pre = %d) = got error %v, want %v", packed, pre, gotErr, wantErr)
+				}
+				if gotN != wantN {
+					t.Errorf("UnpackBlock(%x, pre = %d): n = got %d, want %d", packed, pre, gotN, wantN)
+				}
+				if diff := cmp.Diff(want, got); diff != "" {
+					t.Errorf("UnpackBlock(%x, pre = %d) = (-want, +got):\n%s", packed, pre, diff)
+				}
+			}
+		})
+	}
+}
+
+// toNibbles converts digit characters to FormatNibble.
+func toNibbles(digits []byte) []byte {
+	out := make([]byte, NibbleLen(int64(len(digits))))
+	for i, c := range digits {
+		d := digitValues[c]
+		if i%2 == 0 {
+			out[i/2] = d << 4
+		} else {
+			out[i/2] |= d
+		}
+	}
+	return out
+}
+
+func TestUnpack_Formats(t *testing.T) {
+	t.Parallel()
+
+	for _, radix := range []int{10, 16} {
+		radix := radix
+		t.Run(fmt.Sprintf("Radix %d", radix), func(t *testing.T) {
+			t.Parallel()
+			rnd := rand.New(rand.NewSource(int64(radix)))
+			dpw := ycd.DigitsPerWord(radix)
+			for i := 0; i < 1000; i++ {
+				words := rnd.Intn(8) + 1
+				packed := genPackedWords(rnd, radix, words)
+				pre := rnd.Intn(dpw)
+				post := rnd.Intn(dpw)
+				l := words*dpw - pre - post
+				if l <= 0 {
+					continue
+				}
+				ascii := make([]byte, l)
+				if _, err := UnpackBlock(ascii, packed, radix, pre); err != nil {
+					t.Fatalf("UnpackBlock() failed: %v", err)
+				}
+
+				raw := make([]byte, l)
+				n, err := UnpackBlockRaw(raw, packed, radix, pre)
+				if err != nil {
+					t.Fatalf("UnpackBlockRaw() failed: %v", err)
+				}
+				if n != l {
+					t.Errorf("UnpackBlockRaw(): n = got %d, want %d", n, l)
+				}
+				for j, c := range ascii {
+					if raw[j] != digitValues[c] {
+						t.Fatalf("UnpackBlockRaw(%x, pre = %d)[%d] = got %d, want %c", packed, pre, j, raw[j], c)
+					}
+				}
+
+				nibbles := make([]byte, NibbleLen(int64(l)))
+				n, err = UnpackBlockNibbles(nibbles, packed, radix, pre, l)
+				if err != nil {
+					t.Fatalf("UnpackBlockNibbles() failed: %v", err)
+				}
+				if n != l {
+					t.Errorf("UnpackBlockNibbles(): n = got %d, want %d", n, l)
+				}
+				if diff := cmp.Diff(toNibbles(ascii), nibbles); diff != "" {
+					t.Errorf("UnpackBlockNibbles(%x, pre = %d) = (-want, +got):\n%s", packed, pre, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestUnpack_NibblesBufferTooSmall(t *testing.T) {
+	t.Parallel()
+
+	packed := make([]byte, 2*WordSize)
+	_, err := UnpackBlockNibbles(make([]byte, 9), packed, 10, 0, 19)
+	if !cmp.Equal(err, ErrBufferTooSmall, cmpopts.EquateErrors()) {
+		t.Errorf("UnpackBlockNibbles() = got error %v, want %v", err, ErrBufferTooSmall)
+	}
+}
+
+func TestUnpack_InvalidWord(t *testing.T) {
+	t.Parallel()
+
+	packed := make([]byte, 3*WordSize)
+	binary.LittleEndian.PutUint64(packed[WordSize:], maxDecWord+1)
+	unpacked := make([]byte, 3*19)
+	n, err := UnpackBlock(unpacked, packed, 10, 0)
+	if !cmp.Equal(err, ErrInvalidWord, cmpopts.EquateErrors()) {
+		t.Errorf("UnpackBlock() = got error %v, want %v", err, ErrInvalidWord)
+	}
+	if want := 19; n != want {
+		t.Errorf("UnpackBlock(): n = got %d, want %d", n, want)
+	}
+}
+
+func TestUnpack_Allocs(t *testing.T) {
+	packed := genPackedWords(rand.New(rand.NewSource(1)), 10, 64)
+	unpacked := make([]byte, 64*19)
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := UnpackBlock(unpacked, packed, 10, 1); err != nil {
+			t.Fatalf("UnpackBlock() failed: %v", err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("UnpackBlock() = got %v allocs, want 0", allocs)
+	}
+}
+
+func benchmarkUnpackBlock(b *testing.B, radix int, unpack func([]byte, []byte, int, int) (int, error)) {
+	const words = 64 * 1024
+	packed := genPackedWords(rand.New(rand.NewSource(1)), radix, words)
+	unpacked := make([]byte, words*ycd.DigitsPerWord(radix))
+	b.SetBytes(int64(len(unpacked)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := unpack(unpacked, packed, radix, 0); err != nil {
+			b.Fatalf("unpack failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkUnpackBlock_Dec(b *testing.B) {
+	benchmarkUnpackBlock(b, 10, UnpackBlock)
+}
+
+func BenchmarkUnpackBlock_Hex(b *testing.B) {
+	benchmarkUnpackBlock(b, 16, UnpackBlock)
+}
+
+func BenchmarkUnpackBlock_ReferenceDec(b *testing.B) {
+	benchmarkUnpackBlock(b, 10, unpackBlockReference)
+}
+
+func BenchmarkUnpackBlock_ReferenceHex(b *testing.B) {
+	benchmarkUnpackBlock(b, 16, unpackBlockReference)
+}
@@ -0,0 +1,113 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unpack
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/googlecloudplatform/pi-delivery/pkg/ycd"
+)
+
+// maxDigitsPerWord is the largest ycd.DigitsPerWord.
+const maxDigitsPerWord = 19
+
+// maxDecWord is the largest valid word in decimal (10^19 - 1).
+const maxDecWord = 9_999_999_999_999_999_999
+
+// decTable has "0000" to "9999" concatenated.
+var decTable = func() (t [10000 * 4]byte) {
+	for i := 0; i < 10000; i++ {
+		t[i*4] = byte('0' + i/1000)
+		t[i*4+1] = byte('0' + i/100%10)
+		t[i*4+2] = byte('0' + i/10%10)
+		t[i*4+3] = byte('0' + i%10)
+	}
+	return
+}()
+
+// hexTable has "00" to "ff" as big-endian uint16 values.
+var hexTable = func() (t [256]uint16) {
+	const digits = "0123456789abcdef"
+	for i := 0; i < 256; i++ {
+		t[i] = uint16(digits[i>>4])<<8 | uint16(digits[i&0xf])
+	}
+	return
+}()
+
+// digitValues maps digit characters to their values.
+var digitValues = func() (t [256]byte) {
+	for i := 0; i < 16; i++ {
+		t["0123456789abcdef"[i]] = byte(i)
+	}
+	return
+}()
+
+// formatDec writes the 19 decimal digits of v to dst[:19].
+// v must be smaller than 10^19.
+func formatDec(dst []byte, v uint64) {
+	_ = dst[18]
+	hi := v / 1e16 // < 1000 as v < 10^19.
+	lo := v % 1e16
+	copy(dst[0:3], decTable[hi*4+1:hi*4+4])
+	a, b := lo/1e8, lo%1e8
+	copy(dst[3:7], decTable[a/1e4*4:a/1e4*4+4])
+	copy(dst[7:11], decTable[a%1e4*4:a%1e4*4+4])
+	copy(dst[11:15], decTable[b/1e4*4:b/1e4*4+4])
+	copy(dst[15:19], decTable[b%1e4*4:b%1e4*4+4])
+}
+
+// formatHex writes the 16 hexadecimal digits of v to dst[:16].
+func formatHex(dst []byte, v uint64) {
+	hi := uint64(hexTable[byte(v>>56)])<<48 | uint64(hexTable[byte(v>>48)])<<32 |
+		uint64(hexTable[byte(v>>40)])<<16 | uint64(hexTable[byte(v>>32)])
+	lo := uint64(hexTable[byte(v>>24)])<<48 | uint64(hexTable[byte(v>>16)])<<32 |
+		uint64(hexTable[byte(v>>8)])<<16 | uint64(hexTable[byte(v)])
+	binary.BigEndian.PutUint64(dst[0:8], hi)
+	binary.BigEndian.PutUint64(dst[8:16], lo)
+}
+
+// unpackWord writes the digits of the little-endian word in packed[:WordSize]
+// to dst[:ycd.DigitsPerWord(radix)] with leading zeros.
+func unpackWord(dst, packed []byte, radix int) error {
+	v := binary.LittleEndian.Uint64(packed)
+	switch radix {
+	case 10:
+		if v > maxDecWord {
+			return fmt.Errorf("%w: word = %x, value = %d", ErrInvalidWord, packed[:WordSize], v)
+		}
+		formatDec(dst, v)
+	case 16:
+		formatHex(dst, v)
+	default:
+		return ErrUnknownRadix
+	}
+	return nil
+}
+
+// unpackWordFormat is the same as unpackWord but writes digits in format.
+// format must be either FormatASCII or FormatRaw.
+func unpackWordFormat(dst, packed []byte, radix int, format Format) error {
+	if err := unpackWord(dst, packed, radix); err != nil {
+		return err
+	}
+	if format == FormatRaw {
+		dst = dst[:ycd.DigitsPerWord(radix)]
+		for i, c := range dst {
+			dst[i] = digitValues[c]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ycd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrInvalidDigit = errors.New("ycd: invalid digit")
+var ErrNoDigits = errors.New("ycd: no digits after the radix point")
+
+// CreateFunc returns a writer for the ycd file of block blockID
+// and the object name the file will be stored as.
+type CreateFunc func(blockID int64) (name string, w io.WriteCloser, err error)
+
+// Encoder converts digit text to a set of ycd files.
+type Encoder struct {
+	radix     int
+	blockSize int64
+	create    CreateFunc
+}
+
+// NewEncoder returns a new Encoder that writes blocks of blockSize digits
+// in radix to the writers returned by create.
+func NewEncoder(radix int, blockSize int64, create CreateFunc) (*Encoder, error) {
+	if radix != 10 && radix != 16 {
+		return nil, fmt.Errorf("unknown radix: %v", radix)
+	}
+	if blockSize <= 0 {
+		return nil, fmt.Errorf("invalid block size: %v", blockSize)
+	}
+	return &Encoder{
+		radix:     radix,
+		blockSize: blockSize,
+		create:    create,
+	}, nil
+}
+
+// FileName returns the y-cruncher style file name for blockID,
+// e.g. "Pi - Dec - Chudnovsky - 0.ycd" for prefix "Pi - Dec - Chudnovsky".
+func FileName(prefix string, blockID int64) string {
+	return fmt.Sprintf("%s - %d.ycd", prefix, blockID)
+}
+
+// Encode reads digit text in the y-cruncher text format ("3.14159...") from r
+// and writes ycd files until r returns io.EOF. Whitespace in the input is ignored.
+// It returns the written files sorted by BlockID.
+//
+// Only the last file has TotalDigits set, and only if it has fewer digits
+// than the block size, as y-cruncher does.
+// Encode buffers packed digits of one block in memory because the header
+// of the last block depends on the number of digits in it.
+func (e *Encoder) Encode(r io.Reader) ([]*YCDFile, error) {
+	dr := &digitReader{br: bufio.NewReader(r), radix: e.radix}
+	intPart, err := dr.readIntegerPart()
+	if err != nil {
+		return nil, err
+	}
+	first, err := dr.peek(firstDigitsLen)
+	if err != nil {
+		return nil, err
+	}
+	if len(first) == 0 {
+		return nil, ErrNoDigits
+	}
+	firstDigits := intPart + "." + string(first)
+
+	dpw := DigitsPerWord(e.radix)
+	blockByteLen := (e.blockSize + int64(dpw) - 1) / int64(dpw) * WordSize
+	packed := bytes.NewBuffer(make([]byte, 0, blockByteLen))
+	files := []*YCDFile{}
+	total := int64(0)
+	for id := int64(0); ; id++ {
+		packed.Reset()
+		n, err := e.packBlock(dr, packed)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			break
+		}
+		total += n
+		more, err := dr.more()
+		if err != nil {
+			return nil, err
+		}
+
+		h := &Header{
+			FileVersion: FileVersion,
+			Radix:       e.radix,
+			FirstDigits: firstDigits,
+			BlockSize:   e.blockSize,
+			BlockID:     id,
+		}
+		if n < e.blockSize {
+			h.TotalDigits = total
+		}
+		f, err := e.writeFile(h, packed.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		if !more {
+			break
+		}
+	}
+	return files, nil
+}
+
+func (e *Encoder) writeFile(h *Header, packed []byte) (*YCDFile, error) {
+	name, w, err := e.create(h.BlockID)
+	if err != nil {
+		return nil, err
+	}
+	hn, err := h.WriteTo(w)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	if _, err := w.Write(packed); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	h.Length = int(hn) - headerTerminatorLen
+	return &YCDFile{
+		Header:           h,
+		Name:             name,
+		FirstDigitOffset: int(hn),
+	}, nil
+}
+
+// packBlock packs up to blockSize digits from dr into w.
+// The last word is padded with zeros if the block isn't aligned with words.
+func (e *Encoder) packBlock(dr *digitReader, w io.Writer) (int64, error) {
+	dpw := DigitsPerWord(e.radix)
+	radix := uint64(e.radix)
+	buf := make([]byte, WordSize)
+	n := int64(0)
+	for n < e.blockSize {
+		word := uint64(0)
+		i := 0
+		for ; i < dpw && n < e.blockSize; i++ {
+			d, err := dr.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return n, err
+			}
+			word = word*radix + uint64(d)
+			n++
+		}
+		if i == 0 {
+			break
+		}
+		for j := i; j < dpw; j++ {
+			word *= radix
+		}
+		binary.LittleEndian.PutUint64(buf, word)
+		if _, err := w.Write(buf); err != nil {
+			return n, err
+		}
+		if i < dpw {
+			break
+		}
+	}
+	return n, nil
+}
+
+// digitReader reads digits from digit text skipping whitespace.
+type digitReader struct {
+	br      *bufio.Reader
+	radix   int
+	off     int64
+	pending []byte
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// readIntegerPart reads the digits before the radix point.
+func (r *digitReader) readIntegerPart() (string, error) {
+	s := []byte{}
+	for {
+		c, err := r.br.ReadByte()
+		if err == io.EOF {
+			return "", ErrNoDigits
+		}
+		if err != nil {
+			return "", err
+		}
+		r.off++
+		if isSpace(c) {
+			continue
+		}
+		if c == '.' {
+			break
+		}
+		if _, err := r.digitValue(c); err != nil {
+			return "", err
+		}
+		s = append(s, c)
+	}
+	if len(s) == 0 {
+		return "", fmt.Errorf("%w: no integer part before the radix point", ErrInvalidDigit)
+	}
+	return string(s), nil
+}
+
+func (r *digitReader) digitValue(c byte) (byte, error) {
+	d, ok := DigitValue(c, r.radix)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q at byte offset %v", ErrInvalidDigit, c, r.off-1)
+	}
+	return d, nil
+}
+
+func (r *digitReader) readDigit() (byte, error) {
+	for {
+		c, err := r.br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		r.off++
+		if isSpace(c) {
+			continue
+		}
+		return r.digitValue(c)
+	}
+}
+
+// next returns the value of the next digit.
+func (r *digitReader) next() (byte, error) {
+	if len(r.pending) > 0 {
+		d := r.pending[0]
+		r.pending = r.pending[1:]
+		return d, nil
+	}
+	return r.readDigit()
+}
+
+// peek returns up to n digit characters without consuming them.
+// It returns fewer than n digits at the end of the input.
+func (r *digitReader) peek(n int) ([]byte, error) {
+	for len(r.pending) < n {
+		d, err := r.readDigit()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.pending = append(r.pending, d)
+	}
+	s := make([]byte, 0, n)
+	for _, d := range r.pending {
+		if len(s) == n {
+			break
+		}
+		s = append(s, "0123456789abcdef"[d])
+	}
+	return s, nil
+}
+
+// more reports whether there are more digits.
+func (r *digitReader) more() (bool, error) {
+	s, err := r.peek(1)
+	return len(s) > 0, err
+}
@@ -0,0 +1,180 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ycd_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/googlecloudplatform/pi-delivery/pkg/obj/mem"
+	"github.com/googlecloudplatform/pi-delivery/pkg/resultset"
+	"github.com/googlecloudplatform/pi-delivery/pkg/unpack"
+	"github.com/googlecloudplatform/pi-delivery/pkg/ycd"
+)
+
+// create returns a CreateFunc that stores files in bucket.
+func create(bucket *mem.Bucket, prefix string) ycd.CreateFunc {
+	return func(blockID int64) (string, io.WriteCloser, error) {
+		name := ycd.FileName(prefix, blockID)
+		return name, bucket.Create(name), nil
+	}
+}
+
+func genDigits(radix, n int) string {
+	rnd := rand.New(rand.NewSource(int64(radix*n + 1)))
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		sb.WriteByte("0123456789abcdef"[rnd.Intn(radix)])
+	}
+	return sb.String()
+}
+
+func TestEncoder_Header(t *testing.T) {
+	t.Parallel()
+
+	h := &ycd.Header{
+		Radix:       16,
+		FirstDigits: "3.243f6a8885a308d313198a2e03707344a4093822299f31d008",
+		TotalDigits: 0,
+		BlockSize:   1000000,
+		BlockID:     0,
+	}
+	buf := new(bytes.Buffer)
+	if _, err := h.WriteTo(buf); err != nil {
+		t.Fatalf("WriteTo() failed: %v", err)
+	}
+	y, err := ycd.Parse(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if got, want := y.FirstDigitOffset, buf.Len(); got != want {
+		t.Errorf("FirstDigitOffset = got %d, want %d", got, want)
+	}
+	if got, want := y.Header.Length, 192; got != want {
+		t.Errorf("Length = got %d, want %d", got, want)
+	}
+}
+
+func TestEncoder_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		radix     int
+		blockSize int64
+		n         int
+		wantFiles int
+	}{
+		{10, 100, 1, 1},
+		{10, 100, 100, 1},
+		{10, 100, 250, 3},
+		{10, 30, 100, 4},
+		{10, 38, 114, 3},
+		{16, 100, 250, 3},
+		{16, 30, 100, 4},
+		{16, 32, 96, 3},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(fmt.Sprintf("Radix %d BlockSize %d N %d", tc.radix, tc.blockSize, tc.n), func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			digits := genDigits(tc.radix, tc.n)
+			bucket := mem.NewClient().MemBucket("pi")
+			enc, err := ycd.NewEncoder(tc.radix, tc.blockSize, create(bucket, "Pi"))
+			if err != nil {
+				t.Fatalf("NewEncoder() failed: %v", err)
+			}
+			// Line breaks should be ignored.
+			files, err := enc.Encode(strings.NewReader("3.\n" + digits + "\n"))
+			if err != nil {
+				t.Fatalf("Encode() failed: %v", err)
+			}
+			if got := len(files); got != tc.wantFiles {
+				t.Fatalf("Encode() = got %d files, want %d", got, tc.wantFiles)
+			}
+
+			for _, f := range files {
+				rd, err := bucket.Object(f.Name).NewRangeReader(ctx, 0, -1)
+				if err != nil {
+					t.Fatalf("NewRangeReader(%s) failed: %v", f.Name, err)
+				}
+				got, err := ycd.Parse(rd)
+				rd.Close()
+				if err != nil {
+					t.Fatalf("Parse(%s) failed: %v", f.Name, err)
+				}
+				got.Name = f.Name
+				if diff := cmp.Diff(f, got); diff != "" {
+					t.Errorf("Parse(%s) = (-want, +got):\n%s", f.Name, diff)
+				}
+			}
+
+			set := resultset.ResultSet(files)
+			if got, want := set.TotalDigits(), int64(tc.n); got != want {
+				t.Errorf("TotalDigits() = got %d, want %d", got, want)
+			}
+			rd := set.NewReader(ctx, bucket)
+			t.Cleanup(func() {
+				if err := rd.Close(); err != nil {
+					t.Errorf("Close() failed: %v", err)
+				}
+			})
+			got, err := io.ReadAll(unpack.NewReader(ctx, rd))
+			if err != nil {
+				t.Errorf("ReadAll() failed: %v", err)
+			}
+			if diff := cmp.Diff(digits, string(got)); diff != "" {
+				t.Errorf("ReadAll() = (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEncoder_Errors(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		radix   int
+		in      string
+		wantErr error
+	}{
+		{"empty", 10, "", ycd.ErrNoDigits},
+		{"integer only", 10, "3.", ycd.ErrNoDigits},
+		{"no integer part", 10, ".14", ycd.ErrInvalidDigit},
+		{"hex digit in decimal", 10, "3.14a", ycd.ErrInvalidDigit},
+		{"invalid character", 16, "3.24x", ycd.ErrInvalidDigit},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			enc, err := ycd.NewEncoder(tc.radix, 100, create(mem.NewClient().MemBucket("pi"), "Pi"))
+			if err != nil {
+				t.Fatalf("NewEncoder() failed: %v", err)
+			}
+			if _, err := enc.Encode(strings.NewReader(tc.in)); !errors.Is(err, tc.wantErr) {
+				t.Errorf("Encode(%q) = got error %v, want %v", tc.in, err, tc.wantErr)
+			}
+		})
+	}
+}
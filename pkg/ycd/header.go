@@ -16,11 +16,46 @@ package ycd
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"strconv"
 	"strings"
 )
 
+// FileVersion is the ycd file version this package reads and writes.
+const FileVersion = "1.1.0"
+
+var ErrUnknownVersion = errors.New("ycd: unknown file version")
+
+// SyntaxError is returned when a header is malformed.
+type SyntaxError struct {
+	// Line is the line number starting at 1.
+	Line int
+	// Column is the byte column in the line starting at 1.
+	Column int
+	// Msg describes the problem.
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("ycd: header line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+// HeaderTooLargeError is returned when a header doesn't end within
+// ParseOptions.MaxHeaderLength bytes.
+type HeaderTooLargeError struct {
+	// Limit is the maximum header length that was exceeded.
+	Limit int
+}
+
+func (e *HeaderTooLargeError) Error() string {
+	return fmt.Sprintf("ycd: header is larger than %d bytes", e.Limit)
+}
+
+// firstDigitsLen is the number of digits after the radix point in FirstDigits.
+const firstDigitsLen = 50
+
 type Header struct {
 	// FileVersion is the version of the ycd file.
 	// Currently it's 1.1.0 and this code is tested against the version.
@@ -47,25 +82,41 @@ type Header struct {
 	// Length is the total byte length of the header in the file.
 	// It is the offset of the empty line after EndHeader.
 	Length int
+
+	// Extra has the header fields this package doesn't know about.
+	// It's only populated when ParseOptions.AllowUnknownKeys is set.
+	Extra map[string]string
 }
 
 func parseInt64(s string) (int64, error) {
 	return strconv.ParseInt(s, 10, 64)
 }
 
-func (h *Header) validate() error {
-	if h.FileVersion != "1.1.0" {
-		return fmt.Errorf("unknown file version: %s", h.FileVersion)
+func (h *Header) validate(check VersionCheck) error {
+	switch check {
+	case VersionStrict:
+		if h.FileVersion != FileVersion {
+			return fmt.Errorf("%w: %s", ErrUnknownVersion, h.FileVersion)
+		}
+	case VersionLenient:
+		major := strings.SplitN(FileVersion, ".", 2)[0]
+		if !strings.HasPrefix(h.FileVersion, major+".") {
+			return fmt.Errorf("%w: %s", ErrUnknownVersion, h.FileVersion)
+		}
 	}
 	if h.Radix != 10 && h.Radix != 16 {
 		return fmt.Errorf("unknown radix: %v", h.Radix)
 	}
+	if h.BlockSize <= 0 {
+		return fmt.Errorf("invalid block size: %v", h.BlockSize)
+	}
 	return nil
 }
 
-func parseHeader(reader *bufio.Reader) (*Header, error) {
+func parseHeader(reader *bufio.Reader, opts *ParseOptions) (*Header, error) {
 	var h Header
 	length := 0
+	lineNo := 1
 
 	line, err := reader.ReadString('\n')
 	if err != nil {
@@ -73,7 +124,11 @@ func parseHeader(reader *bufio.Reader) (*Header, error) {
 	}
 	length += len(line)
 	if strings.TrimSpace(line) != "#Compressed Digit File" {
-		return nil, fmt.Errorf("first line should be '#Compressed Digit File': %s", strings.TrimSpace(line))
+		return nil, &SyntaxError{
+			Line:   lineNo,
+			Column: 1,
+			Msg:    fmt.Sprintf("first line should be '#Compressed Digit File': %s", strings.TrimSpace(line)),
+		}
 	}
 
 	for {
@@ -81,18 +136,35 @@ func parseHeader(reader *bufio.Reader) (*Header, error) {
 		if err != nil {
 			return nil, err
 		}
+		lineNo++
 		length += len(line)
-		// The delimiter is \r\n so it's two runes.
-		if len(line) == 2 {
+		// The delimiter is \r\n so empty lines are two runes.
+		if strings.TrimSpace(line) == "" {
 			continue
 		}
-		tokens := strings.Split(line, ":")
+		tokens := strings.SplitN(line, ":", 2)
 		key := strings.TrimSpace(tokens[0])
 		if key == "EndHeader" {
 			break
 		}
+		if len(tokens) < 2 {
+			return nil, &SyntaxError{
+				Line:   lineNo,
+				Column: len(strings.TrimRight(line, "\r\n")) + 1,
+				Msg:    fmt.Sprintf("missing ':' after key %s", key),
+			}
+		}
 
+		// Column of the first non-space character of the value.
+		valueCol := len(tokens[0]) + 2 + len(tokens[1]) - len(strings.TrimLeft(tokens[1], " \t"))
 		value := strings.TrimSpace(tokens[1])
+		intErr := func(err error) error {
+			return &SyntaxError{
+				Line:   lineNo,
+				Column: valueCol,
+				Msg:    fmt.Sprintf("invalid value for %s: %v", key, err),
+			}
+		}
 		switch key {
 		case "FileVersion":
 			h.FileVersion = value
@@ -100,7 +172,7 @@ func parseHeader(reader *bufio.Reader) (*Header, error) {
 			if i, err := strconv.Atoi(value); err == nil {
 				h.Radix = i
 			} else {
-				return nil, err
+				return nil, intErr(err)
 			}
 		case "FirstDigits":
 			h.FirstDigits = value
@@ -108,28 +180,65 @@ func parseHeader(reader *bufio.Reader) (*Header, error) {
 			if i, err := parseInt64(value); err == nil {
 				h.TotalDigits = i
 			} else {
-				return nil, err
+				return nil, intErr(err)
 			}
 		case "Blocksize":
 			if i, err := parseInt64(value); err == nil {
 				h.BlockSize = i
 			} else {
-				return nil, err
+				return nil, intErr(err)
 			}
 		case "BlockID":
 			if i, err := parseInt64(value); err == nil {
 				h.BlockID = i
 			} else {
-				return nil, err
+				return nil, intErr(err)
 			}
 		default:
-			return nil, fmt.Errorf("unknown header key: %s", key)
+			if !opts.AllowUnknownKeys {
+				return nil, &SyntaxError{
+					Line:   lineNo,
+					Column: 1,
+					Msg:    fmt.Sprintf("unknown header key: %s", key),
+				}
+			}
+			if h.Extra == nil {
+				h.Extra = make(map[string]string)
+			}
+			h.Extra[key] = value
 		}
 	}
-	if err := h.validate(); err != nil {
+	if err := h.validate(opts.VersionCheck); err != nil {
 		return nil, err
 	}
 
 	h.Length = length
 	return &h, nil
 }
+
+// WriteTo writes the header in the y-cruncher format to w.
+// The output includes the empty line and the NUL terminator after EndHeader,
+// so the next byte written to w is the first byte of the packed digits.
+// FileVersion defaults to the current version if it's empty.
+// WriteTo doesn't update Length.
+func (h *Header) WriteTo(w io.Writer) (int64, error) {
+	version := h.FileVersion
+	if version == "" {
+		version = FileVersion
+	}
+	s := "#Compressed Digit File\r\n\r\n" +
+		"FileVersion:\t" + version + "\r\n\r\n" +
+		"Base:\t" + strconv.Itoa(h.Radix) + "\r\n\r\n" +
+		"FirstDigits:\t" + h.FirstDigits + "\r\n\r\n" +
+		"TotalDigits:\t" + strconv.FormatInt(h.TotalDigits, 10) + "\r\n\r\n" +
+		"Blocksize:\t" + strconv.FormatInt(h.BlockSize, 10) + "\r\n" +
+		"BlockID:\t" + strconv.FormatInt(h.BlockID, 10) + "\r\n\r\n" +
+		"EndHeader\r\n" +
+		"\r\n\x00"
+	n, err := io.WriteString(w, s)
+	return int64(n), err
+}
+
+// headerTerminatorLen is the length of the empty line and the NUL character
+// between EndHeader and the first digit.
+const headerTerminatorLen = 3
@@ -39,13 +39,81 @@ func DigitsPerWord(radix int) int {
 	}
 }
 
+// DigitValue returns the value of the digit character c in radix.
+// Hexadecimal digits may be in either case. It returns false if c isn't
+// a digit in radix.
+func DigitValue(c byte, radix int) (byte, bool) {
+	var d byte
+	switch {
+	case '0' <= c && c <= '9':
+		d = c - '0'
+	case 'a' <= c && c <= 'f':
+		d = c - 'a' + 10
+	case 'A' <= c && c <= 'F':
+		d = c - 'A' + 10
+	default:
+		return 0, false
+	}
+	if int(d) >= radix {
+		return 0, false
+	}
+	return d, true
+}
+
+// VersionCheck specifies how Parse checks FileVersion.
+type VersionCheck int
+
+const (
+	// VersionStrict accepts FileVersion only.
+	VersionStrict VersionCheck = iota
+	// VersionLenient accepts any version with the same major version as FileVersion.
+	VersionLenient
+)
+
+// ParseOptions are options for ParseWithOptions.
+type ParseOptions struct {
+	// MaxHeaderLength is the maximum byte length of the header
+	// including the NUL terminator. Zero means no limit.
+	MaxHeaderLength int
+
+	// VersionCheck specifies how FileVersion is checked.
+	VersionCheck VersionCheck
+
+	// AllowUnknownKeys stores unknown header keys in Header.Extra
+	// instead of returning an error.
+	AllowUnknownKeys bool
+}
+
 // Parse parses the header of a ycd file and returns the field values.
+// It's the same as ParseWithOptions with the zero ParseOptions.
 func Parse(reader io.Reader) (*YCDFile, error) {
-	br := bufio.NewReader(reader)
+	return ParseWithOptions(reader, ParseOptions{})
+}
+
+// ParseWithOptions parses the header of a ycd file with opts.
+// It returns *SyntaxError for malformed headers and *HeaderTooLargeError
+// if the header doesn't end within opts.MaxHeaderLength bytes.
+func ParseWithOptions(reader io.Reader, opts ParseOptions) (*YCDFile, error) {
+	var lr *io.LimitedReader
+	if opts.MaxHeaderLength > 0 {
+		lr = &io.LimitedReader{R: reader, N: int64(opts.MaxHeaderLength)}
+		reader = lr
+	}
+	y, err := parse(bufio.NewReader(reader), &opts)
+	if err == io.EOF {
+		if lr != nil && lr.N == 0 {
+			return nil, &HeaderTooLargeError{Limit: opts.MaxHeaderLength}
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+	return y, err
+}
+
+func parse(br *bufio.Reader, opts *ParseOptions) (*YCDFile, error) {
 	y := new(YCDFile)
 
 	// First parse the header
-	if h, err := parseHeader(br); err == nil {
+	if h, err := parseHeader(br, opts); err == nil {
 		y.Header = h
 	} else {
 		return nil, err
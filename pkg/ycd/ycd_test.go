@@ -15,7 +15,9 @@
 package ycd
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"strings"
 	"testing"
 
@@ -98,6 +100,7 @@ func TestYCD_Parse(t *testing.T) {
 		},
 	}
 	for _, tc := range testCases {
+		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 			got, err := Parse(strings.NewReader(tc.raw))
@@ -130,3 +133,123 @@ func TestYCD_DigitsPerWord(t *testing.T) {
 		})
 	}
 }
+
+func TestYCD_ParseWithOptions(t *testing.T) {
+	t.Parallel()
+
+	crlf := func(s string) string {
+		return strings.ReplaceAll(s, "\n", "\r\n") + "\x00"
+	}
+	withExtra := crlf(strings.Replace(rawTestDataHex, "EndHeader", "Checksum:\tabc\n\nEndHeader", 1))
+	newVersion := crlf(strings.Replace(rawTestDataHex, "1.1.0", "1.2.0", 1))
+
+	testCases := []struct {
+		name      string
+		raw       string
+		opts      ParseOptions
+		wantExtra map[string]string
+		wantErr   error
+		wantLine  int
+		wantCol   int
+		wantLimit int
+	}{
+		{
+			name: "default",
+			raw:  crlf(rawTestDataHex),
+		},
+		{
+			name: "fits in the limit",
+			raw:  crlf(rawTestDataHex),
+			opts: ParseOptions{MaxHeaderLength: 195},
+		},
+		{
+			name:      "too large",
+			raw:       crlf(rawTestDataHex),
+			opts:      ParseOptions{MaxHeaderLength: 194},
+			wantLimit: 194,
+		},
+		{
+			name:    "truncated",
+			raw:     crlf(rawTestDataHex)[:100],
+			opts:    ParseOptions{MaxHeaderLength: 1024},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:     "missing colon",
+			raw:      crlf(strings.Replace(rawTestDataHex, "Base:\t16", "Base 16", 1)),
+			wantLine: 5,
+			wantCol:  8,
+		},
+		{
+			name:     "invalid number",
+			raw:      crlf(strings.Replace(rawTestDataHex, "Blocksize:\t1000000", "Blocksize:\t1e6", 1)),
+			wantLine: 11,
+			wantCol:  12,
+		},
+		{
+			name:     "unknown key",
+			raw:      withExtra,
+			wantLine: 14,
+			wantCol:  1,
+		},
+		{
+			name:      "unknown key allowed",
+			raw:       withExtra,
+			opts:      ParseOptions{AllowUnknownKeys: true},
+			wantExtra: map[string]string{"Checksum": "abc"},
+		},
+		{
+			name:    "strict version",
+			raw:     newVersion,
+			wantErr: ErrUnknownVersion,
+		},
+		{
+			name: "lenient version",
+			raw:  newVersion,
+			opts: ParseOptions{VersionCheck: VersionLenient},
+		},
+		{
+			name:    "lenient version with a different major version",
+			raw:     crlf(strings.Replace(rawTestDataHex, "1.1.0", "2.0.0", 1)),
+			opts:    ParseOptions{VersionCheck: VersionLenient},
+			wantErr: ErrUnknownVersion,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseWithOptions(strings.NewReader(tc.raw), tc.opts)
+			var syntaxErr *SyntaxError
+			var sizeErr *HeaderTooLargeError
+			switch {
+			case tc.wantLine > 0:
+				if !errors.As(err, &syntaxErr) {
+					t.Fatalf("ParseWithOptions() = got error %v, want *SyntaxError", err)
+				}
+				if syntaxErr.Line != tc.wantLine || syntaxErr.Column != tc.wantCol {
+					t.Errorf("ParseWithOptions() = got line %d column %d, want line %d column %d",
+						syntaxErr.Line, syntaxErr.Column, tc.wantLine, tc.wantCol)
+				}
+			case tc.wantLimit > 0:
+				if !errors.As(err, &sizeErr) {
+					t.Fatalf("ParseWithOptions() = got error %v, want *HeaderTooLargeError", err)
+				}
+				if sizeErr.Limit != tc.wantLimit {
+					t.Errorf("HeaderTooLargeError.Limit = got %d, want %d", sizeErr.Limit, tc.wantLimit)
+				}
+			case tc.wantErr != nil:
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("ParseWithOptions() = got error %v, want %v", err, tc.wantErr)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("ParseWithOptions() failed: %v", err)
+				}
+				if diff := cmp.Diff(tc.wantExtra, got.Header.Extra); diff != "" {
+					t.Errorf("Header.Extra = (-want, +got):\n%s", diff)
+				}
+			}
+		})
+	}
+}